@@ -2,22 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/larrydiffey/difpipe/pkg/benchmark"
+	"github.com/larrydiffey/difpipe/pkg/codec"
 	"github.com/larrydiffey/difpipe/pkg/config"
 	"github.com/larrydiffey/difpipe/pkg/core"
 	"github.com/larrydiffey/difpipe/pkg/orchestrator"
 	"github.com/larrydiffey/difpipe/pkg/output"
 	"github.com/larrydiffey/difpipe/pkg/status"
+	"github.com/larrydiffey/difpipe/pkg/transport"
 	"github.com/spf13/cobra"
 )
 
 var (
 	// Global flags
-	configFile   string
-	outputFormat string
-	verbose      bool
+	configFile    string
+	outputFormat  string
+	verbose       bool
+	hostKeyPolicy string
 
 	// Root command
 	rootCmd = &cobra.Command{
@@ -45,7 +51,57 @@ Examples:
   difpipe transfer --config transfer.yaml
 
   # Transfer with stdin config
-  echo '{"source":"/data","dest":"s3://backup"}' | difpipe transfer --config -`,
+  echo '{"source":"/data","dest":"s3://backup"}' | difpipe transfer --config -
+
+  # Write a single archive instead of copying the tree
+  difpipe transfer /data --archive-to /backup/data.tar.gz
+
+  # Pull a remote tree into a local directory with tar
+  difpipe transfer root@server:/data /restore --strategy tar
+
+Local tar transfers extract into the destination directory, keeping modes,
+modification times, symlinks and hard links. --archive-to writes one tar
+file instead, compressed by its extension (.gz, .tgz, .zst, .tzst, .lz4)
+or --compression.
+Remote sources are pulled into a local directory: tar runs on the source
+host and the stream is extracted locally. Excludes on base names are passed
+to the remote tar, other filters are applied while extracting. Pushes to a
+remote host are split into --parallel streams of similar size, each over
+its own SSH connection, which fills high-latency links better.
+
+--preserve selects further metadata for tar transfers: hardlinks, sparse
+(holes are recreated instead of written as zeros), xattrs, acls, all or
+none. Remote hosts need GNU tar for xattrs and ACLs.
+
+Streams to remote hosts are compressed with zstd, lz4 or gzip. Each host is
+probed for the tools; with auto, or when the requested codec is missing,
+the first of zstd, lz4, gzip every host has is used. --compression-level
+sets the level (config: compression_level).
+
+Remote-to-remote file copies go over SFTP into a part file next to the
+destination, renamed over it once complete, keeping the source's mode and
+modification time. An explicit --compression codec, or a host without SFTP,
+uses shell commands instead. Copies are checked with SHA-256: the stream is
+hashed as it passes and compared with a hash of the copy on the
+destination. A mismatch exits with code 31. --verify=false skips the check.
+Files of 128MB and more are split into byte ranges copied over --parallel
+connection pairs (over the shell this needs GNU dd on both hosts).
+
+Hosts reachable only through bastions take --source-jump and --dest-jump,
+user@host[:port] hops tried in order, each reached through the one before
+(like ssh -J). Jump hosts log in with the SSH agent or default keys; the
+config file sets auth per hop under source.jump and destination.jump.
+
+HostName, Port, User, IdentityFile, ProxyJump and ConnectTimeout from
+~/.ssh/config apply to SSH hosts; hosts named there can be written as
+alias:path.
+
+SSH host keys are checked against ~/.ssh/known_hosts and
+~/.difpipe/known_hosts. --host-key-policy tofu (the default) records the
+keys of hosts seen for the first time in ~/.difpipe/known_hosts; strict
+only connects to hosts already listed; insecure skips the check. A key that
+differs from the one on record fails with exit code 11, as does an unknown
+host under strict (config: host_key_policy).`,
 		Args: cobra.MaximumNArgs(2),
 		RunE: runTransfer,
 	}
@@ -61,7 +117,40 @@ Returns:
 - File counts and sizes
 - Average file size
 - File type distribution
-- Recommended strategy with explanation`,
+- Content types and families detected from file headers
+- Compressibility and whether compression is worthwhile
+- Recommended strategy with explanation
+
+When a destination is given with --delta, the destination is enumerated
+and only new or changed files count towards the recommendation.
+
+With --config, thresholds and rules from the config file are applied.
+
+Analysis of local directories is cached under ~/.difpipe/analysis. Every
+directory is listed and its files stat'ed, but only directories whose
+files, sizes or modification times changed are read again. The output
+shows the cache age; use --no-cache to rescan everything.
+
+With --duplicates, sampled files are hashed (by size, then partial, then
+full hash) to report duplicate groups, duplicate bytes and the dedup ratio.
+This reads file contents and bypasses the cache.
+
+With --explain, every rule and threshold evaluated is listed with the
+measured value and threshold, along with why each alternative strategy
+was rejected.
+
+With --compare, every engine that supports the source and destination
+protocols estimates the transfer. The table lists estimated time, bytes on
+the wire after compression, resume support and the binaries each strategy
+needs, marking local binaries missing from PATH.
+
+Files modified within --settle-window (default 1m) are reported as possibly
+still being written, e.g. logs being appended. Tar transfers detect files
+that change while being read, send them again at the end and list those
+that kept changing.
+
+--source-jump and --dest-jump reach hosts through bastions, as for
+transfer.`,
 		Args: cobra.RangeArgs(1, 2),
 		RunE: runAnalyze,
 	}
@@ -85,6 +174,22 @@ If no ID is provided, shows all recent transfers.`,
 		RunE: runStatus,
 	}
 
+	// Benchmark command
+	benchmarkCmd = &cobra.Command{
+		Use:   "benchmark [endpoint]",
+		Short: "Measure throughput to calibrate estimates",
+		Long: `Measure throughput for an endpoint and store the results per host
+under ~/.difpipe/benchmarks. Estimates use stored results instead of
+assumed speeds.
+
+Local paths measure disk read throughput and per-file overhead.
+SSH endpoints (user@host:path) measure stream throughput in both directions
+and per-file overhead of a remote tar stream. Set DIFPIPE_SOURCE_PASSWORD
+for password authentication.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runBenchmark,
+	}
+
 	// Version command (already handled by cobra)
 )
 
@@ -93,16 +198,39 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file (JSON/YAML), use '-' for stdin")
 	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "output format: text, json, yaml, csv")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().StringVar(&hostKeyPolicy, "host-key-policy", "", "SSH host key checking: tofu (default), strict, insecure")
 
 	// Transfer flags
 	transferCmd.Flags().String("strategy", "auto", "transfer strategy: auto, rclone, rsync, tar")
 	transferCmd.Flags().Int("parallel", 4, "number of parallel transfers")
 	transferCmd.Flags().Bool("checkpoint", true, "enable checkpoint/resume")
-	transferCmd.Flags().String("compression", "auto", "compression: auto, none, zstd, gzip")
+	transferCmd.Flags().Bool("verify", true, "compare a SHA-256 of remote-to-remote file copies with the source")
+	transferCmd.Flags().String("compression", "auto", "compression: auto, none, zstd, lz4, gzip")
+	transferCmd.Flags().Int("compression-level", 0, "compression level, 0 for the codec default (gzip/lz4 1-9, zstd 1-19)")
 	transferCmd.Flags().Bool("dry-run", false, "perform dry run without actual transfer")
 	transferCmd.Flags().StringSlice("include", []string{}, "include patterns")
 	transferCmd.Flags().StringSlice("exclude", []string{}, "exclude patterns")
 	transferCmd.Flags().Bool("stream", false, "stream progress as newline-delimited JSON")
+	transferCmd.Flags().Bool("delta", false, "scan destination and base strategy on new/changed files")
+	transferCmd.Flags().Bool("no-cache", false, "rescan the source instead of reusing cached analysis")
+	transferCmd.Flags().String("archive-to", "", "write a tar archive to this file instead of copying (uses tar)")
+	transferCmd.Flags().StringSlice("preserve", []string{}, "metadata tar transfers keep: hardlinks, sparse, xattrs, acls, all, none (default hardlinks)")
+	transferCmd.Flags().StringSlice("source-jump", []string{}, "jump hosts to the source, user@host[:port] in order")
+	transferCmd.Flags().StringSlice("dest-jump", []string{}, "jump hosts to the destination, user@host[:port] in order")
+
+	// Analyze flags
+	analyzeCmd.Flags().Bool("delta", false, "scan destination and report new/changed/unchanged/extra files")
+	analyzeCmd.Flags().Bool("explain", false, "show every rule and threshold evaluated for the recommendation")
+	analyzeCmd.Flags().Bool("no-cache", false, "rescan the source instead of reusing cached analysis")
+	analyzeCmd.Flags().Bool("duplicates", false, "hash sampled files and report duplicate content")
+	analyzeCmd.Flags().Bool("compare", false, "estimate the transfer with every supporting strategy")
+	analyzeCmd.Flags().Duration("settle-window", time.Minute, "report files modified more recently than this as still being written (0 disables)")
+	analyzeCmd.Flags().StringSlice("source-jump", []string{}, "jump hosts to the source, user@host[:port] in order")
+	analyzeCmd.Flags().StringSlice("dest-jump", []string{}, "jump hosts to the destination, user@host[:port] in order")
+
+	// Benchmark flags
+	benchmarkCmd.Flags().Int("size-mb", 256, "data to move for throughput measurements in MB")
+	benchmarkCmd.Flags().Int("files", 500, "number of small files for per-file overhead")
 
 	// Status flags
 	statusCmd.Flags().String("state", "", "filter by state: queued, running, completed, failed")
@@ -112,6 +240,7 @@ func init() {
 	rootCmd.AddCommand(analyzeCmd)
 	rootCmd.AddCommand(schemaCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(benchmarkCmd)
 }
 
 func main() {
@@ -120,7 +249,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Warning: failed to initialize status tracker: %v\n", err)
 	}
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	transport.Shared().Shutdown()
+	if err != nil {
 		os.Exit(core.ExitGeneralError)
 	}
 }
@@ -129,6 +260,12 @@ func main() {
 func runTransfer(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
+	// An archive stands in for the destination
+	archiveTo, _ := cmd.Flags().GetString("archive-to")
+	if archiveTo != "" && len(args) == 1 {
+		args = append(args, archiveTo)
+	}
+
 	// Load configuration
 	cfg, err := loadConfig(args)
 	if err != nil {
@@ -137,9 +274,17 @@ func runTransfer(cmd *cobra.Command, args []string) error {
 
 	// Override with flags
 	applyFlags(cmd, cfg)
+	if err := setHostKeyPolicy(cmd, cfg); err != nil {
+		return exitWithError(core.ExitConfigError, "load config", err)
+	}
 
 	// Create orchestrator
 	orch := orchestrator.New()
+	if status.GlobalTracker != nil {
+		orch.WithTracker(status.GlobalTracker)
+	}
+	noCache, _ := cmd.Flags().GetBool("no-cache")
+	orch.WithCache(!noCache)
 
 	// Set up progress reporting if streaming
 	streamFlag, _ := cmd.Flags().GetBool("stream")
@@ -149,30 +294,50 @@ func runTransfer(cmd *cobra.Command, args []string) error {
 		_ = streamWriter
 	}
 
+	rules, err := convertRules(cfg.Transfer.Options.Rules)
+	if err != nil {
+		return exitWithError(core.ExitConfigError, "load config", err)
+	}
+	if _, err := codec.New(core.Compression(cfg.Transfer.Options.Compression), cfg.Transfer.Options.CompressionLevel); err != nil {
+		return exitWithError(core.ExitConfigError, "load config", err)
+	}
+	preserve, err := core.ParsePreserve(cfg.Transfer.Options.Preserve)
+	if err != nil {
+		return exitWithError(core.ExitConfigError, "load config", err)
+	}
+
 	// Build transfer options
 	opts := &core.TransferOptions{
 		Source:      cfg.Transfer.Source.Path,
 		Destination: cfg.Transfer.Destination.Path,
 		Strategy:    core.Strategy(cfg.Transfer.Options.Strategy),
 		Compression: core.Compression(cfg.Transfer.Options.Compression),
+		CompressionLevel: cfg.Transfer.Options.CompressionLevel,
 		Parallel:    cfg.Transfer.Options.Parallel,
 		Checkpoint:  cfg.Transfer.Options.Checkpoint,
+		Verify:      cfg.Transfer.Options.Verify,
 		DryRun:      cfg.Transfer.Options.DryRun,
 		Filters: &core.FilterOptions{
 			Include: cfg.Transfer.Filters.Include,
 			Exclude: cfg.Transfer.Filters.Exclude,
 		},
 		Auth: &core.AuthOptions{
-			SourceAuth: cfg.Transfer.Source.Auth,
-			DestAuth:   cfg.Transfer.Destination.Auth,
+			SourceAuth:  cfg.Transfer.Source.Auth,
+			DestAuth:    cfg.Transfer.Destination.Auth,
+			SourceJumps: config.JumpHosts(cfg.Transfer.Source.Jump),
+			DestJumps:   config.JumpHosts(cfg.Transfer.Destination.Jump),
 		},
 		Thresholds: convertThresholds(cfg.Transfer.Options.Thresholds),
+		Rules:      rules,
+		ScanDestination: cfg.Transfer.Options.ScanDestination,
+		ArchiveTo:       archiveTo,
+		Preserve:        &preserve,
 	}
 
 	// Perform transfer
 	result, err := orch.Transfer(ctx, opts)
 	if err != nil {
-		return exitWithError(core.ExitTransferFailed, "transfer", err)
+		return exitWithError(exitCodeFor(err, core.ExitTransferFailed), "transfer", err)
 	}
 
 	// Format output
@@ -185,19 +350,97 @@ func runAnalyze(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 
 	source := args[0]
+	delta, _ := cmd.Flags().GetBool("delta")
+	explain, _ := cmd.Flags().GetBool("explain")
+	duplicates, _ := cmd.Flags().GetBool("duplicates")
+	compare, _ := cmd.Flags().GetBool("compare")
+
+	if delta && len(args) < 2 {
+		return exitWithError(core.ExitConfigError, "analyze", fmt.Errorf("--delta requires a destination"))
+	}
+	if compare && len(args) < 2 {
+		return exitWithError(core.ExitConfigError, "analyze", fmt.Errorf("--compare requires a destination"))
+	}
 
 	// Create orchestrator
 	orch := orchestrator.New()
+	if status.GlobalTracker != nil {
+		orch.WithTracker(status.GlobalTracker)
+	}
+	noCache, _ := cmd.Flags().GetBool("no-cache")
+	orch.WithCache(!noCache)
+	settleWindow, _ := cmd.Flags().GetDuration("settle-window")
+	orch.WithExplain(explain).WithDuplicates(duplicates).WithSettleWindow(settleWindow)
+
+	opts := &core.TransferOptions{
+		Source:          source,
+		ScanDestination: delta,
+	}
+
+	// Thresholds, rules and endpoint auth come from the config file when
+	// one is given
+	cfg := &config.Config{}
+	if configFile != "" {
+		var err error
+		cfg, err = config.LoadConfig(configFile)
+		if err != nil {
+			return exitWithError(core.ExitConfigError, "load config", err)
+		}
+		opts.Thresholds = convertThresholds(cfg.Transfer.Options.Thresholds)
+		opts.Rules, err = convertRules(cfg.Transfer.Options.Rules)
+		if err != nil {
+			return exitWithError(core.ExitConfigError, "load config", err)
+		}
+	}
+	applyJumpFlags(cmd, cfg)
+	if err := setHostKeyPolicy(cmd, cfg); err != nil {
+		return exitWithError(core.ExitConfigError, "load config", err)
+	}
+	opts.Auth = &core.AuthOptions{
+		SourceAuth:  cfg.Transfer.Source.Auth,
+		DestAuth:    cfg.Transfer.Destination.Auth,
+		SourceJumps: config.JumpHosts(cfg.Transfer.Source.Jump),
+		DestJumps:   config.JumpHosts(cfg.Transfer.Destination.Jump),
+	}
 
 	// Analyze
-	analysis, err := orch.Analyze(ctx, source)
+	var analysis *core.FileAnalysis
+	var err error
+	if compare {
+		opts.Destination = args[1]
+		analysis, err = orch.Compare(ctx, opts)
+	} else if len(args) == 2 {
+		opts.Destination = args[1]
+		analysis, err = orch.AnalyzeTransfer(ctx, opts)
+	} else {
+		analysis, err = orch.Analyze(ctx, source)
+	}
 	if err != nil {
-		return exitWithError(core.ExitGeneralError, "analyze", err)
+		return exitWithError(exitCodeFor(err, core.ExitGeneralError), "analyze", err)
 	}
 
 	// Format output
 	format := output.Format(outputFormat)
 	formatter := output.New(format, os.Stdout)
+
+	// Text output lists the explanation and comparison after the analysis
+	if format == output.FormatText && (analysis.Explanation != nil || analysis.Comparison != nil) {
+		explanation, comparison := analysis.Explanation, analysis.Comparison
+		analysis.Explanation, analysis.Comparison = nil, nil
+		if err := formatter.Format(analysis); err != nil {
+			return err
+		}
+		if explanation != nil {
+			if err := formatter.Format(explanation.String()); err != nil {
+				return err
+			}
+		}
+		if comparison != nil {
+			return formatter.Format(comparison.String())
+		}
+		return nil
+	}
+
 	return formatter.Format(analysis)
 }
 
@@ -214,14 +457,16 @@ func runSchema(cmd *cobra.Command, args []string) error {
         "source": {
           "type": "object",
           "properties": {
-            "path": {"type": "string"}
+            "path": {"type": "string"},
+            "jump": {"$ref": "#/definitions/jump"}
           },
           "required": ["path"]
         },
         "destination": {
           "type": "object",
           "properties": {
-            "path": {"type": "string"}
+            "path": {"type": "string"},
+            "jump": {"$ref": "#/definitions/jump"}
           },
           "required": ["path"]
         },
@@ -231,8 +476,28 @@ func runSchema(cmd *cobra.Command, args []string) error {
             "strategy": {"type": "string", "enum": ["auto", "rclone", "rsync", "tar"]},
             "parallel": {"type": "integer", "minimum": 1},
             "checkpoint": {"type": "boolean"},
-            "compression": {"type": "string", "enum": ["auto", "none", "zstd", "gzip"]},
-            "dry_run": {"type": "boolean"}
+            "verify": {"type": "boolean"},
+            "compression": {"type": "string", "enum": ["auto", "none", "zstd", "lz4", "gzip"]},
+            "compression_level": {"type": "integer", "minimum": 0, "maximum": 19},
+            "dry_run": {"type": "boolean"},
+            "scan_destination": {"type": "boolean"},
+            "preserve": {"type": "array", "items": {"type": "string", "enum": ["hardlinks", "sparse", "xattrs", "acls", "all", "none"]}},
+            "host_key_policy": {"type": "string", "enum": ["tofu", "strict", "insecure"]},
+            "rules": {
+              "type": "array",
+              "items": {
+                "type": "object",
+                "properties": {
+                  "name": {"type": "string"},
+                  "strategy": {"type": "string", "enum": ["rclone", "rsync", "tar", "proxy"]},
+                  "staging": {"type": "string"},
+                  "staging_auth": {"type": "object"},
+                  "staging_jump": {"$ref": "#/definitions/jump"},
+                  "match": {"type": "object"}
+                },
+                "required": ["strategy"]
+              }
+            }
           }
         }
       },
@@ -246,7 +511,20 @@ func runSchema(cmd *cobra.Command, args []string) error {
       }
     }
   },
-  "required": ["transfer"]
+  "required": ["transfer"],
+  "definitions": {
+    "jump": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "host": {"type": "string"},
+          "auth": {"type": "object"}
+        },
+        "required": ["host"]
+      }
+    }
+  }
 }`
 
 	fmt.Println(schema)
@@ -278,6 +556,7 @@ func loadConfig(args []string) (*config.Config, error) {
 					Strategy:    "auto",
 					Parallel:    4,
 					Checkpoint:  true,
+					Verify:      true,
 					Compression: "auto",
 				},
 			},
@@ -310,14 +589,30 @@ func applyFlags(cmd *cobra.Command, cfg *config.Config) {
 		checkpoint, _ := cmd.Flags().GetBool("checkpoint")
 		cfg.Transfer.Options.Checkpoint = checkpoint
 	}
+	if cmd.Flags().Changed("verify") {
+		verify, _ := cmd.Flags().GetBool("verify")
+		cfg.Transfer.Options.Verify = verify
+	}
 	if cmd.Flags().Changed("compression") {
 		compression, _ := cmd.Flags().GetString("compression")
 		cfg.Transfer.Options.Compression = compression
 	}
+	if cmd.Flags().Changed("compression-level") {
+		level, _ := cmd.Flags().GetInt("compression-level")
+		cfg.Transfer.Options.CompressionLevel = level
+	}
+	if cmd.Flags().Changed("preserve") {
+		preserve, _ := cmd.Flags().GetStringSlice("preserve")
+		cfg.Transfer.Options.Preserve = preserve
+	}
 	if cmd.Flags().Changed("dry-run") {
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		cfg.Transfer.Options.DryRun = dryRun
 	}
+	if cmd.Flags().Changed("delta") {
+		delta, _ := cmd.Flags().GetBool("delta")
+		cfg.Transfer.Options.ScanDestination = delta
+	}
 	if cmd.Flags().Changed("include") {
 		include, _ := cmd.Flags().GetStringSlice("include")
 		cfg.Transfer.Filters.Include = include
@@ -329,6 +624,44 @@ func applyFlags(cmd *cobra.Command, cfg *config.Config) {
 	if cmd.Flags().Changed("output") {
 		cfg.Output.Format = outputFormat
 	}
+	applyJumpFlags(cmd, cfg)
+}
+
+// applyJumpFlags replaces the configured jump hosts with those given by
+// --source-jump and --dest-jump
+func applyJumpFlags(cmd *cobra.Command, cfg *config.Config) {
+	jumps := func(flag string) []config.JumpConfig {
+		hosts, _ := cmd.Flags().GetStringSlice(flag)
+		var jumps []config.JumpConfig
+		for _, host := range hosts {
+			jumps = append(jumps, config.JumpConfig{Host: host})
+		}
+		return jumps
+	}
+	if cmd.Flags().Changed("source-jump") {
+		cfg.Transfer.Source.Jump = jumps("source-jump")
+	}
+	if cmd.Flags().Changed("dest-jump") {
+		cfg.Transfer.Destination.Jump = jumps("dest-jump")
+	}
+}
+
+// setHostKeyPolicy selects SSH host key checking from --host-key-policy,
+// the config file or DIFPIPE_HOST_KEY_POLICY
+func setHostKeyPolicy(cmd *cobra.Command, cfg *config.Config) error {
+	name := cfg.Transfer.Options.HostKeyPolicy
+	if cmd.Flags().Changed("host-key-policy") {
+		name = hostKeyPolicy
+	} else if name == "" {
+		name = os.Getenv("DIFPIPE_HOST_KEY_POLICY")
+	}
+
+	policy, err := transport.ParseHostKeyPolicy(name)
+	if err != nil {
+		return err
+	}
+	transport.SetHostKeyPolicy(policy)
+	return nil
 }
 
 // exitWithError prints error and exits with appropriate code
@@ -347,10 +680,21 @@ func exitWithError(code int, context string, err error) error {
 	formatter := output.New(output.Format(outputFormat), os.Stderr)
 	_ = formatter.Format(errorOutput)
 
+	// os.Exit skips main, so log out of pooled connections here
+	transport.Shared().Shutdown()
 	os.Exit(code)
 	return nil // Never reached
 }
 
+// exitCodeFor returns the exit code carried by a typed error, or fallback
+func exitCodeFor(err error, fallback int) int {
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		return coded.ExitCode()
+	}
+	return fallback
+}
+
 // convertThresholds converts config thresholds to core thresholds
 func convertThresholds(cfg *config.ThresholdSettings) *core.ThresholdSettings {
 	if cfg == nil {
@@ -367,6 +711,77 @@ func convertThresholds(cfg *config.ThresholdSettings) *core.ThresholdSettings {
 	}
 }
 
+// convertRules validates config rules and converts them to core rules
+func convertRules(cfg []config.RuleConfig) ([]core.StrategyRule, error) {
+	rules := make([]core.StrategyRule, 0, len(cfg))
+	for _, r := range cfg {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		rules = append(rules, core.StrategyRule{
+			Name:                r.Name,
+			Strategy:            core.Strategy(r.Strategy),
+			Staging:             r.Staging,
+			StagingAuth:         r.StagingAuth,
+			StagingJumps:        config.JumpHosts(r.StagingJump),
+			SourceProtocols:     convertProtocols(r.Match.SourceProtocols),
+			DestProtocols:       convertProtocols(r.Match.DestProtocols),
+			SourceHosts:         r.Match.SourceHosts,
+			DestHosts:           r.Match.DestHosts,
+			MinFiles:            r.Match.MinFiles,
+			MaxFiles:            r.Match.MaxFiles,
+			MinTotalSize:        r.Match.MinTotalSizeMB * 1024 * 1024,
+			MaxTotalSize:        r.Match.MaxTotalSizeMB * 1024 * 1024,
+			MinSmallFilePercent: r.Match.MinSmallFilePercent,
+			MaxSmallFilePercent: r.Match.MaxSmallFilePercent,
+			MinLargeFilePercent: r.Match.MinLargeFilePercent,
+			MaxLargeFilePercent: r.Match.MaxLargeFilePercent,
+			Extensions:          r.Match.Extensions,
+			MinExtensionPercent: r.Match.MinExtensionPercent,
+		})
+	}
+	return rules, nil
+}
+
+// convertProtocols converts protocol names to core protocols
+func convertProtocols(names []string) []core.Protocol {
+	var protocols []core.Protocol
+	for _, name := range names {
+		protocols = append(protocols, core.Protocol(name))
+	}
+	return protocols
+}
+
+// runBenchmark executes the benchmark command
+func runBenchmark(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	sizeMB, _ := cmd.Flags().GetInt("size-mb")
+	files, _ := cmd.Flags().GetInt("files")
+
+	if err := setHostKeyPolicy(cmd, &config.Config{}); err != nil {
+		return exitWithError(core.ExitConfigError, "benchmark", err)
+	}
+
+	runner := benchmark.NewRunner().WithSampleSize(sizeMB).WithSmallFiles(files)
+
+	profile, err := runner.Run(ctx, args[0])
+	if err != nil {
+		return exitWithError(exitCodeFor(err, core.ExitGeneralError), "benchmark", err)
+	}
+
+	store, err := benchmark.NewStore("")
+	if err != nil {
+		return exitWithError(core.ExitGeneralError, "benchmark", err)
+	}
+	if err := store.Save(profile); err != nil {
+		return exitWithError(core.ExitGeneralError, "benchmark", err)
+	}
+
+	formatter := output.New(output.Format(outputFormat), os.Stdout)
+	return formatter.Format(profile)
+}
+
 // runStatus executes the status command
 func runStatus(cmd *cobra.Command, args []string) error {
 	// Check if tracker is initialized
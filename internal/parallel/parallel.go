@@ -0,0 +1,24 @@
+// Package parallel holds helpers shared by engines that run concurrent
+// workers
+package parallel
+
+import (
+	"context"
+	"errors"
+)
+
+// FirstError returns the error that stopped a group of workers rather than
+// the cancellations it caused in the others
+func FirstError(errs []error) error {
+	var canceled error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		canceled = err
+	}
+	return canceled
+}
@@ -0,0 +1,29 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFirstError(t *testing.T) {
+	cause := errors.New("connection lost")
+	canceled := fmt.Errorf("shard 2: %w", context.Canceled)
+
+	tests := []struct {
+		errs     []error
+		expected error
+	}{
+		{nil, nil},
+		{[]error{nil, nil}, nil},
+		{[]error{canceled, nil, cause}, cause},
+		{[]error{nil, canceled}, canceled},
+	}
+
+	for _, tt := range tests {
+		if got := FirstError(tt.errs); got != tt.expected {
+			t.Errorf("FirstError(%v) = %v, want %v", tt.errs, got, tt.expected)
+		}
+	}
+}
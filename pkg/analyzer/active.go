@@ -0,0 +1,89 @@
+package analyzer
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+const (
+	// defaultSettleWindow is how recently a file may have been modified
+	// before it counts as still being written
+	defaultSettleWindow = time.Minute
+
+	// maxActivePaths caps how many active files are listed in the analysis
+	maxActivePaths = 10
+)
+
+// WithSettleWindow sets how recently a file may have been modified before
+// it is reported as possibly still being written. Zero disables the check.
+func (a *FileAnalyzer) WithSettleWindow(window time.Duration) *FileAnalyzer {
+	a.settleWindow = window
+	return a
+}
+
+// activeFile is a sampled file modified within the settle window
+type activeFile struct {
+	path    string
+	modTime time.Time
+}
+
+// activeFiles collects sampled files modified within the settle window
+type activeFiles struct {
+	window time.Duration
+	now    time.Time
+	files  []activeFile
+	bytes  int64
+}
+
+// newActiveFiles creates a collector for files modified within window of now
+func newActiveFiles(window time.Duration) *activeFiles {
+	return &activeFiles{window: window, now: time.Now()}
+}
+
+// add records a file when it was modified within the settle window and
+// reports whether it was. Modification times in the future count as recent.
+func (f *activeFiles) add(path string, info fs.FileInfo) bool {
+	if f.window <= 0 || !info.Mode().IsRegular() || f.now.Sub(info.ModTime()) >= f.window {
+		return false
+	}
+	f.files = append(f.files, activeFile{path: path, modTime: info.ModTime()})
+	f.bytes += info.Size()
+	return true
+}
+
+// apply sets the active files on an analysis when there are any
+func (f *activeFiles) apply(analysis *core.FileAnalysis) {
+	if len(f.files) == 0 {
+		return
+	}
+
+	sort.Slice(f.files, func(i, j int) bool {
+		return f.files[i].modTime.After(f.files[j].modTime)
+	})
+
+	active := &core.ActiveFiles{
+		SettleWindow: f.window,
+		Files:        int64(len(f.files)),
+		Bytes:        f.bytes,
+	}
+	for i, file := range f.files {
+		if i == maxActivePaths {
+			break
+		}
+		active.Paths = append(active.Paths, file.path)
+	}
+	analysis.Active = active
+}
+
+// activeNote warns about files that may change during the transfer
+func activeNote(active *core.ActiveFiles) string {
+	if active == nil {
+		return ""
+	}
+	return fmt.Sprintf("; %d files modified within the last %s may still be written and can change during transfer",
+		active.Files, active.SettleWindow)
+}
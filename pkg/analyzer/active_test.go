@@ -0,0 +1,84 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyze_ReportsFilesWithinSettleWindow(t *testing.T) {
+	srcDir := t.TempDir()
+	old := filepath.Join(srcDir, "old.log")
+	if err := os.WriteFile(old, make([]byte, 100), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "current.log"), make([]byte, 40), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	analysis, err := New().WithSettleWindow(time.Minute).Analyze(context.Background(), srcDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	if analysis.Active == nil {
+		t.Fatal("Expected active files to be reported")
+	}
+	if analysis.Active.Files != 1 || analysis.Active.Bytes != 40 {
+		t.Errorf("Expected 1 active file of 40 bytes, got %d/%d", analysis.Active.Files, analysis.Active.Bytes)
+	}
+	if len(analysis.Active.Paths) != 1 || analysis.Active.Paths[0] != "current.log" {
+		t.Errorf("Expected current.log, got %v", analysis.Active.Paths)
+	}
+	if !strings.Contains(analysis.RecommendReason, "may still be written") {
+		t.Errorf("Expected a warning in the reason, got %q", analysis.RecommendReason)
+	}
+}
+
+func TestAnalyze_SettleWindowDisabled(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "current.log"), make([]byte, 40), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	analysis, err := New().WithSettleWindow(0).Analyze(context.Background(), srcDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if analysis.Active != nil {
+		t.Errorf("Expected no active files with the check disabled, got %+v", analysis.Active)
+	}
+}
+
+func TestAnalyze_CacheRescansActiveDirectories(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "current.log"), make([]byte, 40), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	cache, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	a := New().WithCache(cache)
+
+	for i := 0; i < 2; i++ {
+		analysis, err := a.Analyze(context.Background(), srcDir)
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if analysis.Cache.ReusedDirs != 0 {
+			t.Errorf("Run %d: expected the active directory to be rescanned, got %+v", i+1, analysis.Cache)
+		}
+		if analysis.Active == nil || analysis.Active.Files != 1 {
+			t.Errorf("Run %d: expected 1 active file, got %+v", i+1, analysis.Active)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/status"
+	"github.com/larrydiffey/difpipe/pkg/transport"
 )
 
 // FileAnalyzer analyzes files and recommends transfer strategies
@@ -20,6 +22,15 @@ type FileAnalyzer struct {
 	largeFilePercent   float64 // Percentage
 	fewFilesCount      int     // File count
 	maxSampleSize      int     // Max files to sample
+	unchangedPercent   float64 // Percentage already at destination to prefer rsync
+	scanDestination    bool
+	auth               *core.AuthOptions
+	history            []*status.TransferStatus
+	rules              []core.StrategyRule
+	explain            bool
+	cache              *Cache
+	duplicates         bool
+	settleWindow       time.Duration // Files modified more recently may still be written
 }
 
 // New creates a new file analyzer with default thresholds
@@ -32,6 +43,8 @@ func New() *FileAnalyzer {
 		largeFilePercent:   50.0,                // 50%
 		fewFilesCount:      10,                  // 10 files
 		maxSampleSize:      10000,               // 10k files
+		unchangedPercent:   50.0,                // 50%
+		settleWindow:       defaultSettleWindow,
 	}
 }
 
@@ -47,6 +60,18 @@ func (a *FileAnalyzer) WithThresholds(smallKB, largeMB, manyFiles, fewFiles, max
 	return a
 }
 
+// WithDestinationScan enables enumerating the destination to compute a delta
+func (a *FileAnalyzer) WithDestinationScan(enabled bool) *FileAnalyzer {
+	a.scanDestination = enabled
+	return a
+}
+
+// WithAuth sets credentials used when enumerating remote locations
+func (a *FileAnalyzer) WithAuth(auth *core.AuthOptions) *FileAnalyzer {
+	a.auth = auth
+	return a
+}
+
 // Analyze examines the source path and returns analysis
 func (a *FileAnalyzer) Analyze(ctx context.Context, source string) (*core.FileAnalysis, error) {
 	startTime := time.Now()
@@ -55,6 +80,7 @@ func (a *FileAnalyzer) Analyze(ctx context.Context, source string) (*core.FileAn
 		FileTypes:      make(map[string]int64),
 		SourceProtocol: detectProtocol(source),
 	}
+	a.startExplanation(analysis)
 
 	// Check if source is local
 	if !isLocalPath(source) {
@@ -62,11 +88,26 @@ func (a *FileAnalyzer) Analyze(ctx context.Context, source string) (*core.FileAn
 		// Actual analysis would require connecting to remote
 		analysis.Recommendation = core.StrategyRclone
 		analysis.RecommendReason = "Remote source, using rclone for broad protocol support"
+		record(analysis, core.ExplainStep{
+			Stage:     core.ExplainProtocol,
+			Check:     "source protocol",
+			Measured:  string(analysis.SourceProtocol),
+			Threshold: "not local",
+			Passed:    true,
+			Selects:   core.StrategyRclone,
+		})
+		decided(analysis, core.ExplainProtocol)
+		finishExplanation(analysis)
 		return analysis, nil
 	}
 
-	// Analyze local filesystem
-	err := a.analyzeLocal(ctx, source, analysis)
+	// Analyze local filesystem, incrementally when a cache is configured
+	var err error
+	if root, ok := a.cacheable(source); ok {
+		err = a.analyzeCached(ctx, root, analysis)
+	} else {
+		err = a.analyzeLocal(ctx, source, analysis)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("analyze local: %w", err)
 	}
@@ -80,7 +121,8 @@ func (a *FileAnalyzer) Analyze(ctx context.Context, source string) (*core.FileAn
 
 	// Determine recommendation
 	analysis.Recommendation = a.recommendStrategy(analysis)
-	analysis.RecommendReason = a.getRecommendationReason(analysis)
+	a.explainRecommendation(analysis)
+	finishExplanation(analysis)
 
 	return analysis, nil
 }
@@ -110,8 +152,18 @@ func (a *FileAnalyzer) analyzeLocal(ctx context.Context, source string, analysis
 		sampleInterval = int(fileCount / int64(a.maxSampleSize))
 	}
 
+	// Sniff headers of a subset of the sampled files
+	sniffInterval := int64(1)
+	if sampled := fileCount / int64(sampleInterval); sampled > maxSniffFiles {
+		sniffInterval = sampled / maxSniffFiles
+	}
+	content := newContentStats()
+	active := newActiveFiles(a.settleWindow)
+	var sampledFiles []fileRef
+
 	// Analyze with sampling
 	currentFile := int64(0)
+	sampledFile := int64(0)
 	err = filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
 		// Check for cancellation
 		select {
@@ -167,63 +219,195 @@ func (a *FileAnalyzer) analyzeLocal(ctx context.Context, source string, analysis
 		}
 		analysis.FileTypes[ext]++
 
+		if a.duplicates && info.Mode().IsRegular() {
+			sampledFiles = append(sampledFiles, fileRef{path: path, size: size})
+		}
+
+		if rel, err := filepath.Rel(source, path); err == nil {
+			active.add(rel, info)
+		}
+
+		// Track content types
+		if sampledFile%sniffInterval == 0 && info.Mode().IsRegular() {
+			if name, err := sniffFile(path); err == nil {
+				content.add(name, size)
+			}
+		}
+		sampledFile++
+
 		return nil
 	})
 
-	return err
+	content.apply(analysis)
+	active.apply(analysis)
+	if err != nil {
+		return err
+	}
+
+	if a.duplicates {
+		duplicates, err := findDuplicates(ctx, source, sampledFiles)
+		if err != nil {
+			return fmt.Errorf("find duplicates: %w", err)
+		}
+		analysis.Duplicates = duplicates
+	}
+
+	return nil
 }
 
 // recommendStrategy recommends the best transfer strategy
 func (a *FileAnalyzer) recommendStrategy(analysis *core.FileAnalysis) core.Strategy {
+	decided(analysis, core.ExplainHeuristic)
+
+	// If most of the data is already at the destination, let rsync skip it
+	if analysis.Delta != nil {
+		preferDelta := a.preferDeltaSync(analysis)
+		record(analysis, core.ExplainStep{
+			Stage:     core.ExplainHeuristic,
+			Check:     "unchanged at destination",
+			Measured:  fmt.Sprintf("%.1f%%", unchangedPercent(analysis.Delta)),
+			Threshold: fmt.Sprintf(">= %.1f%% (local/ssh only)", a.unchangedPercent),
+			Passed:    preferDelta,
+			Selects:   core.StrategyRsync,
+		})
+		if preferDelta {
+			return core.StrategyRsync
+		}
+	}
+
+	// Size and count checks judge what is left to send
+	w := a.workload(analysis)
+
 	// If very few files, any strategy works
-	if analysis.TotalFiles < int64(a.fewFilesCount) {
+	fewFiles := w.files < int64(a.fewFilesCount)
+	record(analysis, core.ExplainStep{
+		Stage:     core.ExplainHeuristic,
+		Check:     w.scope + "file count",
+		Measured:  fmt.Sprintf("%d", w.files),
+		Threshold: fmt.Sprintf("< %d", a.fewFilesCount),
+		Passed:    fewFiles,
+		Selects:   core.StrategyRsync,
+	})
+	if fewFiles {
 		return core.StrategyRsync
 	}
 
 	// If mostly small files, use tar streaming
-	smallFileRatio := float64(analysis.SmallFiles) / float64(analysis.TotalFiles) * 100
-	if smallFileRatio > a.smallFilePercent && analysis.TotalFiles > int64(a.manyFilesCount) {
+	smallFileRatio := float64(w.small) / float64(w.files) * 100
+	mostlySmall := smallFileRatio > a.smallFilePercent
+	manyFiles := w.files > int64(a.manyFilesCount)
+	record(analysis, core.ExplainStep{
+		Stage:     core.ExplainHeuristic,
+		Check:     w.scope + "small file ratio",
+		Measured:  fmt.Sprintf("%.1f%%", smallFileRatio),
+		Threshold: fmt.Sprintf("> %.1f%%", a.smallFilePercent),
+		Passed:    mostlySmall,
+		Selects:   core.StrategyTar,
+	})
+	record(analysis, core.ExplainStep{
+		Stage:     core.ExplainHeuristic,
+		Check:     w.scope + "file count",
+		Measured:  fmt.Sprintf("%d", w.files),
+		Threshold: fmt.Sprintf("> %d", a.manyFilesCount),
+		Passed:    manyFiles,
+		Selects:   core.StrategyTar,
+	})
+	if mostlySmall && manyFiles {
 		return core.StrategyTar
 	}
 
 	// If mostly large files, use rsync
-	largeFileRatio := float64(analysis.LargeFiles) / float64(analysis.TotalFiles) * 100
-	if largeFileRatio > a.largeFilePercent {
+	largeFileRatio := float64(w.large) / float64(w.files) * 100
+	mostlyLarge := largeFileRatio > a.largeFilePercent
+	record(analysis, core.ExplainStep{
+		Stage:     core.ExplainHeuristic,
+		Check:     w.scope + "large file ratio",
+		Measured:  fmt.Sprintf("%.1f%%", largeFileRatio),
+		Threshold: fmt.Sprintf("> %.1f%%", a.largeFilePercent),
+		Passed:    mostlyLarge,
+		Selects:   core.StrategyRsync,
+	})
+	if mostlyLarge {
 		return core.StrategyRsync
 	}
 
 	// Default to rclone for mixed workloads
+	record(analysis, core.ExplainStep{
+		Stage:   core.ExplainHeuristic,
+		Check:   "mixed workload fallback",
+		Passed:  true,
+		Selects: core.StrategyRclone,
+	})
 	return core.StrategyRclone
 }
 
+// workload is what the size and count heuristics judge: the new and
+// changed files when the destination was scanned, else the whole source
+type workload struct {
+	scope                      string // Prefixes the explained checks
+	files, bytes, small, large int64
+}
+
+// workload returns the files the transfer has to send
+func (a *FileAnalyzer) workload(analysis *core.FileAnalysis) workload {
+	if delta := analysis.Delta; delta != nil {
+		return workload{
+			scope: "to send: ",
+			files: delta.TransferFiles(),
+			bytes: delta.TransferBytes(),
+			small: delta.SmallFiles,
+			large: delta.LargeFiles,
+		}
+	}
+	return workload{
+		files: analysis.TotalFiles,
+		bytes: analysis.TotalSize,
+		small: analysis.SmallFiles,
+		large: analysis.LargeFiles,
+	}
+}
+
 // getRecommendationReason explains why a strategy was chosen
 func (a *FileAnalyzer) getRecommendationReason(analysis *core.FileAnalysis) string {
 	strategy := analysis.Recommendation
+	w := a.workload(analysis)
 
 	switch strategy {
 	case core.StrategyTar:
 		return fmt.Sprintf(
 			"Tar streaming recommended: %d files, %.1f%% are small (<%d KB)",
-			analysis.TotalFiles,
-			float64(analysis.SmallFiles)/float64(analysis.TotalFiles)*100,
+			w.files,
+			float64(w.small)/float64(w.files)*100,
 			a.smallFileThreshold/1024,
 		)
 
 	case core.StrategyRsync:
-		if analysis.TotalFiles < int64(a.fewFilesCount) {
-			return fmt.Sprintf("Rsync recommended: only %d files to transfer", analysis.TotalFiles)
+		if a.preferDeltaSync(analysis) {
+			return fmt.Sprintf(
+				"Rsync recommended: %.1f%% of source data already at destination, %s in %d files to send",
+				unchangedPercent(analysis.Delta),
+				formatBytes(analysis.Delta.TransferBytes()),
+				analysis.Delta.TransferFiles(),
+			)
+		}
+		if w.files < int64(a.fewFilesCount) {
+			return fmt.Sprintf("Rsync recommended: only %d files to transfer", w.files)
 		}
 		return fmt.Sprintf(
 			"Rsync recommended: %.1f%% are large files (>%d MB)",
-			float64(analysis.LargeFiles)/float64(analysis.TotalFiles)*100,
+			float64(w.large)/float64(w.files)*100,
 			a.largeFileThreshold/(1024*1024),
 		)
 
 	case core.StrategyRclone:
+		var average int64
+		if w.files > 0 {
+			average = w.bytes / w.files
+		}
 		return fmt.Sprintf(
 			"Rclone recommended: mixed workload with %d files, avg size %s",
-			analysis.TotalFiles,
-			formatBytes(analysis.AverageFileSize),
+			w.files,
+			formatBytes(average),
 		)
 
 	default:
@@ -231,6 +415,20 @@ func (a *FileAnalyzer) getRecommendationReason(analysis *core.FileAnalysis) stri
 	}
 }
 
+// explainRecommendation sets the reason for the recommendation, followed
+// by the notes on compression, duplicates and files still being written
+func (a *FileAnalyzer) explainRecommendation(analysis *core.FileAnalysis) {
+	analysis.RecommendReason = a.getRecommendationReason(analysis)
+	if analysis.Compression == core.CompressionNone {
+		analysis.RecommendReason += fmt.Sprintf(
+			"; skip compression, content is mostly precompressed (%.0f%% compressible)",
+			analysis.Compressibility*100,
+		)
+	}
+	analysis.RecommendReason += duplicateNote(analysis.Duplicates)
+	analysis.RecommendReason += activeNote(analysis.Active)
+}
+
 // Recommend suggests the best strategy for given analysis
 func (a *FileAnalyzer) Recommend(analysis *core.FileAnalysis) core.Strategy {
 	return a.recommendStrategy(analysis)
@@ -244,12 +442,32 @@ func (a *FileAnalyzer) AnalyzeTransfer(ctx context.Context, source, destination
 
 	// Check for remote-to-remote SSH transfer
 	if sourceProto == core.ProtocolSSH && destProto == core.ProtocolSSH {
-		return &core.FileAnalysis{
+		analysis := &core.FileAnalysis{
 			SourceProtocol:  sourceProto,
 			DestProtocol:    destProto,
 			Recommendation:  core.StrategyProxy,
 			RecommendReason: "Remote-to-remote SSH transfer, using streaming proxy",
-		}, nil
+		}
+		a.startExplanation(analysis)
+		record(analysis, core.ExplainStep{
+			Stage:     core.ExplainProtocol,
+			Check:     "protocols",
+			Measured:  "ssh → ssh",
+			Threshold: "ssh → ssh",
+			Passed:    true,
+			Selects:   core.StrategyProxy,
+		})
+		decided(analysis, core.ExplainProtocol)
+		if a.scanDestination {
+			delta, err := a.analyzeDelta(ctx, source, destination)
+			if err != nil {
+				return nil, fmt.Errorf("analyze destination: %w", err)
+			}
+			analysis.Delta = delta
+		}
+		a.applyRules(analysis, source, destination)
+		finishExplanation(analysis)
+		return analysis, nil
 	}
 
 	// For other cases, use the existing Analyze method
@@ -261,6 +479,28 @@ func (a *FileAnalyzer) AnalyzeTransfer(ctx context.Context, source, destination
 	// Set destination protocol
 	analysis.DestProtocol = destProto
 
+	// Compare against the destination and re-evaluate with the delta
+	if a.scanDestination {
+		delta, err := a.analyzeDelta(ctx, source, destination)
+		if err != nil {
+			return nil, fmt.Errorf("analyze destination: %w", err)
+		}
+		analysis.Delta = delta
+
+		if isLocalPath(source) {
+			a.startExplanation(analysis)
+			analysis.Recommendation = a.recommendStrategy(analysis)
+			a.explainRecommendation(analysis)
+		}
+	}
+
+	// Site rules take precedence, then what actually performed best for
+	// similar transfers
+	if !a.applyRules(analysis, source, destination) {
+		a.applyHistory(analysis, source, destination)
+	}
+	finishExplanation(analysis)
+
 	return analysis, nil
 }
 
@@ -277,8 +517,8 @@ func detectProtocol(path string) core.Protocol {
 		return core.ProtocolHTTP
 	case strings.HasPrefix(path, "ftp://"), strings.HasPrefix(path, "ftps://"):
 		return core.ProtocolFTP
-	case strings.Contains(path, "@") && strings.Contains(path, ":"):
-		// Looks like user@host:path
+	case transport.IsRemotePath(path):
+		// Looks like user@host:path, or host:path for an ssh config alias
 		return core.ProtocolSSH
 	default:
 		return core.ProtocolLocal
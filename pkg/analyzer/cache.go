@@ -0,0 +1,324 @@
+package analyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// Cache persists per-directory analysis results between runs. A directory
+// whose modification time and inode are unchanged, and whose files still
+// have the sizes and modification times on record, reuses its cached file
+// stats without sniffing content again; other directories are read again,
+// as are directories that held files within the settle window.
+type Cache struct {
+	dir string
+}
+
+// cacheVersion changes whenever dirRecord gains fields, older entries
+// are rescanned
+const cacheVersion = 4
+
+// cacheEntry is the cached analysis of one source tree
+type cacheEntry struct {
+	Version            int                   `json:"version"`
+	Source             string                `json:"source"`
+	SmallFileThreshold int64                 `json:"small_file_threshold"`
+	LargeFileThreshold int64                 `json:"large_file_threshold"`
+	Dirs               map[string]*dirRecord `json:"dirs"` // Keyed by path relative to Source
+}
+
+// dirRecord holds stats for the files directly inside one directory
+type dirRecord struct {
+	ModTime      int64            `json:"mod_time"` // Unix nanoseconds
+	Inode        uint64           `json:"inode"`
+	FilesSum     string           `json:"files_sum"` // Digest of the names, sizes and modification times of the files
+	ScannedAt    time.Time        `json:"scanned_at"`
+	Files        int64            `json:"files"`
+	Bytes        int64            `json:"bytes"`
+	SmallFiles   int64            `json:"small_files"`
+	MediumFiles  int64            `json:"medium_files"`
+	LargeFiles   int64            `json:"large_files"`
+	FileTypes    map[string]int64 `json:"file_types"`
+	ContentFiles map[string]int64 `json:"content_files,omitempty"` // Sniffed files by content type
+	ContentBytes map[string]int64 `json:"content_bytes,omitempty"`
+	Subdirs      []string         `json:"subdirs,omitempty"`
+	Active       bool             `json:"active,omitempty"` // Held files modified within the settle window
+}
+
+// NewCache creates an analysis cache
+func NewCache(dir string) (*Cache, error) {
+	// Default to ~/.difpipe/analysis
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("get home dir: %w", err)
+		}
+		dir = filepath.Join(home, ".difpipe", "analysis")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create analysis cache dir: %w", err)
+	}
+
+	return &Cache{dir: dir}, nil
+}
+
+// WithCache reuses and updates cached results for local sources. Pass nil
+// to always scan.
+func (a *FileAnalyzer) WithCache(cache *Cache) *FileAnalyzer {
+	a.cache = cache
+	return a
+}
+
+// load loads the cached entry for a source tree
+func (c *Cache) load(source string) (*cacheEntry, error) {
+	data, err := os.ReadFile(c.entryPath(source))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("unmarshal analysis cache: %w", err)
+	}
+	if entry.Source != source {
+		return nil, fmt.Errorf("analysis cache is for %s", entry.Source)
+	}
+
+	return &entry, nil
+}
+
+// save stores the entry for a source tree
+func (c *Cache) save(entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal analysis cache: %w", err)
+	}
+
+	if err := os.WriteFile(c.entryPath(entry.Source), data, 0644); err != nil {
+		return fmt.Errorf("write analysis cache: %w", err)
+	}
+
+	return nil
+}
+
+// entryPath returns the cache file for a source tree
+func (c *Cache) entryPath(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// analyzeCached analyzes a local directory tree, reading only directories
+// that changed since the cached scan
+func (a *FileAnalyzer) analyzeCached(ctx context.Context, root string, analysis *core.FileAnalysis) error {
+	previous, _ := a.cache.load(root)
+	if previous != nil && (previous.Version != cacheVersion ||
+		previous.SmallFileThreshold != a.smallFileThreshold || previous.LargeFileThreshold != a.largeFileThreshold) {
+		// Size classes are stored per directory, so other thresholds need a rescan
+		previous = nil
+	}
+
+	entry := &cacheEntry{
+		Version:            cacheVersion,
+		Source:             root,
+		SmallFileThreshold: a.smallFileThreshold,
+		LargeFileThreshold: a.largeFileThreshold,
+		Dirs:               make(map[string]*dirRecord),
+	}
+	info := &core.CacheInfo{}
+
+	active := newActiveFiles(a.settleWindow)
+	if err := a.scanDir(ctx, root, ".", previous, entry, info, active); err != nil {
+		return err
+	}
+
+	var oldest time.Time
+	content := newContentStats()
+	for _, rec := range entry.Dirs {
+		analysis.TotalFiles += rec.Files
+		analysis.TotalSize += rec.Bytes
+		analysis.SmallFiles += rec.SmallFiles
+		analysis.MediumFiles += rec.MediumFiles
+		analysis.LargeFiles += rec.LargeFiles
+		for ext, count := range rec.FileTypes {
+			analysis.FileTypes[ext] += count
+		}
+		for name, count := range rec.ContentFiles {
+			content.files[name] += count
+			content.bytes[name] += rec.ContentBytes[name]
+		}
+		if oldest.IsZero() || rec.ScannedAt.Before(oldest) {
+			oldest = rec.ScannedAt
+		}
+	}
+	if info.ReusedDirs > 0 {
+		info.Age = time.Since(oldest)
+	}
+	analysis.Cache = info
+	content.apply(analysis)
+	active.apply(analysis)
+
+	// A failed write only costs a rescan next time
+	_ = a.cache.save(entry)
+
+	return nil
+}
+
+// scanDir records one directory and recurses into its subdirectories,
+// reusing the previous record when the directory fingerprint matches and
+// none of its files were still being written
+func (a *FileAnalyzer) scanDir(ctx context.Context, root, rel string, previous *cacheEntry, entry *cacheEntry, info *core.CacheInfo, active *activeFiles) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The root may be a symlink to a directory, nothing below it is followed
+	stat := os.Lstat
+	if rel == "." {
+		stat = os.Stat
+	}
+
+	path := filepath.Join(root, rel)
+	dirInfo, err := stat(path)
+	if err != nil {
+		if rel == "." {
+			return err
+		}
+		return nil // Skip errors
+	}
+	modTime := dirInfo.ModTime().UnixNano()
+	ino := inode(dirInfo)
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if rel == "." {
+			return err
+		}
+		return nil // Skip errors
+	}
+	files, filesSum := statFiles(entries)
+
+	if previous != nil {
+		if rec, ok := previous.Dirs[rel]; ok && rec.ModTime == modTime && rec.Inode == ino && rec.FilesSum == filesSum && !rec.Active {
+			entry.Dirs[rel] = rec
+			info.ReusedDirs++
+			for _, sub := range rec.Subdirs {
+				if err := a.scanDir(ctx, root, sub, previous, entry, info, active); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	rec := &dirRecord{
+		ModTime:      modTime,
+		Inode:        ino,
+		FilesSum:     filesSum,
+		ScannedAt:    time.Now(),
+		FileTypes:    make(map[string]int64),
+		ContentFiles: make(map[string]int64),
+		ContentBytes: make(map[string]int64),
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			rec.Subdirs = append(rec.Subdirs, filepath.Join(rel, e.Name()))
+		}
+	}
+	sniffed := 0
+	for _, fileInfo := range files {
+		a.addFile(rec, fileInfo.Name(), fileInfo.Size())
+		if active.add(filepath.Join(rel, fileInfo.Name()), fileInfo) {
+			rec.Active = true
+		}
+
+		if sniffed < sniffPerDir && fileInfo.Mode().IsRegular() {
+			if name, err := sniffFile(filepath.Join(path, fileInfo.Name())); err == nil {
+				rec.ContentFiles[name]++
+				rec.ContentBytes[name] += fileInfo.Size()
+				sniffed++
+			}
+		}
+	}
+
+	entry.Dirs[rel] = rec
+	info.ScannedDirs++
+
+	for _, sub := range rec.Subdirs {
+		if err := a.scanDir(ctx, root, sub, previous, entry, info, active); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statFiles returns the files among a directory's entries and a digest of
+// their names, sizes and modification times, which in-place edits change
+// even when the directory's own modification time stays
+func statFiles(entries []fs.DirEntry) ([]fs.FileInfo, string) {
+	var files []fs.FileInfo
+	hash := sha256.New()
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		fileInfo, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, fileInfo)
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", e.Name(), fileInfo.Size(), fileInfo.ModTime().UnixNano())
+	}
+	return files, hex.EncodeToString(hash.Sum(nil))
+}
+
+// addFile classifies a file into a directory record
+func (a *FileAnalyzer) addFile(rec *dirRecord, name string, size int64) {
+	rec.Files++
+	rec.Bytes += size
+
+	if size < a.smallFileThreshold {
+		rec.SmallFiles++
+	} else if size > a.largeFileThreshold {
+		rec.LargeFiles++
+	} else {
+		rec.MediumFiles++
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		ext = "(no extension)"
+	}
+	rec.FileTypes[ext]++
+}
+
+// cacheable returns the absolute path of a source directory when results
+// for it can be cached
+func (a *FileAnalyzer) cacheable(source string) (string, bool) {
+	// Duplicate detection reads file contents anyway and needs every
+	// sampled path, which the cache does not keep
+	if a.cache == nil || a.duplicates {
+		return "", false
+	}
+
+	root, err := filepath.Abs(source)
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return "", false
+	}
+
+	return root, true
+}
@@ -0,0 +1,144 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnalyze_CacheReusesUnchangedDirectories(t *testing.T) {
+	srcDir := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(srcDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			name := filepath.Join(srcDir, dir, "file"+string(rune('0'+i))+".txt")
+			if err := os.WriteFile(name, make([]byte, 100), 0644); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+		}
+	}
+
+	cache, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	// The files were just written, don't treat them as still being written
+	a := New().WithCache(cache).WithSettleWindow(0)
+
+	first, err := a.Analyze(context.Background(), srcDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if first.Cache == nil || first.Cache.ScannedDirs != 3 || first.Cache.ReusedDirs != 0 {
+		t.Fatalf("Expected 3 scanned directories on first run, got %+v", first.Cache)
+	}
+	if first.TotalFiles != 6 {
+		t.Errorf("Expected 6 files, got %d", first.TotalFiles)
+	}
+
+	// Add a file to b; make sure its mtime moves even on coarse filesystems
+	if err := os.WriteFile(filepath.Join(srcDir, "b", "new.log"), make([]byte, 50), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(srcDir, "b"), later, later); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	second, err := a.Analyze(context.Background(), srcDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if second.Cache.ScannedDirs != 1 || second.Cache.ReusedDirs != 2 {
+		t.Errorf("Expected 1 rescanned and 2 reused directories, got %+v", second.Cache)
+	}
+	if second.TotalFiles != 7 || second.TotalSize != 650 {
+		t.Errorf("Expected 7 files of 650 bytes, got %d/%d", second.TotalFiles, second.TotalSize)
+	}
+	if second.FileTypes[".log"] != 1 {
+		t.Errorf("Expected the new .log file to be counted, got %v", second.FileTypes)
+	}
+}
+
+func TestAnalyze_CacheInvalidatedByThresholds(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "file.dat"), make([]byte, 20*1024), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	cache, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+
+	if _, err := New().WithCache(cache).Analyze(context.Background(), srcDir); err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	a := New().WithCache(cache).WithThresholds(50, 100, 1000, 10, 10000, 80, 50)
+	analysis, err := a.Analyze(context.Background(), srcDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if analysis.Cache.ReusedDirs != 0 {
+		t.Errorf("Expected rescan after threshold change, got %+v", analysis.Cache)
+	}
+	if analysis.SmallFiles != 1 {
+		t.Errorf("Expected file to be small under 50 KB threshold, got %d small", analysis.SmallFiles)
+	}
+}
+
+func TestAnalyze_CacheDetectsInPlaceEdits(t *testing.T) {
+	srcDir := t.TempDir()
+	sub := filepath.Join(srcDir, "a")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	file := filepath.Join(sub, "data.txt")
+	if err := os.WriteFile(file, make([]byte, 100), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	dirInfo, err := os.Stat(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	a := New().WithCache(cache).WithSettleWindow(0)
+	if _, err := a.Analyze(context.Background(), srcDir); err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	// Append in place, the directory's modification time doesn't move
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(make([]byte, 900))
+	f.Close()
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	if err := os.Chtimes(sub, dirInfo.ModTime(), dirInfo.ModTime()); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	second, err := a.Analyze(context.Background(), srcDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if second.Cache.ScannedDirs != 1 || second.Cache.ReusedDirs != 1 {
+		t.Errorf("Expected the edited directory rescanned, got %+v", second.Cache)
+	}
+	if second.TotalSize != 1000 {
+		t.Errorf("Expected 1000 bytes after the edit, got %d", second.TotalSize)
+	}
+}
@@ -0,0 +1,228 @@
+package analyzer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+const (
+	// sniffSize is how many header bytes are read to detect content
+	sniffSize = 512
+
+	// maxSniffFiles caps how many sampled files are opened per analysis
+	maxSniffFiles = 1000
+
+	// sniffPerDir caps how many files are opened per directory when
+	// scanning for the cache
+	sniffPerDir = 32
+
+	// minCompressibility is the estimated compressibility below which
+	// compressing the stream is not worth the CPU
+	minCompressibility = 0.3
+)
+
+// Content families
+const (
+	FamilyArchive    = "archive"
+	FamilyImage      = "image"
+	FamilyVideo      = "video"
+	FamilyAudio      = "audio"
+	FamilyDatabase   = "database"
+	FamilyDocument   = "document"
+	FamilyExecutable = "executable"
+	FamilyText       = "text"
+	FamilyBinary     = "binary"
+	FamilyEmpty      = "empty"
+)
+
+// contentType describes a detected file format
+type contentType struct {
+	family          string
+	compressibility float64 // 0.0 = incompressible, 1.0 = highly compressible
+}
+
+// contentTypes lists every type detectContent can return
+var contentTypes = map[string]contentType{
+	"gzip":    {FamilyArchive, 0.0},
+	"zstd":    {FamilyArchive, 0.0},
+	"xz":      {FamilyArchive, 0.0},
+	"bzip2":   {FamilyArchive, 0.0},
+	"lz4":     {FamilyArchive, 0.0},
+	"zip":     {FamilyArchive, 0.05},
+	"7z":      {FamilyArchive, 0.0},
+	"rar":     {FamilyArchive, 0.0},
+	"tar":     {FamilyArchive, 0.7}, // Uncompressed container
+	"jpeg":    {FamilyImage, 0.02},
+	"png":     {FamilyImage, 0.02},
+	"gif":     {FamilyImage, 0.05},
+	"webp":    {FamilyImage, 0.02},
+	"heic":    {FamilyImage, 0.02},
+	"tiff":    {FamilyImage, 0.4},
+	"mp4":     {FamilyVideo, 0.02},
+	"mkv":     {FamilyVideo, 0.02},
+	"avi":     {FamilyVideo, 0.05},
+	"mp3":     {FamilyAudio, 0.02},
+	"flac":    {FamilyAudio, 0.02},
+	"ogg":     {FamilyAudio, 0.02},
+	"wav":     {FamilyAudio, 0.3},
+	"sqlite":  {FamilyDatabase, 0.6},
+	"parquet": {FamilyDatabase, 0.1},
+	"pdf":     {FamilyDocument, 0.2},
+	"elf":     {FamilyExecutable, 0.6},
+	"text":    {FamilyText, 0.8},
+	"binary":  {FamilyBinary, 0.5},
+	"empty":   {FamilyEmpty, 0.0},
+}
+
+// magic is a header signature at a fixed offset
+type magic struct {
+	offset int
+	bytes  []byte
+	name   string
+}
+
+// magics are checked in order, the first match wins
+var magics = []magic{
+	{0, []byte{0x1f, 0x8b}, "gzip"},
+	{0, []byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"},
+	{0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "xz"},
+	{0, []byte("BZh"), "bzip2"},
+	{0, []byte{0x04, 0x22, 0x4d, 0x18}, "lz4"},
+	{0, []byte("PK\x03\x04"), "zip"},
+	{0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, "7z"},
+	{0, []byte("Rar!\x1a\x07"), "rar"},
+	{257, []byte("ustar"), "tar"},
+	{0, []byte{0xff, 0xd8, 0xff}, "jpeg"},
+	{0, []byte{0x89, 'P', 'N', 'G'}, "png"},
+	{0, []byte("GIF8"), "gif"},
+	{8, []byte("WEBP"), "webp"},
+	{4, []byte("ftypheic"), "heic"},
+	{4, []byte("ftypheix"), "heic"},
+	{4, []byte("ftypmif1"), "heic"},
+	{4, []byte("ftyp"), "mp4"},
+	{0, []byte("II*\x00"), "tiff"},
+	{0, []byte("MM\x00*"), "tiff"},
+	{0, []byte{0x1a, 0x45, 0xdf, 0xa3}, "mkv"},
+	{8, []byte("AVI "), "avi"},
+	{8, []byte("WAVE"), "wav"},
+	{0, []byte("ID3"), "mp3"},
+	{0, []byte{0xff, 0xfb}, "mp3"},
+	{0, []byte("fLaC"), "flac"},
+	{0, []byte("OggS"), "ogg"},
+	{0, []byte("SQLite format 3\x00"), "sqlite"},
+	{0, []byte("PAR1"), "parquet"},
+	{0, []byte("%PDF"), "pdf"},
+	{0, []byte("\x7fELF"), "elf"},
+}
+
+// detectContent identifies a file format from its first bytes
+func detectContent(header []byte) string {
+	if len(header) == 0 {
+		return "empty"
+	}
+
+	for _, m := range magics {
+		end := m.offset + len(m.bytes)
+		if len(header) >= end && bytes.Equal(header[m.offset:end], m.bytes) {
+			return m.name
+		}
+	}
+
+	if looksLikeText(header) {
+		return "text"
+	}
+	return "binary"
+}
+
+// looksLikeText reports whether a header is UTF-8 text without control bytes
+func looksLikeText(header []byte) bool {
+	// The header may end mid-rune
+	for i := 0; i < utf8.UTFMax && len(header) > 0 && !utf8.Valid(header); i++ {
+		header = header[:len(header)-1]
+	}
+	if !utf8.Valid(header) {
+		return false
+	}
+
+	for _, b := range header {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' {
+			return false
+		}
+	}
+	return true
+}
+
+// sniffFile reads the header of a file and detects its content type
+func sniffFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return detectContent(header[:n]), nil
+}
+
+// contentStats accumulates sniffed files by content type
+type contentStats struct {
+	files map[string]int64
+	bytes map[string]int64
+}
+
+// newContentStats creates empty content stats
+func newContentStats() *contentStats {
+	return &contentStats{
+		files: make(map[string]int64),
+		bytes: make(map[string]int64),
+	}
+}
+
+// add records one sniffed file
+func (c *contentStats) add(name string, size int64) {
+	c.files[name]++
+	c.bytes[name] += size
+}
+
+// apply sets content types, families, compressibility and the compression
+// recommendation on an analysis
+func (c *contentStats) apply(analysis *core.FileAnalysis) {
+	if len(c.files) == 0 {
+		return
+	}
+
+	analysis.ContentTypes = make(map[string]int64)
+	analysis.ContentFamilies = make(map[string]int64)
+
+	var totalBytes int64
+	var compressible float64
+	for name, count := range c.files {
+		ct := contentTypes[name]
+		analysis.ContentTypes[name] += count
+		analysis.ContentFamilies[ct.family] += count
+		totalBytes += c.bytes[name]
+		compressible += float64(c.bytes[name]) * ct.compressibility
+	}
+
+	if totalBytes > 0 {
+		analysis.Compressibility = compressible / float64(totalBytes)
+	}
+	analysis.Compression = recommendCompression(analysis.Compressibility, totalBytes)
+}
+
+// recommendCompression decides whether compressing content is worthwhile
+func recommendCompression(compressibility float64, sampledBytes int64) core.Compression {
+	if sampledBytes > 0 && compressibility < minCompressibility {
+		return core.CompressionNone
+	}
+	return core.CompressionGzip
+}
@@ -0,0 +1,75 @@
+package analyzer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestDetectContent(t *testing.T) {
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[257:], "ustar")
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, "gzip"},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), "png"},
+		{"mp4", []byte("\x00\x00\x00\x18ftypmp42"), "mp4"},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic"), "heic"},
+		{"sqlite", []byte("SQLite format 3\x00rest"), "sqlite"},
+		{"tar", tarHeader, "tar"},
+		{"text", []byte("2024-01-01 INFO started\n"), "text"},
+		{"utf8 cut mid-rune", append([]byte("caf"), 0xc3), "text"},
+		{"binary", []byte{0x00, 0x01, 0x02, 0x03}, "binary"},
+		{"empty", nil, "empty"},
+	}
+
+	for _, tt := range tests {
+		if got := detectContent(tt.header); got != tt.want {
+			t.Errorf("%s: detectContent = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze_ContentFamilies(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Extensionless rotated logs, already gzipped
+	for i := 0; i < 4; i++ {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(bytes.Repeat([]byte("log line\n"), 1000))
+		gz.Close()
+		name := filepath.Join(tmpDir, "app.log."+string(rune('1'+i)))
+		if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "current"), []byte("log line\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	analysis, err := New().Analyze(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	if analysis.ContentTypes["gzip"] != 4 || analysis.ContentTypes["text"] != 1 {
+		t.Errorf("Expected 4 gzip and 1 text, got %v", analysis.ContentTypes)
+	}
+	if analysis.ContentFamilies[FamilyArchive] != 4 {
+		t.Errorf("Expected 4 archives, got %v", analysis.ContentFamilies)
+	}
+	if analysis.Compression != core.CompressionNone {
+		t.Errorf("Expected no compression for gzipped content, got %s (%.2f compressible)",
+			analysis.Compression, analysis.Compressibility)
+	}
+}
@@ -0,0 +1,311 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// modTimeTolerance absorbs timestamp precision differences between filesystems
+const modTimeTolerance = time.Second
+
+// fileEntry is a file found while enumerating a location
+type fileEntry struct {
+	size    int64
+	modTime time.Time
+}
+
+// analyzeDelta enumerates source and destination and compares their contents
+func (a *FileAnalyzer) analyzeDelta(ctx context.Context, source, destination string) (*core.DeltaAnalysis, error) {
+	startTime := time.Now()
+
+	var sourceAuth, destAuth map[string]interface{}
+	var sourceJumps, destJumps []core.JumpHost
+	if a.auth != nil {
+		sourceAuth = a.auth.SourceAuth
+		destAuth = a.auth.DestAuth
+		sourceJumps = a.auth.SourceJumps
+		destJumps = a.auth.DestJumps
+	}
+
+	sourceFiles, err := listLocation(ctx, source, sourceAuth, sourceJumps, a.auth.SourcePasswordEnv())
+	if err != nil {
+		return nil, fmt.Errorf("list source: %w", err)
+	}
+
+	destFiles, err := listLocation(ctx, destination, destAuth, destJumps, a.auth.DestPasswordEnv())
+	if err != nil {
+		return nil, fmt.Errorf("list destination: %w", err)
+	}
+
+	delta := a.compareListings(sourceFiles, destFiles)
+	delta.ScanTime = time.Since(startTime)
+
+	return delta, nil
+}
+
+// compareListings classifies files as new, changed, unchanged or extra,
+// and the files to send by size
+func (a *FileAnalyzer) compareListings(source, dest map[string]fileEntry) *core.DeltaAnalysis {
+	delta := &core.DeltaAnalysis{}
+
+	for path, src := range source {
+		dst, exists := dest[path]
+		switch {
+		case !exists:
+			delta.NewFiles++
+			delta.NewBytes += src.size
+		case src.size != dst.size || !sameModTime(src.modTime, dst.modTime):
+			delta.ChangedFiles++
+			delta.ChangedBytes += src.size
+		default:
+			delta.UnchangedFiles++
+			delta.UnchangedBytes += src.size
+			continue
+		}
+
+		if src.size < a.smallFileThreshold {
+			delta.SmallFiles++
+		} else if src.size > a.largeFileThreshold {
+			delta.LargeFiles++
+		}
+	}
+
+	for path, dst := range dest {
+		if _, exists := source[path]; !exists {
+			delta.ExtraFiles++
+			delta.ExtraBytes += dst.size
+		}
+	}
+
+	return delta
+}
+
+// sameModTime compares modification times within modTimeTolerance
+func sameModTime(a, b time.Time) bool {
+	diff := a.Sub(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= modTimeTolerance
+}
+
+// listLocation enumerates all files under a location keyed by relative path.
+// A location that does not exist yet is reported as empty.
+func listLocation(ctx context.Context, location string, authConfig map[string]interface{}, jumps []core.JumpHost, passwordEnv string) (map[string]fileEntry, error) {
+	switch detectProtocol(location) {
+	case core.ProtocolLocal:
+		return listLocal(ctx, location)
+	case core.ProtocolSSH:
+		return listSSH(ctx, location, authConfig, jumps, passwordEnv)
+	default:
+		return listRclone(ctx, location)
+	}
+}
+
+// listLocal enumerates a local directory tree
+func listLocal(ctx context.Context, root string) (map[string]fileEntry, error) {
+	files := make(map[string]fileEntry)
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if err != nil {
+			return nil // Skip errors
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		if relPath == "." {
+			// Root is a single file
+			relPath = filepath.Base(path)
+		}
+
+		files[filepath.ToSlash(relPath)] = fileEntry{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// listSSH enumerates a remote directory tree with find over SSH
+func listSSH(ctx context.Context, location string, authConfig map[string]interface{}, jumps []core.JumpHost, passwordEnv string) (map[string]fileEntry, error) {
+	loc, err := transport.ParseRemotePath(location)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := transport.ResolveAuth(authConfig, passwordEnv)
+	if err != nil {
+		return nil, fmt.Errorf("get authentication: %w", err)
+	}
+	if err = loc.ResolveJumps(jumps); err != nil {
+		return nil, err
+	}
+
+	t := transport.Shared()
+	client, err := t.Connect(ctx, loc.SSHConfig(auth))
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	defer t.Close(client)
+
+	result, err := t.ExecuteCommand(ctx, client, listCommand(loc.Path))
+	if err != nil {
+		return nil, fmt.Errorf("execute find: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("find failed: %s", strings.TrimSpace(string(result.Stderr)))
+	}
+
+	return parseFindOutput(result.Stdout, loc.Path), nil
+}
+
+// listCommand builds the command listing the files below path as
+// "size mtime relpath" records. GNU find terminates them with NUL, which
+// keeps unusual file names intact. Where find lacks -printf, as on BSD and
+// busybox, stat prints a line per file instead.
+func listCommand(path string) string {
+	path = transport.QuoteRemotePath(path)
+	stat := `stat -c "%s %Y %n" "$@" 2>/dev/null || stat -f "%z %m %N" "$@"`
+	return fmt.Sprintf("if [ ! -e %[1]s ]; then :; "+
+		"elif find %[1]s -prune -printf '' 2>/dev/null; then find %[1]s -type f -printf '%%s %%T@ %%P\\0'; "+
+		"elif [ -d %[1]s ]; then cd %[1]s && find . -type f -exec sh -c '%[2]s' sh {} +; "+
+		"else stat -c '%%s %%Y ' %[1]s 2>/dev/null || stat -f '%%z %%m ' %[1]s; fi",
+		path, stat)
+}
+
+// parseFindOutput parses "size mtime relpath" records, NUL-terminated
+// from find or one per line from stat. Paths from stat start with "./".
+func parseFindOutput(output []byte, root string) map[string]fileEntry {
+	files := make(map[string]fileEntry)
+
+	separator := byte(0)
+	if bytes.IndexByte(output, 0) < 0 {
+		separator = '\n'
+	}
+	for _, record := range bytes.Split(output, []byte{separator}) {
+		parts := strings.SplitN(string(record), " ", 3)
+		if len(parts) != 3 {
+			continue // Skip malformed records
+		}
+
+		size, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seconds, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			continue
+		}
+
+		relPath := strings.TrimPrefix(parts[2], "./")
+		if relPath == "" {
+			// Root is a single file
+			relPath = filepath.Base(root)
+		}
+
+		files[relPath] = fileEntry{
+			size:    size,
+			modTime: time.Unix(0, int64(seconds*float64(time.Second))),
+		}
+	}
+
+	return files
+}
+
+// listRclone enumerates a remote supported by rclone using lsjson
+func listRclone(ctx context.Context, location string) (map[string]fileEntry, error) {
+	cmd := exec.CommandContext(ctx, "rclone", "lsjson", "-R", "--files-only", location)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "directory not found") {
+			return make(map[string]fileEntry), nil
+		}
+		return nil, fmt.Errorf("rclone lsjson: %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var entries []struct {
+		Path    string
+		Size    int64
+		ModTime time.Time
+	}
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("parse rclone listing: %w", err)
+	}
+
+	files := make(map[string]fileEntry, len(entries))
+	for _, entry := range entries {
+		files[entry.Path] = fileEntry{
+			size:    entry.Size,
+			modTime: entry.ModTime,
+		}
+	}
+
+	return files, nil
+}
+
+// supportsDeltaSync reports whether rsync can run incrementally against a protocol
+func supportsDeltaSync(protocol core.Protocol) bool {
+	return protocol == core.ProtocolLocal || protocol == core.ProtocolSSH
+}
+
+// preferDeltaSync reports whether the destination already holds enough of
+// the source that an incremental rsync beats re-sending everything
+func (a *FileAnalyzer) preferDeltaSync(analysis *core.FileAnalysis) bool {
+	delta := analysis.Delta
+	if delta == nil {
+		return false
+	}
+	if !supportsDeltaSync(analysis.SourceProtocol) || !supportsDeltaSync(analysis.DestProtocol) {
+		return false
+	}
+
+	return unchangedPercent(delta) >= a.unchangedPercent
+}
+
+// unchangedPercent returns the share of source bytes already at the destination
+func unchangedPercent(delta *core.DeltaAnalysis) float64 {
+	total := delta.UnchangedBytes + delta.TransferBytes()
+	if total == 0 {
+		return 0
+	}
+	return float64(delta.UnchangedBytes) / float64(total) * 100
+}
@@ -0,0 +1,211 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestCompareListings(t *testing.T) {
+	now := time.Now()
+
+	source := map[string]fileEntry{
+		"new.txt":       {size: 100, modTime: now},
+		"changed.txt":   {size: 200, modTime: now},
+		"touched.txt":   {size: 300, modTime: now},
+		"unchanged.txt": {size: 400, modTime: now},
+	}
+	dest := map[string]fileEntry{
+		"changed.txt":   {size: 150, modTime: now},
+		"touched.txt":   {size: 300, modTime: now.Add(-time.Hour)},
+		"unchanged.txt": {size: 400, modTime: now.Add(500 * time.Millisecond)},
+		"extra.txt":     {size: 50, modTime: now},
+	}
+
+	delta := New().compareListings(source, dest)
+
+	if delta.NewFiles != 1 || delta.NewBytes != 100 {
+		t.Errorf("expected 1 new file of 100 bytes, got %d/%d", delta.NewFiles, delta.NewBytes)
+	}
+	if delta.ChangedFiles != 2 || delta.ChangedBytes != 500 {
+		t.Errorf("expected 2 changed files of 500 bytes, got %d/%d", delta.ChangedFiles, delta.ChangedBytes)
+	}
+	if delta.UnchangedFiles != 1 || delta.UnchangedBytes != 400 {
+		t.Errorf("expected 1 unchanged file of 400 bytes, got %d/%d", delta.UnchangedFiles, delta.UnchangedBytes)
+	}
+	if delta.ExtraFiles != 1 || delta.ExtraBytes != 50 {
+		t.Errorf("expected 1 extra file of 50 bytes, got %d/%d", delta.ExtraFiles, delta.ExtraBytes)
+	}
+	if delta.SmallFiles != 3 || delta.LargeFiles != 0 {
+		t.Errorf("expected 3 small files to send, got %d small and %d large", delta.SmallFiles, delta.LargeFiles)
+	}
+	if delta.TransferBytes() != 600 {
+		t.Errorf("expected 600 bytes to transfer, got %d", delta.TransferBytes())
+	}
+}
+
+func TestParseFindOutput(t *testing.T) {
+	output := []byte("12 1700000000.5000000000 a/b.txt\x000 1700000001.0000000000 name with spaces\x00garbage\x00")
+
+	files := parseFindOutput(output, "/data")
+
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+	if files["a/b.txt"].size != 12 {
+		t.Errorf("expected size 12, got %d", files["a/b.txt"].size)
+	}
+	if _, ok := files["name with spaces"]; !ok {
+		t.Error("expected file name with spaces to be parsed")
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	find, err := exec.LookPath("find")
+	if err != nil {
+		t.Skip("find not available")
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "a.txt"), make([]byte, 12), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "name with spaces"), make([]byte, 3), 0644)
+
+	// A find without -printf, as on BSD and busybox
+	shim := t.TempDir()
+	script := "#!/bin/sh\nfor a; do [ \"$a\" = -printf ] && exit 1; done\nexec " + find + " \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(shim, "find"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+	}{
+		{"gnu find", os.Getenv("PATH")},
+		{"without printf", shim + string(os.PathListSeparator) + os.Getenv("PATH")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(root string) map[string]fileEntry {
+				cmd := exec.Command("sh", "-c", listCommand(root))
+				cmd.Env = append(os.Environ(), "PATH="+tt.path)
+				out, err := cmd.Output()
+				if err != nil {
+					t.Fatalf("list %s: %v", root, err)
+				}
+				return parseFindOutput(out, root)
+			}
+
+			files := run(dir)
+			if len(files) != 2 || files["a.txt"].size != 12 || files["sub/name with spaces"].size != 3 {
+				t.Errorf("unexpected listing %v", files)
+			}
+			if files["a.txt"].modTime.IsZero() {
+				t.Error("expected a modification time")
+			}
+			if files := run(filepath.Join(dir, "a.txt")); files["a.txt"].size != 12 {
+				t.Errorf("unexpected listing of a single file %v", files)
+			}
+			if files := run(filepath.Join(dir, "missing")); len(files) != 0 {
+				t.Errorf("expected no files, got %v", files)
+			}
+		})
+	}
+}
+
+func TestAnalyzeTransfer_DestinationDelta(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	// 20 files, 19 already copied with the same size and mtime
+	for i := 0; i < 20; i++ {
+		name := filepath.Join(srcDir, "file"+string(rune('a'+i))+".dat")
+		if err := os.WriteFile(name, make([]byte, 1024), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if i == 0 {
+			continue
+		}
+		info, _ := os.Stat(name)
+		copyName := filepath.Join(dstDir, filepath.Base(name))
+		if err := os.WriteFile(copyName, make([]byte, 1024), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := os.Chtimes(copyName, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatalf("Failed to set times: %v", err)
+		}
+	}
+
+	a := New().WithDestinationScan(true)
+	analysis, err := a.AnalyzeTransfer(context.Background(), srcDir, dstDir)
+	if err != nil {
+		t.Fatalf("AnalyzeTransfer failed: %v", err)
+	}
+
+	if analysis.Delta == nil {
+		t.Fatal("Expected delta analysis")
+	}
+	if analysis.Delta.NewFiles != 1 || analysis.Delta.UnchangedFiles != 19 {
+		t.Errorf("Expected 1 new and 19 unchanged, got %d/%d", analysis.Delta.NewFiles, analysis.Delta.UnchangedFiles)
+	}
+	if analysis.Recommendation != core.StrategyRsync {
+		t.Errorf("Expected rsync when destination is mostly up to date, got %s", analysis.Recommendation)
+	}
+}
+
+func TestAnalyzeTransfer_HeuristicsJudgeFilesToSend(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+
+	// Many small files, all copied already: by the source alone this is a
+	// tar workload
+	for i := 0; i < 1200; i++ {
+		name := fmt.Sprintf("small%04d.txt", i)
+		for _, dir := range []string{srcDir, dstDir} {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+			if err := os.Chtimes(path, past, past); err != nil {
+				t.Fatalf("Failed to set times: %v", err)
+			}
+		}
+	}
+	// Three large files still to send, written just now
+	for i := 0; i < 3; i++ {
+		name := filepath.Join(srcDir, fmt.Sprintf("large%d.bin", i))
+		if err := os.WriteFile(name, make([]byte, 2*1024*1024), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	a := New().WithThresholds(1, 1, 1000, 10, 10000, 80, 50).
+		WithSettleWindow(time.Minute).
+		WithDestinationScan(true)
+	analysis, err := a.AnalyzeTransfer(context.Background(), srcDir, dstDir)
+	if err != nil {
+		t.Fatalf("AnalyzeTransfer failed: %v", err)
+	}
+
+	if analysis.Delta.LargeFiles != 3 || analysis.Delta.SmallFiles != 0 {
+		t.Errorf("Expected 3 large files to send, got %d large and %d small", analysis.Delta.LargeFiles, analysis.Delta.SmallFiles)
+	}
+	if analysis.Recommendation != core.StrategyRsync {
+		t.Errorf("Expected rsync for 3 files to send, got %s", analysis.Recommendation)
+	}
+	if !strings.Contains(analysis.RecommendReason, "only 3 files") {
+		t.Errorf("Expected the files to send in the reason, got %q", analysis.RecommendReason)
+	}
+	if !strings.Contains(analysis.RecommendReason, "may still be written") {
+		t.Errorf("Expected the note on active files kept, got %q", analysis.RecommendReason)
+	}
+}
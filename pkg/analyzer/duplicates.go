@@ -0,0 +1,192 @@
+package analyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+const (
+	// partialHashSize is how much of each file the partial hash covers
+	partialHashSize = 64 * 1024
+
+	// maxDuplicateGroups caps how many groups are listed in the analysis
+	maxDuplicateGroups = 20
+
+	// notableDedupRatio is the dedup ratio worth mentioning in the reason
+	notableDedupRatio = 1.1
+)
+
+// fileRef is a sampled file considered for duplicate detection
+type fileRef struct {
+	path string
+	size int64
+}
+
+// WithDuplicates enables hashing sampled files to find duplicate content.
+// Hashing reads file contents, so it is off by default.
+func (a *FileAnalyzer) WithDuplicates(enabled bool) *FileAnalyzer {
+	a.duplicates = enabled
+	return a
+}
+
+// findDuplicates narrows files down to identical content in three passes:
+// equal size, equal partial hash, equal full hash
+func findDuplicates(ctx context.Context, root string, files []fileRef) (*core.DuplicateAnalysis, error) {
+	startTime := time.Now()
+	result := &core.DuplicateAnalysis{}
+
+	// Pass 1: only files sharing a size can be duplicates
+	bySize := make(map[int64][]fileRef)
+	var totalBytes int64
+	for _, f := range files {
+		totalBytes += f.size
+		if f.size > 0 {
+			bySize[f.size] = append(bySize[f.size], f)
+		}
+	}
+
+	var groups []core.DuplicateGroup
+	for size, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+
+		// Pass 2: partial hash, unless the partial hash covers the whole file
+		if size > partialHashSize {
+			var err error
+			candidates, err = narrowByHash(ctx, candidates, partialHashSize, result)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Pass 3: full hash
+		byHash, err := groupByHash(ctx, candidates, -1, result)
+		if err != nil {
+			return nil, err
+		}
+
+		for hash, same := range byHash {
+			if len(same) < 2 {
+				continue
+			}
+			group := core.DuplicateGroup{Size: size, Hash: hash}
+			for _, f := range same {
+				rel, err := filepath.Rel(root, f.path)
+				if err != nil {
+					rel = f.path
+				}
+				group.Files = append(group.Files, rel)
+			}
+			sort.Strings(group.Files)
+			groups = append(groups, group)
+		}
+	}
+
+	for _, g := range groups {
+		copies := int64(len(g.Files) - 1)
+		result.DuplicateFiles += copies
+		result.DuplicateBytes += copies * g.Size
+	}
+	result.GroupCount = int64(len(groups))
+	result.FilesChecked = int64(len(files))
+
+	if unique := totalBytes - result.DuplicateBytes; unique > 0 {
+		result.DedupRatio = float64(totalBytes) / float64(unique)
+	} else {
+		result.DedupRatio = 1
+	}
+
+	// List the groups that waste the most space
+	sort.Slice(groups, func(i, j int) bool {
+		wi := int64(len(groups[i].Files)-1) * groups[i].Size
+		wj := int64(len(groups[j].Files)-1) * groups[j].Size
+		if wi != wj {
+			return wi > wj
+		}
+		return groups[i].Hash < groups[j].Hash
+	})
+	if len(groups) > maxDuplicateGroups {
+		groups = groups[:maxDuplicateGroups]
+	}
+	result.Groups = groups
+	result.ScanTime = time.Since(startTime)
+
+	return result, nil
+}
+
+// narrowByHash keeps only files whose hash of the first limit bytes is
+// shared with another file
+func narrowByHash(ctx context.Context, files []fileRef, limit int64, result *core.DuplicateAnalysis) ([]fileRef, error) {
+	byHash, err := groupByHash(ctx, files, limit, result)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []fileRef
+	for _, same := range byHash {
+		if len(same) > 1 {
+			kept = append(kept, same...)
+		}
+	}
+	return kept, nil
+}
+
+// groupByHash groups files by the hash of their first limit bytes, or the
+// whole file when limit is negative. Unreadable files are skipped.
+func groupByHash(ctx context.Context, files []fileRef, limit int64, result *core.DuplicateAnalysis) (map[string][]fileRef, error) {
+	byHash := make(map[string][]fileRef)
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		hash, read, err := hashFile(f.path, limit)
+		if err != nil {
+			continue
+		}
+		result.BytesHashed += read
+		byHash[hash] = append(byHash[hash], f)
+	}
+	return byHash, nil
+}
+
+// hashFile returns the SHA-256 of a file prefix and the bytes read
+func hashFile(path string, limit int64) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if limit >= 0 {
+		reader = io.LimitReader(file, limit)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, reader)
+	if err != nil {
+		return "", n, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// duplicateNote describes significant duplication for the recommendation
+func duplicateNote(d *core.DuplicateAnalysis) string {
+	if d == nil || d.DedupRatio < notableDedupRatio {
+		return ""
+	}
+	return fmt.Sprintf("; %s in %d duplicate files (dedup ratio %.2f), a deduplicating target would store less",
+		formatBytes(d.DuplicateBytes), d.DuplicateFiles, d.DedupRatio)
+}
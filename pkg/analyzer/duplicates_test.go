@@ -0,0 +1,63 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyze_Duplicates(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	large := bytes.Repeat([]byte("artifact"), 20*1024) // Larger than the partial hash
+	sameHead := append(append([]byte{}, large[:len(large)-1]...), 'X')
+
+	files := map[string][]byte{
+		"build1/app.jar": large,
+		"build2/app.jar": large,
+		"build3/app.jar": large,
+		"build3/other":   sameHead, // Same size and prefix, different tail
+		"a/readme.txt":   []byte("same small file"),
+		"b/readme.txt":   []byte("same small file"),
+		"c/unique.txt":   []byte("different file!"), // Same size, different content
+		"empty1":         nil,
+		"empty2":         nil,
+	}
+	for name, data := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	analysis, err := New().WithDuplicates(true).Analyze(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	d := analysis.Duplicates
+	if d == nil {
+		t.Fatal("Expected duplicate analysis")
+	}
+	if d.GroupCount != 2 {
+		t.Fatalf("Expected 2 duplicate groups, got %d: %+v", d.GroupCount, d.Groups)
+	}
+	if d.DuplicateFiles != 3 {
+		t.Errorf("Expected 3 duplicate copies, got %d", d.DuplicateFiles)
+	}
+	wantBytes := int64(2*len(large) + len("same small file"))
+	if d.DuplicateBytes != wantBytes {
+		t.Errorf("Expected %d duplicate bytes, got %d", wantBytes, d.DuplicateBytes)
+	}
+	if len(d.Groups[0].Files) != 3 || d.Groups[0].Files[0] != filepath.Join("build1", "app.jar") {
+		t.Errorf("Expected the jar group first, got %v", d.Groups[0].Files)
+	}
+	if d.DedupRatio < 1.5 {
+		t.Errorf("Expected a dedup ratio above 1.5, got %.2f", d.DedupRatio)
+	}
+}
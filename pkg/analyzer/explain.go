@@ -0,0 +1,128 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// explainStrategies are the strategies compared in explanations
+var explainStrategies = []core.Strategy{
+	core.StrategyRclone,
+	core.StrategyRsync,
+	core.StrategyTar,
+	core.StrategyProxy,
+}
+
+// stageOrder lists explain stages in precedence order
+var stageOrder = map[string]int{
+	core.ExplainProtocol:  0,
+	core.ExplainRule:      1,
+	core.ExplainHeuristic: 2,
+	core.ExplainHistory:   3,
+}
+
+// WithExplain records every check evaluated during strategy selection
+func (a *FileAnalyzer) WithExplain(enabled bool) *FileAnalyzer {
+	a.explain = enabled
+	return a
+}
+
+// startExplanation attaches a fresh explanation when explain mode is enabled
+func (a *FileAnalyzer) startExplanation(analysis *core.FileAnalysis) {
+	if a.explain {
+		analysis.Explanation = &core.Explanation{}
+	}
+}
+
+// record adds a step to the analysis explanation, if one is being recorded
+func record(analysis *core.FileAnalysis, step core.ExplainStep) {
+	if analysis.Explanation != nil {
+		analysis.Explanation.Steps = append(analysis.Explanation.Steps, step)
+	}
+}
+
+// decided notes the stage that made the current choice
+func decided(analysis *core.FileAnalysis, stage string) {
+	if analysis.Explanation != nil {
+		analysis.Explanation.DecidedBy = stage
+	}
+}
+
+// finishExplanation fills in the selected strategy and why each
+// alternative was rejected
+func finishExplanation(analysis *core.FileAnalysis) {
+	e := analysis.Explanation
+	if e == nil {
+		return
+	}
+
+	e.Selected = analysis.Recommendation
+	e.Rejected = nil
+
+	// Heuristics run first but rules take precedence, list in precedence order
+	sort.SliceStable(e.Steps, func(i, j int) bool {
+		return stageOrder[e.Steps[i].Stage] < stageOrder[e.Steps[j].Stage]
+	})
+
+	for _, strategy := range explainStrategies {
+		if strategy == e.Selected {
+			continue
+		}
+		e.Rejected = append(e.Rejected, core.RejectedStrategy{
+			Strategy: strategy,
+			Reason:   rejectionReason(analysis, strategy),
+		})
+	}
+}
+
+// rejectionReason explains why a strategy lost to the selected one
+func rejectionReason(analysis *core.FileAnalysis, strategy core.Strategy) string {
+	e := analysis.Explanation
+
+	if analysis.DestProtocol != "" && !strategySupports(strategy, analysis.SourceProtocol, analysis.DestProtocol) {
+		return fmt.Sprintf("does not support %s → %s", analysis.SourceProtocol, analysis.DestProtocol)
+	}
+
+	winning := decidingStep(e)
+
+	switch e.DecidedBy {
+	case core.ExplainRule:
+		return fmt.Sprintf("rule %q selected %s", analysis.MatchedRule, e.Selected)
+	case core.ExplainHistory:
+		return fmt.Sprintf("history favors %s: %s", e.Selected, winning.Measured)
+	case core.ExplainProtocol:
+		return winning.Summary() + fmt.Sprintf(", using %s", e.Selected)
+	}
+
+	// Heuristics: report the thresholds this strategy missed
+	var missed []string
+	for _, step := range e.Steps {
+		if step.Stage == core.ExplainHeuristic && step.Selects == strategy && !step.Passed {
+			missed = append(missed, fmt.Sprintf("%s %s, needs %s", step.Check, step.Measured, step.Threshold))
+		}
+	}
+	if len(missed) > 0 {
+		return strings.Join(missed, "; ")
+	}
+	if strategy == core.StrategyRclone {
+		return "fallback for mixed workloads, not reached"
+	}
+	if winning.Check != "" {
+		return fmt.Sprintf("not evaluated, %s selected %s first", winning.Summary(), e.Selected)
+	}
+	return "no heuristic selects it for this transfer"
+}
+
+// decidingStep returns the last passed step of the deciding stage
+func decidingStep(e *core.Explanation) core.ExplainStep {
+	for i := len(e.Steps) - 1; i >= 0; i-- {
+		step := e.Steps[i]
+		if step.Stage == e.DecidedBy && step.Passed {
+			return step
+		}
+	}
+	return core.ExplainStep{}
+}
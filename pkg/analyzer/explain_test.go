@@ -0,0 +1,88 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestAnalyze_Explain(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		name := filepath.Join(tmpDir, "file"+string(rune('a'+i))+".txt")
+		if err := os.WriteFile(name, []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	a := New().WithExplain(true)
+	analysis, err := a.AnalyzeTransfer(context.Background(), tmpDir, "user@host:/backup")
+	if err != nil {
+		t.Fatalf("AnalyzeTransfer failed: %v", err)
+	}
+
+	e := analysis.Explanation
+	if e == nil {
+		t.Fatal("Expected explanation")
+	}
+	if e.Selected != core.StrategyRsync || e.DecidedBy != core.ExplainHeuristic {
+		t.Errorf("Expected rsync by heuristic, got %s by %s", e.Selected, e.DecidedBy)
+	}
+
+	first := e.Steps[0]
+	if first.Check != "file count" || first.Measured != "5" || first.Threshold != "< 10" || !first.Passed {
+		t.Errorf("Unexpected first step: %+v", first)
+	}
+
+	if len(e.Rejected) != 3 {
+		t.Fatalf("Expected 3 rejected strategies, got %d", len(e.Rejected))
+	}
+	for _, r := range e.Rejected {
+		if r.Reason == "" {
+			t.Errorf("Expected a reason for rejecting %s", r.Strategy)
+		}
+		if r.Strategy == core.StrategyProxy && !strings.Contains(r.Reason, "does not support") {
+			t.Errorf("Expected proxy rejected for protocols, got %q", r.Reason)
+		}
+	}
+}
+
+func TestAnalyzeTransfer_ExplainRules(t *testing.T) {
+	a := New().WithExplain(true).WithRules([]core.StrategyRule{
+		{Name: "to-s3", Strategy: core.StrategyRsync, DestProtocols: []core.Protocol{core.ProtocolS3}},
+		{Name: "proxy-hosts", Strategy: core.StrategyRclone, SourceHosts: []string{"src*"}},
+	})
+
+	analysis, err := a.AnalyzeTransfer(context.Background(), "user@src1:/data", "user@dst:/data")
+	if err != nil {
+		t.Fatalf("AnalyzeTransfer failed: %v", err)
+	}
+
+	e := analysis.Explanation
+	if e.Selected != core.StrategyRclone || e.DecidedBy != core.ExplainRule {
+		t.Fatalf("Expected rclone by rule, got %s by %s", e.Selected, e.DecidedBy)
+	}
+
+	var failed, passed int
+	for _, step := range e.Steps {
+		if step.Stage != core.ExplainRule {
+			continue
+		}
+		if step.Passed {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	if failed != 1 || passed != 1 {
+		t.Errorf("Expected one failed and one passed rule condition, got %d/%d", failed, passed)
+	}
+
+	if !strings.Contains(e.String(), "Rejected:") {
+		t.Error("Expected text explanation to list rejected strategies")
+	}
+}
@@ -0,0 +1,213 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/benchmark"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/status"
+)
+
+const (
+	// minHistorySamples is how many completed transfers a strategy needs
+	// before its history is trusted
+	minHistorySamples = 2
+
+	// historyMargin is how much faster another strategy must have been
+	// to override the heuristic choice
+	historyMargin = 1.10
+)
+
+// Size profiles group transfers by average file size
+const (
+	profileSmallFiles = "small files"
+	profileLargeFiles = "large files"
+	profileMixed      = "mixed files"
+)
+
+// StrategyEvidence summarizes how a strategy performed in past transfers
+type StrategyEvidence struct {
+	Strategy    core.Strategy
+	Samples     int
+	BytesPerSec float64 // Median throughput
+}
+
+// WithHistory sets past transfers used to learn strategy performance
+func (a *FileAnalyzer) WithHistory(transfers []*status.TransferStatus) *FileAnalyzer {
+	a.history = transfers
+	return a
+}
+
+// sizeProfile classifies an average file size
+func (a *FileAnalyzer) sizeProfile(averageFileSize int64) string {
+	switch {
+	case averageFileSize < a.smallFileThreshold:
+		return profileSmallFiles
+	case averageFileSize > a.largeFileThreshold:
+		return profileLargeFiles
+	default:
+		return profileMixed
+	}
+}
+
+// historyEvidence collects per-strategy throughput of completed transfers
+// between the same hosts with the same size profile, best first
+func (a *FileAnalyzer) historyEvidence(source, destination, profile string) []StrategyEvidence {
+	sourceHost := benchmark.HostKey(source)
+	destHost := benchmark.HostKey(destination)
+
+	speeds := make(map[core.Strategy][]float64)
+	for _, transfer := range a.history {
+		if transfer.State != core.StateCompleted || transfer.EndTime == nil {
+			continue
+		}
+		if transfer.BytesDone <= 0 || transfer.FilesDone <= 0 {
+			continue
+		}
+		if benchmark.HostKey(transfer.Source) != sourceHost || benchmark.HostKey(transfer.Destination) != destHost {
+			continue
+		}
+		if a.sizeProfile(transfer.BytesDone/transfer.FilesDone) != profile {
+			continue
+		}
+
+		duration := transfer.EndTime.Sub(transfer.StartTime).Seconds()
+		if duration <= 0 {
+			continue
+		}
+		speeds[transfer.Strategy] = append(speeds[transfer.Strategy], float64(transfer.BytesDone)/duration)
+	}
+
+	var evidence []StrategyEvidence
+	for strategy, samples := range speeds {
+		if len(samples) < minHistorySamples {
+			continue
+		}
+		evidence = append(evidence, StrategyEvidence{
+			Strategy:    strategy,
+			Samples:     len(samples),
+			BytesPerSec: median(samples),
+		})
+	}
+
+	sort.Slice(evidence, func(i, j int) bool {
+		return evidence[i].BytesPerSec > evidence[j].BytesPerSec
+	})
+
+	return evidence
+}
+
+// applyHistory replaces the heuristic recommendation when another strategy
+// measurably performed better for similar transfers, and records the
+// evidence in the recommendation reason
+func (a *FileAnalyzer) applyHistory(analysis *core.FileAnalysis, source, destination string) {
+	if len(a.history) == 0 || analysis.TotalFiles == 0 {
+		return
+	}
+
+	averageFileSize := analysis.AverageFileSize
+	if analysis.Delta != nil && analysis.Delta.TransferFiles() > 0 {
+		averageFileSize = analysis.Delta.TransferBytes() / analysis.Delta.TransferFiles()
+	}
+	profile := a.sizeProfile(averageFileSize)
+
+	var evidence []StrategyEvidence
+	for _, e := range a.historyEvidence(source, destination, profile) {
+		if strategySupports(e.Strategy, analysis.SourceProtocol, analysis.DestProtocol) {
+			evidence = append(evidence, e)
+		}
+	}
+	if len(evidence) == 0 {
+		record(analysis, core.ExplainStep{
+			Stage:     core.ExplainHistory,
+			Check:     "matching transfers",
+			Measured:  fmt.Sprintf("none for %s", profile),
+			Threshold: fmt.Sprintf(">= %d per strategy", minHistorySamples),
+		})
+		return
+	}
+
+	best := evidence[0]
+	summary := formatEvidence(evidence, profile, source, destination)
+
+	// Only override when the current choice is unproven or clearly slower
+	override := best.Strategy != analysis.Recommendation
+	for _, e := range evidence {
+		if override && e.Strategy == analysis.Recommendation && best.BytesPerSec < e.BytesPerSec*historyMargin {
+			override = false
+		}
+	}
+
+	record(analysis, core.ExplainStep{
+		Stage:     core.ExplainHistory,
+		Check:     "fastest in history",
+		Measured:  summary,
+		Threshold: fmt.Sprintf(">= %.0f%% faster than %s", (historyMargin-1)*100, analysis.Recommendation),
+		Passed:    override,
+		Selects:   best.Strategy,
+	})
+
+	if !override {
+		analysis.RecommendReason += "; " + summary
+		return
+	}
+
+	analysis.Recommendation = best.Strategy
+	analysis.RecommendReason = fmt.Sprintf("%s recommended from history: %s",
+		strategyTitle(best.Strategy), summary)
+	decided(analysis, core.ExplainHistory)
+}
+
+// formatEvidence describes history evidence for a recommendation reason
+func formatEvidence(evidence []StrategyEvidence, profile, source, destination string) string {
+	parts := make([]string, 0, len(evidence))
+	for _, e := range evidence {
+		parts = append(parts, fmt.Sprintf("%s %s/s over %d transfers",
+			e.Strategy, formatBytes(int64(e.BytesPerSec)), e.Samples))
+	}
+	return fmt.Sprintf("%s for %s, %s → %s",
+		strings.Join(parts, " vs "), profile, benchmark.HostKey(source), benchmark.HostKey(destination))
+}
+
+// strategySupports reports whether a strategy can move data between protocols
+func strategySupports(strategy core.Strategy, source, dest core.Protocol) bool {
+	sshOrLocal := func(p core.Protocol) bool {
+		return p == core.ProtocolLocal || p == core.ProtocolSSH
+	}
+
+	switch strategy {
+	case core.StrategyRclone:
+		return true
+	case core.StrategyRsync:
+		return sshOrLocal(source) && sshOrLocal(dest) && !(source == core.ProtocolSSH && dest == core.ProtocolSSH)
+	case core.StrategyTar:
+		return (source == core.ProtocolLocal && sshOrLocal(dest)) || (source == core.ProtocolSSH && dest == core.ProtocolLocal)
+	case core.StrategyProxy:
+		return source == core.ProtocolSSH && dest == core.ProtocolSSH
+	default:
+		return false
+	}
+}
+
+// strategyTitle capitalizes a strategy name for messages
+func strategyTitle(strategy core.Strategy) string {
+	name := string(strategy)
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// median returns the median of a non-empty slice
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
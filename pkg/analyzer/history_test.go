@@ -0,0 +1,77 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/status"
+)
+
+// completedTransfer builds a finished transfer of 1000 small files
+func completedTransfer(strategy core.Strategy, destination string, duration time.Duration) *status.TransferStatus {
+	start := time.Now().Add(-time.Hour)
+	end := start.Add(duration)
+	return &status.TransferStatus{
+		State:       core.StateCompleted,
+		Strategy:    strategy,
+		Source:      "/data",
+		Destination: destination,
+		StartTime:   start,
+		EndTime:     &end,
+		BytesDone:   1000 * 1024,
+		FilesDone:   1000,
+	}
+}
+
+func TestApplyHistory_OverridesSlowerStrategy(t *testing.T) {
+	a := New().WithHistory([]*status.TransferStatus{
+		completedTransfer(core.StrategyRsync, "root@backup:/data", 10*time.Second),
+		completedTransfer(core.StrategyRsync, "root@backup:/data", 12*time.Second),
+		completedTransfer(core.StrategyTar, "root@backup:/data", 2*time.Second),
+		completedTransfer(core.StrategyTar, "root@backup:/data", 3*time.Second),
+		// Different host, must be ignored
+		completedTransfer(core.StrategyRclone, "root@other:/data", time.Second),
+		completedTransfer(core.StrategyRclone, "root@other:/data", time.Second),
+	})
+
+	analysis := &core.FileAnalysis{
+		TotalFiles:      50,
+		AverageFileSize: 1024,
+		SourceProtocol:  core.ProtocolLocal,
+		DestProtocol:    core.ProtocolSSH,
+		Recommendation:  core.StrategyRsync,
+		RecommendReason: "heuristic",
+	}
+
+	a.applyHistory(analysis, "/data", "root@backup:/data")
+
+	if analysis.Recommendation != core.StrategyTar {
+		t.Fatalf("Expected tar from history, got %s", analysis.Recommendation)
+	}
+	if !strings.Contains(analysis.RecommendReason, "over 2 transfers") {
+		t.Errorf("Expected evidence in reason, got %q", analysis.RecommendReason)
+	}
+}
+
+func TestApplyHistory_KeepsChoiceWithoutEnoughSamples(t *testing.T) {
+	a := New().WithHistory([]*status.TransferStatus{
+		completedTransfer(core.StrategyTar, "root@backup:/data", time.Second),
+	})
+
+	analysis := &core.FileAnalysis{
+		TotalFiles:      50,
+		AverageFileSize: 1024,
+		SourceProtocol:  core.ProtocolLocal,
+		DestProtocol:    core.ProtocolSSH,
+		Recommendation:  core.StrategyRsync,
+		RecommendReason: "heuristic",
+	}
+
+	a.applyHistory(analysis, "/data", "root@backup:/data")
+
+	if analysis.Recommendation != core.StrategyRsync || analysis.RecommendReason != "heuristic" {
+		t.Errorf("Expected recommendation unchanged, got %s (%s)", analysis.Recommendation, analysis.RecommendReason)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package analyzer
+
+import "os"
+
+// inode returns 0, inode numbers are not available on this platform
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
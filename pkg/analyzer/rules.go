@@ -0,0 +1,276 @@
+package analyzer
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// WithRules sets config rules evaluated in order before the built-in heuristics
+func (a *FileAnalyzer) WithRules(rules []core.StrategyRule) *FileAnalyzer {
+	a.rules = rules
+	return a
+}
+
+// ruleFacts are the transfer properties rules match against
+type ruleFacts struct {
+	sourceProtocol core.Protocol
+	destProtocol   core.Protocol
+	sourceHost     string
+	destHost       string
+	files          int64
+	totalSize      int64
+	classified     int64 // Sampled files counted by size class
+	smallFiles     int64
+	largeFiles     int64
+	fileTypes      map[string]int64
+}
+
+// newRuleFacts gathers rule inputs from an analysis
+func newRuleFacts(analysis *core.FileAnalysis, source, destination string) ruleFacts {
+	return ruleFacts{
+		sourceProtocol: analysis.SourceProtocol,
+		destProtocol:   analysis.DestProtocol,
+		sourceHost:     endpointHost(source),
+		destHost:       endpointHost(destination),
+		files:          analysis.TotalFiles,
+		totalSize:      analysis.TotalSize,
+		classified:     analysis.SmallFiles + analysis.MediumFiles + analysis.LargeFiles,
+		smallFiles:     analysis.SmallFiles,
+		largeFiles:     analysis.LargeFiles,
+		fileTypes:      analysis.FileTypes,
+	}
+}
+
+// applyRules applies the first matching rule to the analysis and reports
+// whether one matched
+func (a *FileAnalyzer) applyRules(analysis *core.FileAnalysis, source, destination string) bool {
+	facts := newRuleFacts(analysis, source, destination)
+
+	for i, rule := range a.rules {
+		name := ruleName(rule, i)
+
+		matched, steps := matchRule(rule, name, facts)
+		for _, step := range steps {
+			record(analysis, step)
+		}
+		if !matched {
+			continue
+		}
+
+		analysis.Recommendation = rule.Strategy
+		analysis.MatchedRule = name
+		analysis.Staging = rule.Staging
+		if rule.Staging != "" {
+			analysis.StagingRule = &rule
+		}
+		decided(analysis, core.ExplainRule)
+
+		conditions := make([]string, 0, len(steps))
+		for _, step := range steps {
+			conditions = append(conditions, strings.TrimPrefix(step.Summary(), fmt.Sprintf("rule %q ", name)))
+		}
+
+		reason := fmt.Sprintf("%s selected by rule %q: %s",
+			strategyTitle(rule.Strategy), name, strings.Join(conditions, ", "))
+		if rule.Staging != "" {
+			reason += fmt.Sprintf("; staging through %s", rule.Staging)
+		}
+		analysis.RecommendReason = reason
+		return true
+	}
+
+	return false
+}
+
+// matchRule checks the conditions of a rule in order, stopping at the first
+// one that fails, and returns the evaluated conditions
+func matchRule(rule core.StrategyRule, name string, facts ruleFacts) (bool, []core.ExplainStep) {
+	var steps []core.ExplainStep
+
+	check := func(ok bool, condition, measured, threshold string) bool {
+		steps = append(steps, core.ExplainStep{
+			Stage:     core.ExplainRule,
+			Check:     fmt.Sprintf("rule %q %s", name, condition),
+			Measured:  measured,
+			Threshold: threshold,
+			Passed:    ok,
+			Selects:   rule.Strategy,
+		})
+		return ok
+	}
+
+	if len(rule.SourceProtocols) > 0 &&
+		!check(containsProtocol(rule.SourceProtocols, facts.sourceProtocol),
+			"source protocol", string(facts.sourceProtocol), fmt.Sprintf("in %v", rule.SourceProtocols)) {
+		return false, steps
+	}
+	if len(rule.DestProtocols) > 0 &&
+		!check(containsProtocol(rule.DestProtocols, facts.destProtocol),
+			"destination protocol", string(facts.destProtocol), fmt.Sprintf("in %v", rule.DestProtocols)) {
+		return false, steps
+	}
+	if len(rule.SourceHosts) > 0 &&
+		!check(matchHost(rule.SourceHosts, facts.sourceHost),
+			"source host", facts.sourceHost, fmt.Sprintf("matches %v", rule.SourceHosts)) {
+		return false, steps
+	}
+	if len(rule.DestHosts) > 0 &&
+		!check(matchHost(rule.DestHosts, facts.destHost),
+			"destination host", facts.destHost, fmt.Sprintf("matches %v", rule.DestHosts)) {
+		return false, steps
+	}
+
+	files := fmt.Sprintf("%d", facts.files)
+	if rule.MinFiles > 0 &&
+		!check(facts.files >= rule.MinFiles, "files", files, fmt.Sprintf(">= %d", rule.MinFiles)) {
+		return false, steps
+	}
+	if rule.MaxFiles > 0 &&
+		!check(facts.files <= rule.MaxFiles, "files", files, fmt.Sprintf("<= %d", rule.MaxFiles)) {
+		return false, steps
+	}
+
+	totalSize := formatBytes(facts.totalSize)
+	if rule.MinTotalSize > 0 &&
+		!check(facts.totalSize >= rule.MinTotalSize, "total size", totalSize, ">= "+formatBytes(rule.MinTotalSize)) {
+		return false, steps
+	}
+	if rule.MaxTotalSize > 0 &&
+		!check(facts.totalSize <= rule.MaxTotalSize, "total size", totalSize, "<= "+formatBytes(rule.MaxTotalSize)) {
+		return false, steps
+	}
+
+	// Ratios need sampled files; remote sources without a listing never match
+	listed := facts.classified > 0
+	smallPercent := percentOf(facts.smallFiles, facts.classified)
+	largePercent := percentOf(facts.largeFiles, facts.classified)
+	ratio := func(percent float64) string {
+		if !listed {
+			return "no file listing"
+		}
+		return fmt.Sprintf("%.1f%%", percent)
+	}
+
+	if rule.MinSmallFilePercent > 0 &&
+		!check(listed && smallPercent >= rule.MinSmallFilePercent,
+			"small files", ratio(smallPercent), fmt.Sprintf(">= %.1f%%", rule.MinSmallFilePercent)) {
+		return false, steps
+	}
+	if rule.MaxSmallFilePercent > 0 &&
+		!check(listed && smallPercent <= rule.MaxSmallFilePercent,
+			"small files", ratio(smallPercent), fmt.Sprintf("<= %.1f%%", rule.MaxSmallFilePercent)) {
+		return false, steps
+	}
+	if rule.MinLargeFilePercent > 0 &&
+		!check(listed && largePercent >= rule.MinLargeFilePercent,
+			"large files", ratio(largePercent), fmt.Sprintf(">= %.1f%%", rule.MinLargeFilePercent)) {
+		return false, steps
+	}
+	if rule.MaxLargeFilePercent > 0 &&
+		!check(listed && largePercent <= rule.MaxLargeFilePercent,
+			"large files", ratio(largePercent), fmt.Sprintf("<= %.1f%%", rule.MaxLargeFilePercent)) {
+		return false, steps
+	}
+
+	if len(rule.Extensions) > 0 {
+		count, total := extensionCount(facts.fileTypes, rule.Extensions)
+		percent := percentOf(count, total)
+		threshold := "> 0%"
+		if rule.MinExtensionPercent > 0 {
+			threshold = fmt.Sprintf(">= %.1f%%", rule.MinExtensionPercent)
+		}
+		if !check(count > 0 && percent >= rule.MinExtensionPercent,
+			strings.Join(rule.Extensions, "/")+" files", fmt.Sprintf("%.1f%%", percent), threshold) {
+			return false, steps
+		}
+	}
+
+	// A rule without conditions matches everything
+	if len(steps) == 0 {
+		check(true, "has no conditions", "", "")
+	}
+
+	return true, steps
+}
+
+// ruleName returns the configured rule name or its position
+func ruleName(rule core.StrategyRule, index int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return fmt.Sprintf("rule %d", index+1)
+}
+
+// endpointHost returns the host of an endpoint for rule matching: the SSH
+// host, the bucket or host of a URL, or "local"
+func endpointHost(endpoint string) string {
+	switch detectProtocol(endpoint) {
+	case core.ProtocolLocal:
+		return "local"
+	case core.ProtocolSSH:
+		if loc, err := transport.ParseRemotePath(endpoint); err == nil {
+			return loc.Host
+		}
+		return ""
+	default:
+		rest := endpoint
+		if i := strings.Index(rest, "://"); i >= 0 {
+			rest = rest[i+3:]
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i]
+		}
+		return rest
+	}
+}
+
+// containsProtocol reports whether protocol is in the list
+func containsProtocol(protocols []core.Protocol, protocol core.Protocol) bool {
+	for _, p := range protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost reports whether host matches any glob pattern
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionCount counts sampled files with any of the extensions
+func extensionCount(fileTypes map[string]int64, extensions []string) (int64, int64) {
+	var count, total int64
+	for ext, n := range fileTypes {
+		total += n
+		for _, want := range extensions {
+			want = strings.ToLower(want)
+			if !strings.HasPrefix(want, ".") {
+				want = "." + want
+			}
+			if ext == want {
+				count += n
+				break
+			}
+		}
+	}
+	return count, total
+}
+
+// percentOf returns part as a percentage of whole
+func percentOf(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
@@ -0,0 +1,111 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestApplyRules_FirstMatchWins(t *testing.T) {
+	a := New().WithRules([]core.StrategyRule{
+		{
+			Name:          "cloud-logs",
+			Strategy:      core.StrategyRclone,
+			DestProtocols: []core.Protocol{core.ProtocolS3},
+		},
+		{
+			Name:      "archive",
+			Strategy:  core.StrategyRsync,
+			DestHosts: []string{"archive*"},
+		},
+		{
+			Name:     "catch-all",
+			Strategy: core.StrategyTar,
+		},
+	})
+
+	analysis := &core.FileAnalysis{
+		TotalFiles:     100,
+		SmallFiles:     100,
+		SourceProtocol: core.ProtocolLocal,
+		DestProtocol:   core.ProtocolSSH,
+		Recommendation: core.StrategyTar,
+	}
+
+	if !a.applyRules(analysis, "/data", "root@archive01:/backup") {
+		t.Fatal("Expected a rule to match")
+	}
+	if analysis.Recommendation != core.StrategyRsync || analysis.MatchedRule != "archive" {
+		t.Errorf("Expected rsync from rule archive, got %s from %q", analysis.Recommendation, analysis.MatchedRule)
+	}
+}
+
+func TestApplyRules_RatiosAndStaging(t *testing.T) {
+	rule := core.StrategyRule{
+		Name:                "small-to-s3",
+		Strategy:            core.StrategyTar,
+		Staging:             "root@staging:/tmp/stage",
+		DestProtocols:       []core.Protocol{core.ProtocolS3},
+		MinSmallFilePercent: 90,
+	}
+	a := New().WithRules([]core.StrategyRule{rule})
+
+	mostlySmall := &core.FileAnalysis{
+		TotalFiles:     100,
+		SmallFiles:     95,
+		MediumFiles:    5,
+		SourceProtocol: core.ProtocolLocal,
+		DestProtocol:   core.ProtocolS3,
+	}
+	if !a.applyRules(mostlySmall, "/data", "s3://bucket/data") {
+		t.Fatal("Expected rule to match 95% small files")
+	}
+	if mostlySmall.Staging != rule.Staging || mostlySmall.StagingRule == nil || mostlySmall.StagingRule.Name != rule.Name {
+		t.Errorf("Expected staging %s by rule %s, got %q", rule.Staging, rule.Name, mostlySmall.Staging)
+	}
+
+	mixed := &core.FileAnalysis{
+		TotalFiles:     100,
+		SmallFiles:     50,
+		LargeFiles:     50,
+		SourceProtocol: core.ProtocolLocal,
+		DestProtocol:   core.ProtocolS3,
+	}
+	if a.applyRules(mixed, "/data", "s3://bucket/data") {
+		t.Error("Expected rule not to match 50% small files")
+	}
+}
+
+func TestMatchRule_Extensions(t *testing.T) {
+	rule := core.StrategyRule{
+		Strategy:            core.StrategyRsync,
+		Extensions:          []string{"log", ".GZ"},
+		MinExtensionPercent: 60,
+	}
+	facts := ruleFacts{
+		fileTypes: map[string]int64{".log": 5, ".gz": 2, ".txt": 3},
+	}
+
+	if ok, _ := matchRule(rule, "logs", facts); !ok {
+		t.Error("Expected 70% .log/.gz files to match")
+	}
+
+	facts.fileTypes[".txt"] = 10
+	if ok, conditions := matchRule(rule, "logs", facts); ok {
+		t.Errorf("Expected no match, got %v", conditions)
+	}
+}
+
+func TestEndpointHost(t *testing.T) {
+	tests := map[string]string{
+		"/data":                 "local",
+		"root@backup:/srv/data": "backup",
+		"s3://bucket/prefix":    "bucket",
+		"https://example.com/x": "example.com",
+	}
+	for endpoint, want := range tests {
+		if got := endpointHost(endpoint); got != want {
+			t.Errorf("endpointHost(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
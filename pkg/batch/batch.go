@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
 )
 
 // Manifest represents the complete transfer plan with batches
@@ -37,6 +42,8 @@ type Batch struct {
 	CompletedAt time.Time `json:"completed_at,omitempty"`
 	Error       string   `json:"error,omitempty"`
 	Checksum    string   `json:"checksum,omitempty"` // For verification
+	Attempt     int      `json:"attempt,omitempty"`       // Retry round for files that changed while being read, 0 for the first pass
+	ChangedFiles []string `json:"changed_files,omitempty"` // Files that changed while being archived
 
 	mutex sync.RWMutex `json:"-"`
 }
@@ -53,6 +60,11 @@ type Config struct {
 	KeepOnFailure    bool   // Keep buffer on failure for resume
 	CheckpointPath   string // Path to save checkpoint state
 	CheckpointEnabled bool  // Enable checkpointing
+	Codec            codec.Codec // Requested batch compression, negotiated with the hosts
+	Preserve         core.PreserveOptions // Metadata tar keeps when creating and extracting batches
+	SourceJumps      []core.JumpHost // Jump hosts ssh goes through to the source
+	DestJumps        []core.JumpHost // Jump hosts ssh goes through to the destination
+	HostKeyPolicy    transport.HostKeyPolicy // How ssh verifies host keys, the transport default if empty
 }
 
 // DefaultConfig returns sensible defaults
@@ -68,7 +80,19 @@ func DefaultConfig() *Config {
 		KeepOnFailure:    true,
 		CheckpointPath:   "/tmp/difpipe-checkpoint.json",
 		CheckpointEnabled: true,
+		Codec:            codec.Codec{Name: core.CompressionAuto},
+		Preserve:         core.DefaultPreserve(),
+	}
+}
+
+// remoteTarFlags returns the tar options for the preserved metadata, double
+// quoted since remote commands are wrapped in single quotes
+func remoteTarFlags(preserve core.PreserveOptions) string {
+	var b strings.Builder
+	for _, flag := range preserve.TarFlags() {
+		fmt.Fprintf(&b, " \"%s\"", flag)
 	}
+	return b.String()
 }
 
 // NewManifest creates a new manifest
@@ -104,6 +128,26 @@ func (m *Manifest) AddBatch(files []string, size int64) *Batch {
 	return batch
 }
 
+// AddRetryBatch adds a batch resending files that changed while being read.
+// The files are already counted in the manifest totals.
+func (m *Manifest) AddRetryBatch(files []string, size int64, attempt int) *Batch {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	batch := &Batch{
+		ID:        len(m.Batches),
+		Files:     files,
+		Size:      size,
+		FileCount: len(files),
+		Status:    "pending",
+		Attempt:   attempt,
+	}
+
+	m.Batches = append(m.Batches, batch)
+
+	return batch
+}
+
 // GetPendingBatches returns all pending batches
 func (m *Manifest) GetPendingBatches() []*Batch {
 	m.mutex.RLock()
@@ -242,3 +286,17 @@ func (b *Batch) GetLocalPath() string {
 	defer b.mutex.RUnlock()
 	return b.LocalPath
 }
+
+// SetChangedFiles records files that changed while being archived
+func (b *Batch) SetChangedFiles(files []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.ChangedFiles = files
+}
+
+// GetChangedFiles returns files that changed while being archived
+func (b *Batch) GetChangedFiles() []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.ChangedFiles
+}
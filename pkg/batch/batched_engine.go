@@ -24,6 +24,7 @@ type BatchedEngine struct {
 	stopChan     chan struct{}
 	errorChan    chan error
 	completed    bool
+	retryAttempt int // Latest retry round for files that changed while being read
 }
 
 // NewBatchedEngine creates a new batched tar engine
@@ -61,6 +62,22 @@ func (be *BatchedEngine) Transfer(source, destination string) error {
 		}
 	}
 
+	// Parse source and destination
+	sourceHost, sourcePath := parseRemoteLocation(source)
+	destHost, destPath := parseRemoteLocation(destination)
+
+	// Compress batches with a codec every end of the pipeline has
+	negotiated, err := be.negotiateCodec(sourceHost, destHost)
+	if err != nil {
+		return fmt.Errorf("negotiate compression: %w", err)
+	}
+	if requested := be.config.Codec; requested.Enabled() && negotiated.Name != requested.Name {
+		fmt.Printf("Warning: %s is not available on every host, using %s\n", requested.Name, negotiated.Name)
+	}
+	config := *be.config
+	config.Codec = negotiated
+	be.config = &config
+
 	// Initialize buffer manager
 	be.bufferMgr = NewBufferManager(be.config)
 	if err := be.bufferMgr.Initialize(); err != nil {
@@ -70,10 +87,6 @@ func (be *BatchedEngine) Transfer(source, destination string) error {
 	fmt.Printf("Buffer initialized: max %.2f GB at %s\n",
 		float64(be.bufferMgr.GetMaxSize())/(1024*1024*1024), be.config.BufferPath)
 
-	// Parse source and destination
-	sourceHost, sourcePath := parseRemoteLocation(source)
-	destHost, destPath := parseRemoteLocation(destination)
-
 	// Create worker pools
 	be.sourcePool = NewSourceWorkerPool(manifest, be.bufferMgr, be.sourceAuth, sourceHost, sourcePath, be.config)
 	be.destPool = NewDestWorkerPool(manifest, be.bufferMgr, be.destAuth, destHost, destPath, be.config)
@@ -97,6 +110,13 @@ func (be *BatchedEngine) Transfer(source, destination string) error {
 	manifest.SetStatus("completed")
 	fmt.Printf("Transfer completed successfully: %d batches\n", len(manifest.Batches))
 
+	if changed := be.ChangedFiles(); len(changed) > 0 {
+		fmt.Printf("Warning: %d files kept changing while being read and may be inconsistent:\n", len(changed))
+		for _, f := range changed {
+			fmt.Printf("  %s\n", f)
+		}
+	}
+
 	return nil
 }
 
@@ -172,9 +192,19 @@ func (be *BatchedEngine) coordinateTransfer() error {
 				}
 				enqueuedMutex.Unlock()
 
-				// Check if all batches are completed
+				// Check if all batches are completed, then resend files
+				// that changed while being read
 				if be.allBatchesCompleted() {
-					return
+					retry := be.nextRetryBatch()
+					if retry == nil {
+						return
+					}
+					fmt.Printf("Retrying %d files that changed while being read (attempt %d)\n",
+						retry.FileCount, retry.Attempt)
+					if err := be.sourcePool.EnqueueBatch(retry); err != nil {
+						transferErr <- fmt.Errorf("enqueue retry batch: %w", err)
+						return
+					}
 				}
 			}
 		}
@@ -213,6 +243,55 @@ func (be *BatchedEngine) allBatchesCompleted() bool {
 	return true
 }
 
+// nextRetryBatch creates a batch for files that changed while being read in
+// the latest round, or returns nil when there are none or retries are used up
+func (be *BatchedEngine) nextRetryBatch() *Batch {
+	be.mutex.Lock()
+	defer be.mutex.Unlock()
+
+	if be.retryAttempt >= MaxChangeRetries {
+		return nil
+	}
+
+	var files []string
+	var size int64
+	for _, batch := range be.manifest.Batches {
+		changed := batch.GetChangedFiles()
+		if batch.Attempt != be.retryAttempt || len(changed) == 0 {
+			continue
+		}
+		files = append(files, changed...)
+		if batch.FileCount > 0 {
+			size += batch.Size * int64(len(changed)) / int64(batch.FileCount)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	be.retryAttempt++
+	return be.manifest.AddRetryBatch(files, size, be.retryAttempt)
+}
+
+// ChangedFiles returns files that were still changing when read for the
+// last time, their copies at the destination may be inconsistent
+func (be *BatchedEngine) ChangedFiles() []string {
+	be.mutex.RLock()
+	defer be.mutex.RUnlock()
+
+	if be.manifest == nil {
+		return nil
+	}
+
+	var changed []string
+	for _, batch := range be.manifest.Batches {
+		if batch.Attempt == be.retryAttempt {
+			changed = append(changed, batch.GetChangedFiles()...)
+		}
+	}
+	return changed
+}
+
 // cleanup performs cleanup based on success/failure
 func (be *BatchedEngine) cleanup(success bool) {
 	// Stop workers
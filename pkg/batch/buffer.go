@@ -49,7 +49,7 @@ func (bm *BufferManager) Initialize() error {
 
 // GetBatchPath returns the path for a batch file
 func (bm *BufferManager) GetBatchPath(manifestID string, batchID int) string {
-	return filepath.Join(bm.path, manifestID, fmt.Sprintf("batch_%05d.tar.gz", batchID))
+	return filepath.Join(bm.path, manifestID, fmt.Sprintf("batch_%05d.tar%s", batchID, bm.config.Codec.Extension()))
 }
 
 // ReserveSpace reserves space in the buffer for a batch
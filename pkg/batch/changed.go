@@ -0,0 +1,121 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// MaxChangeRetries is how often files that changed while being archived
+// are sent again in a retry batch at the end of the run
+const MaxChangeRetries = 2
+
+var (
+	// changedFilePattern matches GNU tar warnings about files modified
+	// while they were being archived
+	changedFilePattern = regexp.MustCompile(`^tar: (.+): (?:file changed as we read it|File shrank by \d+ bytes; padding with zeros)$`)
+
+	// harmlessTarPatterns match diagnostics that accompany those warnings
+	harmlessTarPatterns = []*regexp.Regexp{
+		regexp.MustCompile("^tar: Removing leading `/' from member names$"),
+		regexp.MustCompile(`^tar: Exiting with failure status due to previous errors$`),
+	}
+)
+
+// parseChangedFiles returns the files tar reported as changed while being
+// read. ok is false when the output holds any other diagnostic, in which
+// case the archive cannot be trusted.
+func parseChangedFiles(output string) (files []string, ok bool) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if m := changedFilePattern.FindStringSubmatch(line); m != nil {
+			files = append(files, m[1])
+			continue
+		}
+
+		if !isHarmless(line) {
+			return nil, false
+		}
+	}
+
+	return files, len(files) > 0
+}
+
+// checkTarOutput decides whether a tar run succeeded and returns the files
+// it reported as changed. When tar was piped into a compressor its exit
+// status is lost, so any diagnostic besides change warnings fails the run.
+func checkTarOutput(output string, err error, piped bool) ([]string, error) {
+	changed, ok := parseChangedFiles(output)
+	if err != nil {
+		if !ok || !isTarWarningExit(err) {
+			return nil, fmt.Errorf("tar failed: %w (output: %s)", err, output)
+		}
+		return changed, nil
+	}
+
+	if piped && !ok && !onlyHarmless(output) {
+		return nil, fmt.Errorf("tar failed (output: %s)", output)
+	}
+	return changed, nil
+}
+
+// onlyHarmless reports whether output holds nothing but harmless diagnostics
+func onlyHarmless(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !isHarmless(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// isHarmless reports whether a line of tar output can be ignored
+func isHarmless(line string) bool {
+	for _, p := range harmlessTarPatterns {
+		if p.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTarWarningExit reports whether tar exited with the status it uses when
+// files changed while being read (1, or 2 when a file shrank)
+func isTarWarningExit(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	code := exitErr.ExitCode()
+	return code == 1 || code == 2
+}
+
+// matchBatchFiles maps names reported by tar back to the batch's file
+// paths, tar strips leading slashes
+func matchBatchFiles(batch *Batch, names []string) []string {
+	byName := make(map[string]string, len(batch.Files))
+	for _, f := range batch.Files {
+		byName[strings.TrimPrefix(strings.TrimPrefix(f, "./"), "/")] = f
+	}
+
+	matched := make([]string, 0, len(names))
+	for _, name := range names {
+		key := strings.TrimPrefix(strings.TrimPrefix(name, "./"), "/")
+		if f, ok := byName[key]; ok {
+			matched = append(matched, f)
+		} else {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,60 @@
+package batch
+
+import (
+	"testing"
+)
+
+func TestParseChangedFiles(t *testing.T) {
+	output := "tar: Removing leading `/' from member names\n" +
+		"tar: /data/logs/app.log: file changed as we read it\n" +
+		"tar: /data/logs/old.log: File shrank by 120 bytes; padding with zeros\n" +
+		"tar: Exiting with failure status due to previous errors\n"
+
+	files, ok := parseChangedFiles(output)
+	if !ok {
+		t.Fatal("expected only change warnings to be accepted")
+	}
+	if len(files) != 2 || files[0] != "/data/logs/app.log" || files[1] != "/data/logs/old.log" {
+		t.Errorf("unexpected files: %v", files)
+	}
+
+	if _, ok := parseChangedFiles("tar: /data/x: Cannot open: Permission denied\n"); ok {
+		t.Error("expected other errors to be rejected")
+	}
+	if _, ok := parseChangedFiles(""); ok {
+		t.Error("expected empty output to be rejected")
+	}
+}
+
+func TestMatchBatchFiles(t *testing.T) {
+	batch := &Batch{Files: []string{"/data/logs/app.log", "sub/a.txt"}}
+
+	got := matchBatchFiles(batch, []string{"data/logs/app.log", "./sub/a.txt", "unknown"})
+	want := []string{"/data/logs/app.log", "sub/a.txt", "unknown"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestCheckTarOutput(t *testing.T) {
+	changedOutput := "tar: logs/app.log: file changed as we read it\n"
+
+	if _, err := checkTarOutput("", nil, false); err != nil {
+		t.Errorf("clean run failed: %v", err)
+	}
+
+	// Piped into a compressor, tar's status is lost and its output decides
+	changed, err := checkTarOutput(changedOutput, nil, true)
+	if err != nil || len(changed) != 1 || changed[0] != "logs/app.log" {
+		t.Errorf("piped change warning = %v, %v", changed, err)
+	}
+	if _, err := checkTarOutput("tar: logs: Cannot open: Permission denied\n", nil, true); err == nil {
+		t.Error("expected piped tar errors to fail")
+	}
+	if _, err := checkTarOutput("tar: Removing leading `/' from member names\n", nil, true); err != nil {
+		t.Errorf("harmless piped output failed: %v", err)
+	}
+}
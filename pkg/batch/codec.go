@@ -0,0 +1,140 @@
+package batch
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// negotiateCodec picks the batch codec from the tools available where
+// batches are compressed and extracted: locally for local ends, on the
+// host for remote ones
+func (be *BatchedEngine) negotiateCodec(sourceHost, destHost string) (codec.Codec, error) {
+	requested := be.config.Codec
+	if requested.Name == core.CompressionNone {
+		return requested, nil
+	}
+
+	var ends [][]core.Compression
+	if sourceHost == "" || destHost == "" {
+		ends = append(ends, codec.LocalAvailable())
+	}
+	if sourceHost != "" {
+		available, err := probeCodecs(be.sourceAuth, be.config.sshOptions(be.config.SourceJumps), sourceHost)
+		if err != nil {
+			return codec.Codec{}, fmt.Errorf("source: %w", err)
+		}
+		ends = append(ends, available)
+	}
+	if destHost != "" {
+		available, err := probeCodecs(be.destAuth, be.config.sshOptions(be.config.DestJumps), destHost)
+		if err != nil {
+			return codec.Codec{}, fmt.Errorf("destination: %w", err)
+		}
+		ends = append(ends, available)
+	}
+
+	return codec.Negotiate(requested, codec.Intersect(ends...)), nil
+}
+
+// probeCodecs lists the codec tools installed on a remote host
+func probeCodecs(auth map[string]interface{}, options []string, host string) ([]core.Compression, error) {
+	output, err := sshCommand(auth, options, host, codec.ProbeCommand).Output()
+	if err != nil {
+		return nil, fmt.Errorf("probe codecs on %s: %w", host, err)
+	}
+	return codec.ParseProbe(string(output)), nil
+}
+
+// sshCommand builds a command running remoteCmd on host with the ssh
+// options, and the username and password from the auth map
+func sshCommand(auth map[string]interface{}, options []string, host, remoteCmd string) *exec.Cmd {
+	username := "root" // default
+	password := ""
+
+	if auth != nil {
+		if u, ok := auth["username"].(string); ok {
+			username = u
+		}
+		if p, ok := auth["password"].(string); ok {
+			password = p
+		}
+	}
+
+	args := append(options, fmt.Sprintf("%s@%s", username, host), remoteCmd)
+	if password == "" {
+		return exec.Command("ssh", args...)
+	}
+
+	// Use sshpass with env var to avoid shell escaping issues
+	cmd := exec.Command("sshpass", append([]string{"-e", "ssh"}, args...)...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("SSHPASS=%s", password))
+	return cmd
+}
+
+// sshOptions returns the options of every batch ssh command: host key
+// checking under the policy, with difpipe's known_hosts file read and new
+// keys recorded there first, connection sharing, and -J through the jump
+// hosts. ssh logs in to jump hosts with the agent or default keys; auth set
+// for them is only used by the SSH transport.
+func (c *Config) sshOptions(jumps []core.JumpHost) []string {
+	policy := c.HostKeyPolicy
+	if policy == "" {
+		policy = transport.DefaultHostKeyPolicy()
+	}
+
+	options := []string{"-o", "StrictHostKeyChecking=accept-new"}
+	switch policy {
+	case transport.HostKeyInsecure:
+		options = []string{"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null"}
+	case transport.HostKeyStrict:
+		options = []string{"-o", "StrictHostKeyChecking=yes"}
+	}
+	if known := transport.NewKnownHosts(policy); policy != transport.HostKeyInsecure && known.File != "" {
+		options = append(options, "-o", fmt.Sprintf("UserKnownHostsFile=\"%s\" \"%s\"", known.File, known.UserFile))
+	}
+
+	// The workers' commands to a host share one connection, which stays
+	// open for a minute after the last
+	if dir, err := controlDir(); err == nil {
+		options = append(options, "-o", "ControlMaster=auto", "-o", "ControlPath="+filepath.Join(dir, "%C"), "-o", "ControlPersist=60")
+	}
+
+	if len(jumps) > 0 {
+		hosts := make([]string, len(jumps))
+		for i, jump := range jumps {
+			hosts[i] = jump.Host
+		}
+		options = append(options, "-J", strings.Join(hosts, ","))
+	}
+	return options
+}
+
+// controlDir returns the directory of ssh's connection sharing sockets,
+// ~/.difpipe/ssh
+func controlDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".difpipe", "ssh")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// shellWords quotes arguments for a shell command line
+func shellWords(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = transport.ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,42 @@
+package batch
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+func TestSSHOptions(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	knownHosts := `UserKnownHostsFile="` + filepath.Join(home, ".difpipe", "known_hosts") +
+		`" "` + filepath.Join(home, ".ssh", "known_hosts") + `"`
+	sharing := []string{"-o", "ControlMaster=auto", "-o", "ControlPath=" + filepath.Join(home, ".difpipe", "ssh", "%C"), "-o", "ControlPersist=60"}
+
+	config := &Config{HostKeyPolicy: transport.HostKeyInsecure}
+	want := append([]string{"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null"}, sharing...)
+	if got := config.sshOptions(nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("options = %v, want %v", got, want)
+	}
+
+	config.HostKeyPolicy = transport.HostKeyStrict
+	want = append([]string{"-o", "StrictHostKeyChecking=yes", "-o", knownHosts}, sharing...)
+	if got := config.sshOptions(nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("options = %v, want %v", got, want)
+	}
+
+	config.HostKeyPolicy = transport.HostKeyTOFU
+	jumps := []core.JumpHost{{Host: "ops@bastion:2222"}, {Host: "root@inner"}}
+	want = append(append([]string{"-o", "StrictHostKeyChecking=accept-new", "-o", knownHosts}, sharing...), "-J", "ops@bastion:2222,root@inner")
+	if got := config.sshOptions(jumps); !reflect.DeepEqual(got, want) {
+		t.Errorf("options = %v, want %v", got, want)
+	}
+
+	want = []string{"-o", "StrictHostKeyChecking=no", "-J", "ops@bastion:2222,root@inner"}
+	if got := shellWords(want); got != `'-o' 'StrictHostKeyChecking=no' '-J' 'ops@bastion:2222,root@inner'` {
+		t.Errorf("shell words = %s", got)
+	}
+}
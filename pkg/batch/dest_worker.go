@@ -121,13 +121,17 @@ func (dwp *DestWorkerPool) processBatch(batch *Batch) error {
 	return nil
 }
 
-// extractTarArchive extracts a tar.gz archive to the destination
+// extractTarArchive extracts a batch archive to the destination
 func (dwp *DestWorkerPool) extractTarArchive(batch *Batch, archivePath string) error {
 	var cmd *exec.Cmd
 
 	if dwp.destHost == "" {
 		// Local filesystem - use tar directly
-		cmd = exec.Command("tar", "xzf", archivePath, "-C", dwp.destPath)
+		args := append([]string{"-xf", archivePath, "-C", dwp.destPath}, dwp.config.Preserve.TarFlags()...)
+		if dwp.config.Codec.Enabled() {
+			args = append([]string{"-I", dwp.config.Codec.CompressCommand()}, args...)
+		}
+		cmd = exec.Command("tar", args...)
 	} else {
 		// Remote via SSH - stream tar over SSH
 		username := "root" // default
@@ -143,19 +147,23 @@ func (dwp *DestWorkerPool) extractTarArchive(batch *Batch, archivePath string) e
 		}
 
 		// Build remote tar command
-		// cat archive | ssh tar xzf - -C <path>
-		remoteCmd := fmt.Sprintf("tar xzf - -C %s", dwp.destPath)
+		// cat archive | ssh [decompress |] tar xf - -C <path>
+		remoteCmd := fmt.Sprintf("tar xf - -C %s%s", dwp.destPath, remoteTarFlags(dwp.config.Preserve))
+		if dwp.config.Codec.Enabled() {
+			remoteCmd = dwp.config.Codec.DecompressCommand() + " | " + remoteCmd
+		}
 
+		options := shellWords(dwp.config.sshOptions(dwp.config.DestJumps))
 		if password != "" {
 			// Use sshpass for password auth - use env var to avoid shell escaping issues
 			cmd = exec.Command("bash", "-c",
-				fmt.Sprintf("cat '%s' | SSHPASS='%s' sshpass -e ssh -o StrictHostKeyChecking=no %s@%s '%s'",
-					archivePath, password, username, dwp.destHost, remoteCmd))
+				fmt.Sprintf("cat '%s' | SSHPASS='%s' sshpass -e ssh %s %s@%s '%s'",
+					archivePath, password, options, username, dwp.destHost, remoteCmd))
 		} else {
 			// Use SSH without password (key auth)
 			cmd = exec.Command("bash", "-c",
-				fmt.Sprintf("cat '%s' | ssh -o StrictHostKeyChecking=no %s@%s '%s'",
-					archivePath, username, dwp.destHost, remoteCmd))
+				fmt.Sprintf("cat '%s' | ssh %s %s@%s '%s'",
+					archivePath, options, username, dwp.destHost, remoteCmd))
 		}
 	}
 
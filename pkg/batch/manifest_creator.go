@@ -10,8 +10,9 @@ import (
 
 // FileInfo represents a file with its size
 type FileInfo struct {
-	Path string
-	Size int64
+	Path  string
+	Size  int64
+	Inode uint64 // Set when the file has more than one hard link
 }
 
 // ManifestCreator creates manifests by enumerating and batching files
@@ -68,7 +69,7 @@ func (mc *ManifestCreator) enumerateFiles(host, path string) ([]FileInfo, error)
 
 	if host == "" {
 		// Local filesystem
-		cmd = exec.Command("find", path, "-type", "f", "-printf", "%s %p\\n")
+		cmd = exec.Command("find", path, "(", "-type", "f", "-o", "-type", "l", ")", "-printf", "%s %i %n %p\\n")
 	} else {
 		// Remote via SSH - extract username and password from auth map
 		username := "root" // default
@@ -85,16 +86,15 @@ func (mc *ManifestCreator) enumerateFiles(host, path string) ([]FileInfo, error)
 
 		// Expand ~ in path by cd'ing into it first
 		// This makes all paths relative to that directory
-		findCmd := fmt.Sprintf("cd %s && find . -type f -printf '%%s %%p\\n'", path)
+		findCmd := fmt.Sprintf("cd %s && find . \\( -type f -o -type l \\) -printf '%%s %%i %%n %%p\\n'", path)
 
+		args := append(mc.config.sshOptions(mc.config.SourceJumps), fmt.Sprintf("%s@%s", username, host), findCmd)
 		if password != "" {
 			// Use sshpass with env var to avoid shell escaping issues
-			cmd = exec.Command("sshpass", "-e", "ssh", "-o", "StrictHostKeyChecking=no",
-				fmt.Sprintf("%s@%s", username, host), findCmd)
+			cmd = exec.Command("sshpass", append([]string{"-e", "ssh"}, args...)...)
 			cmd.Env = append(cmd.Env, fmt.Sprintf("SSHPASS=%s", password))
 		} else {
-			cmd = exec.Command("ssh", "-o", "StrictHostKeyChecking=no",
-				fmt.Sprintf("%s@%s", username, host), findCmd)
+			cmd = exec.Command("ssh", args...)
 		}
 	}
 
@@ -108,8 +108,8 @@ func (mc *ManifestCreator) enumerateFiles(host, path string) ([]FileInfo, error)
 	scanner := bufio.NewScanner(strings.NewReader(string(output)))
 	for scanner.Scan() {
 		line := scanner.Text()
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) != 2 {
+		parts := strings.SplitN(line, " ", 4)
+		if len(parts) != 4 {
 			continue // Skip malformed lines
 		}
 
@@ -117,16 +117,22 @@ func (mc *ManifestCreator) enumerateFiles(host, path string) ([]FileInfo, error)
 		if err != nil {
 			continue // Skip unparseable sizes
 		}
+		inode, _ := strconv.ParseUint(parts[1], 10, 64)
+		links, _ := strconv.Atoi(parts[2])
+		if links < 2 {
+			inode = 0
+		}
 
 		// Make path relative (remove leading ./)
-		relPath := strings.TrimPrefix(parts[1], "./")
+		relPath := strings.TrimPrefix(parts[3], "./")
 		if relPath == "" || relPath == "." {
 			continue // Skip empty or current directory
 		}
 
 		files = append(files, FileInfo{
-			Path: relPath,
-			Size: size,
+			Path:  relPath,
+			Size:  size,
+			Inode: inode,
 		})
 	}
 
@@ -152,9 +158,10 @@ func (mc *ManifestCreator) binPackFiles(files []FileInfo) []batchInfo {
 		size:  0,
 	}
 
-	for _, file := range files {
+	for _, group := range linkGroups(files, mc.config.Preserve.Hardlinks) {
 		// If adding this file would exceed target, start new batch
-		if currentBatch.size > 0 && currentBatch.size+file.Size > targetSize {
+		size := group[0].Size // Linked names share their contents
+		if currentBatch.size > 0 && currentBatch.size+size > targetSize {
 			batches = append(batches, currentBatch)
 			currentBatch = batchInfo{
 				files: []string{},
@@ -163,8 +170,10 @@ func (mc *ManifestCreator) binPackFiles(files []FileInfo) []batchInfo {
 		}
 
 		// Add file to current batch
-		currentBatch.files = append(currentBatch.files, file.Path)
-		currentBatch.size += file.Size
+		for _, file := range group {
+			currentBatch.files = append(currentBatch.files, file.Path)
+		}
+		currentBatch.size += size
 	}
 
 	// Add final batch if not empty
@@ -175,6 +184,25 @@ func (mc *ManifestCreator) binPackFiles(files []FileInfo) []batchInfo {
 	return batches
 }
 
+// linkGroups splits files into the groups packed together. With hardlinks
+// every name of a linked file is in one group, so tar archives the later
+// names as links; otherwise each file is its own group.
+func linkGroups(files []FileInfo, hardlinks bool) [][]FileInfo {
+	groups := make([][]FileInfo, 0, len(files))
+	byInode := make(map[uint64]int)
+	for _, file := range files {
+		if hardlinks && file.Inode != 0 {
+			if i, ok := byInode[file.Inode]; ok {
+				groups[i] = append(groups[i], file)
+				continue
+			}
+			byInode[file.Inode] = len(groups)
+		}
+		groups = append(groups, []FileInfo{file})
+	}
+	return groups
+}
+
 // parseLocation parses a location string (host:path or just path)
 func (mc *ManifestCreator) parseLocation(location string) (host, path string, err error) {
 	// Check for user@host:path format
@@ -142,3 +142,32 @@ func TestCreateManifestStructure(t *testing.T) {
 		t.Errorf("expected 2 batches, got %d", len(batches))
 	}
 }
+
+func TestBinPackFiles_KeepsHardlinksTogether(t *testing.T) {
+	config := DefaultConfig()
+	config.ChunkSizeMB = 1
+
+	mc := NewManifestCreator(nil, nil, config)
+
+	files := []FileInfo{
+		{Path: "a.bin", Size: 600 * 1024, Inode: 42},
+		{Path: "b.bin", Size: 600 * 1024},
+		{Path: "links/a.bin", Size: 600 * 1024, Inode: 42},
+	}
+
+	batches := mc.binPackFiles(files)
+	if len(batches) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(batches))
+	}
+	if len(batches[0].files) != 2 || batches[0].files[1] != "links/a.bin" {
+		t.Errorf("batch 0: expected both names of a.bin, got %v", batches[0].files)
+	}
+	if batches[0].size != 600*1024 {
+		t.Errorf("batch 0: linked contents counted twice, size %d", batches[0].size)
+	}
+
+	config.Preserve.Hardlinks = false
+	if batches := mc.binPackFiles(files); len(batches) != 3 {
+		t.Errorf("without hardlinks expected 3 batches, got %d", len(batches))
+	}
+}
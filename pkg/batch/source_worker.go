@@ -166,13 +166,19 @@ func (swp *SourceWorkerPool) createFileList(batch *Batch) (string, error) {
 	return fileListPath, nil
 }
 
-// createTarArchive creates a tar.gz archive from the source files
+// createTarArchive creates a tar archive from the source files, compressed
+// with the negotiated codec
 func (swp *SourceWorkerPool) createTarArchive(batch *Batch, fileListPath, outputPath string) error {
 	var cmd *exec.Cmd
+	piped := false // tar's exit status is lost in a pipe to the compressor
 
 	if swp.sourceHost == "" {
 		// Local filesystem - use tar directly
-		cmd = exec.Command("tar", "czf", outputPath, "-C", swp.sourcePath, "-T", fileListPath)
+		args := append([]string{"-cf", outputPath, "-C", swp.sourcePath, "-T", fileListPath}, swp.config.Preserve.TarFlags()...)
+		if swp.config.Codec.Enabled() {
+			args = append([]string{"-I", swp.config.Codec.CompressCommand()}, args...)
+		}
+		cmd = exec.Command("tar", args...)
 	} else {
 		// Remote via SSH - stream tar over SSH
 		username := "root" // default
@@ -189,25 +195,35 @@ func (swp *SourceWorkerPool) createTarArchive(batch *Batch, fileListPath, output
 
 		// Build remote tar command - cd into directory first then use relative paths
 		// This matches how we enumerate files (cd && find .)
-		remoteCmd := fmt.Sprintf("cd %s && tar czf - -T -", swp.sourcePath)
+		remoteCmd := fmt.Sprintf("cd %s && tar cf - -T -%s", swp.sourcePath, remoteTarFlags(swp.config.Preserve))
+		if swp.config.Codec.Enabled() {
+			remoteCmd += " | " + swp.config.Codec.CompressCommand()
+			piped = true
+		}
 
+		options := shellWords(swp.config.sshOptions(swp.config.SourceJumps))
 		if password != "" {
 			// Use sshpass for password auth - use env var to avoid shell escaping issues
 			cmd = exec.Command("bash", "-c",
-				fmt.Sprintf("cat '%s' | SSHPASS='%s' sshpass -e ssh -o StrictHostKeyChecking=no %s@%s '%s' > '%s'",
-					fileListPath, password, username, swp.sourceHost, remoteCmd, outputPath))
+				fmt.Sprintf("cat '%s' | SSHPASS='%s' sshpass -e ssh %s %s@%s '%s' > '%s'",
+					fileListPath, password, options, username, swp.sourceHost, remoteCmd, outputPath))
 		} else {
 			// Use SSH without password (key auth)
 			cmd = exec.Command("bash", "-c",
-				fmt.Sprintf("cat '%s' | ssh -o StrictHostKeyChecking=no %s@%s '%s' > '%s'",
-					fileListPath, username, swp.sourceHost, remoteCmd, outputPath))
+				fmt.Sprintf("cat '%s' | ssh %s %s@%s '%s' > '%s'",
+					fileListPath, options, username, swp.sourceHost, remoteCmd, outputPath))
 		}
 	}
 
-	// Run tar command
+	// Run tar command; files that changed while being read are still
+	// archived (cut or padded to their stat size) and are retried later
 	output, err := cmd.CombinedOutput()
+	changed, err := checkTarOutput(string(output), err, piped)
 	if err != nil {
-		return fmt.Errorf("tar failed: %w (output: %s)", err, string(output))
+		return err
+	}
+	if len(changed) > 0 {
+		batch.SetChangedFiles(matchBatchFiles(batch, changed))
 	}
 
 	// Verify archive was created
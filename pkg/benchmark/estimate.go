@@ -0,0 +1,122 @@
+package benchmark
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// LocalHost is the profile key for the local machine
+const LocalHost = "local"
+
+// HostKey returns the profile key for an endpoint
+func HostKey(endpoint string) string {
+	if isSSHPath(endpoint) {
+		if loc, err := transport.ParseRemotePath(endpoint); err == nil {
+			return loc.Host
+		}
+	}
+	if i := strings.Index(endpoint, "://"); i > 0 {
+		// Cloud and URL endpoints are keyed by scheme and bucket/host
+		rest := endpoint[i+3:]
+		if j := strings.Index(rest, "/"); j >= 0 {
+			rest = rest[:j]
+		}
+		return endpoint[:i] + "_" + rest
+	}
+	return LocalHost
+}
+
+// Estimate is a transfer time estimate
+type Estimate struct {
+	Duration     time.Duration
+	Speed        string
+	BytesPerSec  float64
+	Measured     bool // Based on stored benchmarks rather than the fallback
+	PerFileDelay time.Duration
+}
+
+// EstimateTransfer estimates how long moving bytes in files from source to
+// destination takes. Stored benchmark profiles for either endpoint are used
+// when present; otherwise fallbackBytesPerSec is assumed.
+func EstimateTransfer(source, destination string, bytes, files int64, fallbackBytesPerSec float64) *Estimate {
+	var sourceProfile, destProfile *Profile
+	if store, err := NewStore(""); err == nil {
+		sourceProfile, _ = store.Load(HostKey(source))
+		destProfile, _ = store.Load(HostKey(destination))
+	}
+
+	return estimateWith(source, destination, sourceProfile, destProfile, bytes, files, fallbackBytesPerSec)
+}
+
+// estimateWith computes an estimate from the given profiles. The slowest
+// measured leg bounds throughput and per-file overhead is added on top.
+func estimateWith(source, destination string, sourceProfile, destProfile *Profile, bytes, files int64, fallbackBytesPerSec float64) *Estimate {
+	var rates []float64
+	var overhead time.Duration
+
+	if sourceProfile != nil {
+		if isSSHPath(source) {
+			rates = append(rates, sourceProfile.StreamReadBytesPerSec)
+		} else {
+			rates = append(rates, sourceProfile.ReadBytesPerSec)
+		}
+		overhead = max(overhead, sourceProfile.PerFileOverhead)
+	}
+	if destProfile != nil && isSSHPath(destination) {
+		rates = append(rates, destProfile.StreamWriteBytesPerSec)
+		overhead = max(overhead, destProfile.PerFileOverhead)
+	}
+
+	bytesPerSec := 0.0
+	for _, r := range rates {
+		if r > 0 && (bytesPerSec == 0 || r < bytesPerSec) {
+			bytesPerSec = r
+		}
+	}
+
+	estimate := &Estimate{
+		Measured:     bytesPerSec > 0,
+		PerFileDelay: overhead,
+	}
+	if !estimate.Measured {
+		bytesPerSec = fallbackBytesPerSec
+	}
+	estimate.BytesPerSec = bytesPerSec
+
+	if bytesPerSec > 0 {
+		seconds := float64(bytes) / bytesPerSec
+		estimate.Duration = time.Duration(seconds*float64(time.Second)) + overhead*time.Duration(files)
+	}
+
+	if estimate.Measured {
+		estimate.Speed = fmt.Sprintf("~%s (measured)", formatSpeed(bytesPerSec))
+	} else {
+		estimate.Speed = fmt.Sprintf("~%.0f MB/s", bytesPerSec/(1024*1024))
+	}
+
+	return estimate
+}
+
+// isSSHPath checks for the user@host:path form, or host:path for an ssh
+// config alias
+func isSSHPath(path string) bool {
+	return transport.IsRemotePath(path)
+}
+
+// formatSpeed formats bytes per second as human-readable string
+func formatSpeed(bytesPerSec float64) string {
+	const unit = 1024
+	if bytesPerSec < unit {
+		return fmt.Sprintf("%.0f B/s", bytesPerSec)
+	}
+	div, exp := float64(unit), 0
+	for n := bytesPerSec / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	units := []string{"KB/s", "MB/s", "GB/s", "TB/s"}
+	return fmt.Sprintf("%.1f %s", bytesPerSec/div, units[exp])
+}
@@ -0,0 +1,52 @@
+package benchmark
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostKey(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected string
+	}{
+		{"/data/source", "local"},
+		{"root@backup.example.com:/srv", "backup.example.com"},
+		{"s3://bucket/prefix", "s3_bucket"},
+	}
+
+	for _, tt := range tests {
+		if got := HostKey(tt.endpoint); got != tt.expected {
+			t.Errorf("HostKey(%s) = %s, want %s", tt.endpoint, got, tt.expected)
+		}
+	}
+}
+
+func TestEstimateWith_Fallback(t *testing.T) {
+	estimate := estimateWith("/src", "/dst", nil, nil, 100*1024*1024, 1, 100*1024*1024)
+
+	if estimate.Measured {
+		t.Error("Expected fallback estimate without profiles")
+	}
+	if estimate.Duration != time.Second {
+		t.Errorf("Expected 1s, got %v", estimate.Duration)
+	}
+	if estimate.Speed != "~100 MB/s" {
+		t.Errorf("Expected ~100 MB/s, got %s", estimate.Speed)
+	}
+}
+
+func TestEstimateWith_SlowestLegAndOverhead(t *testing.T) {
+	source := &Profile{ReadBytesPerSec: 400 * 1024 * 1024, PerFileOverhead: time.Millisecond}
+	dest := &Profile{StreamWriteBytesPerSec: 10 * 1024 * 1024, PerFileOverhead: 2 * time.Millisecond}
+
+	estimate := estimateWith("/src", "user@host:/dst", source, dest, 100*1024*1024, 1000, 50*1024*1024)
+
+	if !estimate.Measured {
+		t.Fatal("Expected measured estimate")
+	}
+	// 10s at the SSH write rate plus 1000 files at 2ms
+	if estimate.Duration != 12*time.Second {
+		t.Errorf("Expected 12s, got %v", estimate.Duration)
+	}
+}
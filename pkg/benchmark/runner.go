@@ -0,0 +1,325 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// smallFileSize is the size of each file used to measure per-file overhead
+const smallFileSize = 4 * 1024
+
+// Runner measures throughput for an endpoint
+type Runner struct {
+	sampleBytes int64
+	smallFiles  int
+	authConfig  map[string]interface{}
+	transport   transport.Transport
+}
+
+// NewRunner creates a runner with default sample sizes
+func NewRunner() *Runner {
+	return &Runner{
+		sampleBytes: 256 * 1024 * 1024, // 256 MB
+		smallFiles:  500,
+		transport:   transport.Shared(),
+	}
+}
+
+// WithSampleSize sets how much data to move for throughput measurements
+func (r *Runner) WithSampleSize(sizeMB int) *Runner {
+	r.sampleBytes = int64(sizeMB) * 1024 * 1024
+	return r
+}
+
+// WithSmallFiles sets how many small files to use for per-file overhead
+func (r *Runner) WithSmallFiles(count int) *Runner {
+	r.smallFiles = count
+	return r
+}
+
+// WithAuth sets the auth config used for SSH endpoints
+func (r *Runner) WithAuth(authConfig map[string]interface{}) *Runner {
+	r.authConfig = authConfig
+	return r
+}
+
+// Run benchmarks an endpoint (local path or user@host:path)
+func (r *Runner) Run(ctx context.Context, endpoint string) (*Profile, error) {
+	profile := &Profile{
+		Host:        HostKey(endpoint),
+		Endpoint:    endpoint,
+		MeasuredAt:  time.Now(),
+		SampleFiles: r.smallFiles,
+	}
+
+	var err error
+	switch {
+	case isSSHPath(endpoint):
+		err = r.runSSH(ctx, endpoint, profile)
+	case strings.Contains(endpoint, "://"):
+		return nil, fmt.Errorf("benchmark supports local paths and user@host:path endpoints, got %s", endpoint)
+	default:
+		err = r.runLocal(ctx, endpoint, profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// runLocal measures disk throughput and small-file overhead
+func (r *Runner) runLocal(ctx context.Context, path string, profile *Profile) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat endpoint: %w", err)
+	}
+
+	scratchParent := path
+	if !info.IsDir() {
+		scratchParent = filepath.Dir(path)
+	}
+
+	scratch, err := os.MkdirTemp(scratchParent, ".difpipe-bench-*")
+	if err != nil {
+		// Endpoint not writable, measure on the system temp dir instead
+		scratch, err = os.MkdirTemp("", "difpipe-bench-*")
+		if err != nil {
+			return fmt.Errorf("create scratch dir: %w", err)
+		}
+	}
+	defer os.RemoveAll(scratch)
+
+	// Prefer existing data, it is less likely to be in the page cache
+	files, total := collectFiles(ctx, path, scratch, r.sampleBytes)
+	if total >= r.sampleBytes/4 {
+		start := time.Now()
+		read, err := readFiles(ctx, files)
+		if err != nil {
+			return fmt.Errorf("read sample: %w", err)
+		}
+		profile.SampleBytes = read
+		profile.ReadBytesPerSec = rate(read, time.Since(start))
+	} else {
+		// A sample written here would be read back from the page cache, so
+		// the synced write is timed instead
+		start := time.Now()
+		if err := writeSample(filepath.Join(scratch, "sample.dat"), r.sampleBytes); err != nil {
+			return fmt.Errorf("write sample: %w", err)
+		}
+		profile.SampleBytes = r.sampleBytes
+		profile.ReadBytesPerSec = rate(r.sampleBytes, time.Since(start))
+	}
+
+	// Per-file overhead: open/read/close many small files
+	smallDir := filepath.Join(scratch, "small")
+	if err := os.Mkdir(smallDir, 0755); err != nil {
+		return fmt.Errorf("create small file dir: %w", err)
+	}
+	payload := make([]byte, smallFileSize)
+	smallPaths := make([]string, r.smallFiles)
+	for i := range smallPaths {
+		smallPaths[i] = filepath.Join(smallDir, fmt.Sprintf("f%05d", i))
+		if err := os.WriteFile(smallPaths[i], payload, 0644); err != nil {
+			return fmt.Errorf("write small file: %w", err)
+		}
+	}
+
+	start := time.Now()
+	if _, err := readFiles(ctx, smallPaths); err != nil {
+		return fmt.Errorf("read small files: %w", err)
+	}
+	profile.PerFileOverhead = perFileOverhead(time.Since(start), r.smallFiles, profile.ReadBytesPerSec)
+
+	return nil
+}
+
+// runSSH measures stream throughput in both directions and small-file
+// overhead of a remote tar stream
+func (r *Runner) runSSH(ctx context.Context, endpoint string, profile *Profile) error {
+	loc, err := transport.ParseRemotePath(endpoint)
+	if err != nil {
+		return err
+	}
+
+	auth, err := transport.ResolveAuth(r.authConfig, "DIFPIPE_SOURCE_PASSWORD")
+	if err != nil {
+		return fmt.Errorf("get authentication: %w", err)
+	}
+
+	client, err := r.transport.Connect(ctx, loc.SSHConfig(auth))
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer r.transport.Close(client)
+
+	profile.SampleBytes = r.sampleBytes
+
+	// Host to us
+	reader, err := r.transport.StreamCommand(ctx, client, fmt.Sprintf("head -c %d /dev/zero", r.sampleBytes))
+	if err != nil {
+		return fmt.Errorf("start read stream: %w", err)
+	}
+	start := time.Now()
+	read, err := io.Copy(io.Discard, reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("read stream: %w", err)
+	}
+	profile.StreamReadBytesPerSec = rate(read, time.Since(start))
+
+	// Us to host
+	writer, err := r.transport.StreamWrite(ctx, client, "cat > /dev/null")
+	if err != nil {
+		return fmt.Errorf("start write stream: %w", err)
+	}
+	start = time.Now()
+	written, err := io.CopyN(writer, zeroReader{}, r.sampleBytes)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write stream: %w", err)
+	}
+	profile.StreamWriteBytesPerSec = rate(written, time.Since(start))
+
+	// Per-file overhead: tar up many small files on the host
+	setup := fmt.Sprintf(
+		"d=$(mktemp -d) && i=0 && while [ $i -lt %d ]; do head -c %d /dev/zero > \"$d/f$i\"; i=$((i+1)); done && echo \"$d\"",
+		r.smallFiles, smallFileSize)
+	result, err := r.transport.ExecuteCommand(ctx, client, setup)
+	if err != nil {
+		return fmt.Errorf("create small files: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("create small files: %s", strings.TrimSpace(string(result.Stderr)))
+	}
+	scratch := strings.TrimSpace(string(result.Stdout))
+	defer r.transport.ExecuteCommand(ctx, client, "rm -rf "+transport.ShellQuote(scratch))
+
+	reader, err = r.transport.StreamCommand(ctx, client, "tar -cf - -C "+transport.ShellQuote(scratch)+" .")
+	if err != nil {
+		return fmt.Errorf("start tar stream: %w", err)
+	}
+	start = time.Now()
+	_, err = io.Copy(io.Discard, reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("read tar stream: %w", err)
+	}
+	profile.PerFileOverhead = perFileOverhead(time.Since(start), r.smallFiles, profile.StreamReadBytesPerSec)
+
+	return nil
+}
+
+// collectFiles picks regular files under root until limit bytes are gathered
+func collectFiles(ctx context.Context, root, skip string, limit int64) ([]string, int64) {
+	var files []string
+	var total int64
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || ctx.Err() != nil {
+			return nil // Skip errors
+		}
+		if d.IsDir() && path == skip {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, path)
+		total += info.Size()
+		if total >= limit {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	return files, total
+}
+
+// readFiles reads files fully and returns the bytes read
+func readFiles(ctx context.Context, files []string) (int64, error) {
+	buf := make([]byte, 1024*1024)
+	var total int64
+
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			continue // Skip unreadable files
+		}
+		n, err := io.CopyBuffer(io.Discard, file, buf)
+		file.Close()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// writeSample writes a file of the given size and syncs it to disk
+func writeSample(path string, size int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(file, zeroReader{}, size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// perFileOverhead subtracts the data transfer time from the elapsed time
+// and spreads the remainder over the files
+func perFileOverhead(elapsed time.Duration, files int, bytesPerSec float64) time.Duration {
+	if files == 0 {
+		return 0
+	}
+
+	dataTime := time.Duration(0)
+	if bytesPerSec > 0 {
+		dataTime = time.Duration(float64(files*smallFileSize) / bytesPerSec * float64(time.Second))
+	}
+
+	overhead := (elapsed - dataTime) / time.Duration(files)
+	if overhead < 0 {
+		return 0
+	}
+	return overhead
+}
+
+// rate returns bytes per second
+func rate(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) / elapsed.Seconds()
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,68 @@
+package benchmark
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunner_Local(t *testing.T) {
+	dir := t.TempDir()
+	runner := NewRunner().WithSampleSize(1).WithSmallFiles(10)
+
+	// Too little data to read, the sample write is timed
+	profile, err := runner.Run(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Host != LocalHost || profile.SampleBytes != 1024*1024 || profile.ReadBytesPerSec <= 0 {
+		t.Errorf("profile = %+v", profile)
+	}
+
+	// Existing data is read
+	if err := os.WriteFile(filepath.Join(dir, "data"), make([]byte, 512*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	profile, err = runner.Run(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.SampleBytes != 512*1024 || profile.ReadBytesPerSec <= 0 {
+		t.Errorf("profile = %+v", profile)
+	}
+
+	// The scratch directory is removed
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("%d entries left in the endpoint, want 1", len(entries))
+	}
+}
+
+func TestRunner_RejectsURLs(t *testing.T) {
+	if _, err := NewRunner().Run(context.Background(), "s3://bucket/prefix"); err == nil {
+		t.Error("Expected an error for a cloud endpoint")
+	}
+}
+
+func TestPerFileOverhead(t *testing.T) {
+	tests := []struct {
+		elapsed     time.Duration
+		files       int
+		bytesPerSec float64
+		expected    time.Duration
+	}{
+		{time.Second, 0, 0, 0},
+		{time.Second, 100, 0, 10 * time.Millisecond},
+		// 100 files of 4 KB at 400 KB/s take 1s to move, leaving 1s of overhead
+		{2 * time.Second, 100, 400 * 1024, 10 * time.Millisecond},
+		{time.Second, 100, 100 * 1024, 0},
+	}
+
+	for _, tt := range tests {
+		if got := perFileOverhead(tt.elapsed, tt.files, tt.bytesPerSec); got != tt.expected {
+			t.Errorf("perFileOverhead(%v, %d, %.0f) = %v, want %v", tt.elapsed, tt.files, tt.bytesPerSec, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Profile holds measured throughput for one host
+type Profile struct {
+	Host                   string        `json:"host"`
+	Endpoint               string        `json:"endpoint"`
+	MeasuredAt             time.Time     `json:"measured_at"`
+	ReadBytesPerSec        float64       `json:"read_bytes_per_sec,omitempty"`         // Local disk read, or synced write without enough data to read
+	StreamReadBytesPerSec  float64       `json:"stream_read_bytes_per_sec,omitempty"`  // SSH, host to us
+	StreamWriteBytesPerSec float64       `json:"stream_write_bytes_per_sec,omitempty"` // SSH, us to host
+	PerFileOverhead        time.Duration `json:"per_file_overhead"`
+	SampleBytes            int64         `json:"sample_bytes"`
+	SampleFiles            int           `json:"sample_files"`
+}
+
+// Store persists benchmark profiles, one file per host
+type Store struct {
+	dir string
+}
+
+// NewStore creates a profile store. The directory is created when the
+// first profile is saved.
+func NewStore(dir string) (*Store, error) {
+	// Default to ~/.difpipe/benchmarks
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("get home dir: %w", err)
+		}
+		dir = filepath.Join(home, ".difpipe", "benchmarks")
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Save stores a profile, replacing any earlier one for the same host
+func (s *Store) Save(profile *Profile) error {
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal profile: %w", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("create benchmark dir: %w", err)
+	}
+	if err := os.WriteFile(s.profilePath(profile.Host), data, 0644); err != nil {
+		return fmt.Errorf("write profile: %w", err)
+	}
+
+	return nil
+}
+
+// Load loads the profile for a host
+func (s *Store) Load(host string) (*Profile, error) {
+	data, err := os.ReadFile(s.profilePath(host))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no benchmark for host: %s", host)
+		}
+		return nil, fmt.Errorf("read profile: %w", err)
+	}
+
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("unmarshal profile: %w", err)
+	}
+
+	return &profile, nil
+}
+
+// List returns all stored profiles
+func (s *Store) List() ([]*Profile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil // Nothing saved yet
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read benchmark dir: %w", err)
+	}
+
+	var profiles []*Profile
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		profile, err := s.Load(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// profilePath returns the file path for a host's profile
+func (s *Store) profilePath(host string) string {
+	// Host names are safe file names apart from IPv6 colons
+	return filepath.Join(s.dir, strings.ReplaceAll(host, ":", "_")+".json")
+}
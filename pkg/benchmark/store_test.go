@@ -0,0 +1,60 @@
+package benchmark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_SaveLoadList(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "benchmarks")
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening a store does not create its directory
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("store dir created before saving: %v", err)
+	}
+	if profiles, err := store.List(); err != nil || len(profiles) != 0 {
+		t.Fatalf("List() = %v, %v before saving", profiles, err)
+	}
+	if _, err := store.Load("backup.example.com"); err == nil {
+		t.Error("Expected an error loading an unknown host")
+	}
+
+	profile := &Profile{Host: "fe80::1", StreamReadBytesPerSec: 1024, PerFileOverhead: time.Millisecond}
+	if err := store.Save(profile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "fe80__1.json")); err != nil {
+		t.Errorf("profile file: %v", err)
+	}
+
+	loaded, err := store.Load("fe80::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.StreamReadBytesPerSec != 1024 || loaded.PerFileOverhead != time.Millisecond {
+		t.Errorf("loaded %+v", loaded)
+	}
+
+	if profiles, err := store.List(); err != nil || len(profiles) != 1 {
+		t.Errorf("List() = %v, %v", profiles, err)
+	}
+}
+
+func TestEstimateTransfer_NoStoreDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	estimate := EstimateTransfer("/src", "/dst", 1024, 1, 1024)
+	if estimate.Measured || estimate.Duration != time.Second {
+		t.Errorf("estimate = %+v", estimate)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".difpipe")); !os.IsNotExist(err) {
+		t.Errorf("estimating created %s/.difpipe: %v", home, err)
+	}
+}
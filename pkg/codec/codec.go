@@ -0,0 +1,196 @@
+package codec
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// Preference is the order codecs are picked in when compression is auto
+var Preference = []core.Compression{core.CompressionZstd, core.CompressionLz4, core.CompressionGzip}
+
+// levelRanges are the accepted compression levels per codec
+var levelRanges = map[core.Compression][2]int{
+	core.CompressionGzip: {1, 9},
+	core.CompressionZstd: {1, 19},
+	core.CompressionLz4:  {1, 9},
+}
+
+// binaries are the command line tools that handle each codec on a host
+var binaries = map[core.Compression]string{
+	core.CompressionGzip: "gzip",
+	core.CompressionZstd: "zstd",
+	core.CompressionLz4:  "lz4",
+}
+
+// Codec compresses and decompresses streams with one algorithm
+type Codec struct {
+	Name  core.Compression
+	Level int // 0 uses the codec's default
+}
+
+// New validates a codec name and level. An empty name is auto.
+func New(name core.Compression, level int) (Codec, error) {
+	if name == "" {
+		name = core.CompressionAuto
+	}
+
+	switch name {
+	case core.CompressionAuto, core.CompressionNone:
+		return Codec{Name: name, Level: level}, nil
+	}
+
+	levels, ok := levelRanges[name]
+	if !ok {
+		return Codec{}, fmt.Errorf("unknown compression: %s", name)
+	}
+	if level != 0 && (level < levels[0] || level > levels[1]) {
+		return Codec{}, fmt.Errorf("%s level must be between %d and %d, got %d", name, levels[0], levels[1], level)
+	}
+	return Codec{Name: name, Level: level}, nil
+}
+
+// Enabled reports whether the codec compresses at all
+func (c Codec) Enabled() bool {
+	_, ok := binaries[c.Name]
+	return ok
+}
+
+// Binary returns the command line tool for the codec, empty when disabled
+func (c Codec) Binary() string {
+	return binaries[c.Name]
+}
+
+// Extension returns the file extension of compressed streams, e.g. ".zst"
+func (c Codec) Extension() string {
+	switch c.Name {
+	case core.CompressionGzip:
+		return ".gz"
+	case core.CompressionZstd:
+		return ".zst"
+	case core.CompressionLz4:
+		return ".lz4"
+	}
+	return ""
+}
+
+// NewWriter compresses everything written to it into w. Closing it flushes
+// the stream but does not close w.
+func (c Codec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.Name {
+	case core.CompressionGzip:
+		level := gzip.DefaultCompression
+		if c.Level != 0 {
+			level = c.Level
+		}
+		return gzip.NewWriterLevel(w, level)
+
+	case core.CompressionZstd:
+		level := zstd.SpeedDefault
+		if c.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+
+	case core.CompressionLz4:
+		writer := lz4.NewWriter(w)
+		if c.Level != 0 {
+			if err := writer.Apply(lz4.CompressionLevelOption(lz4Level(c.Level))); err != nil {
+				return nil, err
+			}
+		}
+		return writer, nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// NewReader decompresses r
+func (c Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch c.Name {
+	case core.CompressionGzip:
+		return gzip.NewReader(r)
+
+	case core.CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+
+	case core.CompressionLz4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// CompressCommand returns a shell command compressing stdin to stdout, empty
+// when the codec is disabled. GNU tar also accepts it for -I.
+func (c Codec) CompressCommand() string {
+	binary := c.Binary()
+	if binary == "" {
+		return ""
+	}
+
+	args := []string{binary, "-c"}
+	if binary != "gzip" {
+		args = append(args, "-q")
+	}
+	if c.Level != 0 {
+		args = append(args, fmt.Sprintf("-%d", c.Level))
+	}
+	return strings.Join(args, " ")
+}
+
+// DecompressCommand returns a shell command decompressing stdin to stdout,
+// empty when the codec is disabled
+func (c Codec) DecompressCommand() string {
+	binary := c.Binary()
+	if binary == "" {
+		return ""
+	}
+	if binary == "gzip" {
+		return "gzip -d -c"
+	}
+	return binary + " -q -d -c"
+}
+
+// CompressOutput wraps a shell command so its output is compressed. The
+// wrapper exits with the command's status, which a plain pipe would lose.
+func (c Codec) CompressOutput(cmd string) string {
+	if !c.Enabled() {
+		return cmd
+	}
+	return fmt.Sprintf("exec 4>&1; status=$( { { ( %s ); echo $? >&3; } | %s >&4; } 3>&1 ); exit $status",
+		cmd, c.CompressCommand())
+}
+
+// lz4Level maps levels 1-9 to the library's levels, 1 being fastest
+func lz4Level(level int) lz4.CompressionLevel {
+	return lz4.CompressionLevel(1 << (8 + level))
+}
+
+// nopWriteCloser passes writes through uncompressed
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// LocalAvailable returns the codecs whose command line tools are in PATH,
+// in preference order
+func LocalAvailable() []core.Compression {
+	var available []core.Compression
+	for _, name := range Preference {
+		if _, err := exec.LookPath(binaries[name]); err == nil {
+			available = append(available, name)
+		}
+	}
+	return available
+}
@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("difpipe streams compress well. ", 1000))
+
+	for _, name := range []core.Compression{core.CompressionNone, core.CompressionGzip, core.CompressionZstd, core.CompressionLz4} {
+		for _, level := range []int{0, 1, 9} {
+			c, err := New(name, level)
+			if err != nil {
+				t.Fatalf("New(%s, %d): %v", name, level, err)
+			}
+
+			var buf bytes.Buffer
+			writer, err := c.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("%s: writer: %v", name, err)
+			}
+			if _, err := writer.Write(data); err != nil {
+				t.Fatalf("%s: write: %v", name, err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("%s: close: %v", name, err)
+			}
+			if c.Enabled() && buf.Len() >= len(data) {
+				t.Errorf("%s level %d: %d bytes not compressed", name, level, buf.Len())
+			}
+
+			reader, err := c.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("%s: reader: %v", name, err)
+			}
+			got, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s level %d: round trip failed: %v", name, level, err)
+			}
+		}
+	}
+}
+
+func TestNew_RejectsBadInput(t *testing.T) {
+	if _, err := New("brotli", 0); err == nil {
+		t.Error("expected unknown codec to be rejected")
+	}
+	if _, err := New(core.CompressionGzip, 12); err == nil {
+		t.Error("expected gzip level 12 to be rejected")
+	}
+	if c, err := New("", 0); err != nil || c.Name != core.CompressionAuto {
+		t.Errorf("empty name = %v, %v, want auto", c.Name, err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	zstd := Codec{Name: core.CompressionZstd, Level: 3}
+	if got := zstd.CompressCommand(); got != "zstd -c -q -3" {
+		t.Errorf("compress = %q", got)
+	}
+	if got := zstd.DecompressCommand(); got != "zstd -q -d -c" {
+		t.Errorf("decompress = %q", got)
+	}
+	if got := (Codec{Name: core.CompressionNone}).CompressCommand(); got != "" {
+		t.Errorf("none compress = %q", got)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	remote := ParseProbe("gzip\nzstd\n")
+	local := []core.Compression{core.CompressionGzip, core.CompressionLz4, core.CompressionZstd}
+	common := Intersect(local, remote)
+
+	tests := []struct {
+		requested Codec
+		want      core.Compression
+	}{
+		{Codec{Name: core.CompressionAuto}, core.CompressionZstd},
+		{Codec{Name: core.CompressionGzip, Level: 9}, core.CompressionGzip},
+		{Codec{Name: core.CompressionLz4}, core.CompressionZstd},
+		{Codec{Name: core.CompressionNone}, core.CompressionNone},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.requested, common); got.Name != tt.want {
+			t.Errorf("Negotiate(%s) = %s, want %s", tt.requested.Name, got.Name, tt.want)
+		}
+	}
+
+	if got := Negotiate(Codec{Name: core.CompressionAuto}, nil); got.Name != core.CompressionNone {
+		t.Errorf("nothing available = %s, want none", got.Name)
+	}
+	if got := Negotiate(Codec{Name: core.CompressionGzip, Level: 9}, common); got.Level != 9 {
+		t.Errorf("level not kept: %d", got.Level)
+	}
+	if got := Negotiate(Codec{Name: core.CompressionAuto, Level: 15}, []core.Compression{core.CompressionGzip}); got.Level != 0 {
+		t.Errorf("out of range level kept: %d", got.Level)
+	}
+}
+
+func TestCompressOutput_KeepsExitStatus(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip not installed")
+	}
+	c := Codec{Name: core.CompressionGzip}
+
+	out, err := exec.Command("sh", "-c", c.CompressOutput("echo hello")).Output()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	reader, err := c.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(reader); string(data) != "hello\n" {
+		t.Errorf("output = %q", data)
+	}
+
+	err = exec.Command("sh", "-c", c.CompressOutput("echo partial; exit 3")).Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("err = %v, want exit status 3", err)
+	}
+}
@@ -0,0 +1,91 @@
+package codec
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// ProbeCommand prints the name of each codec tool installed on a host, one
+// per line. It always succeeds.
+const ProbeCommand = "for c in zstd lz4 gzip; do command -v $c >/dev/null 2>&1 && echo $c; done; true"
+
+// ParseProbe returns the codecs listed in the output of ProbeCommand, in
+// preference order
+func ParseProbe(output string) []core.Compression {
+	found := make(map[core.Compression]bool)
+	for _, line := range strings.Split(output, "\n") {
+		found[core.Compression(strings.TrimSpace(line))] = true
+	}
+
+	var available []core.Compression
+	for _, name := range Preference {
+		if found[name] {
+			available = append(available, name)
+		}
+	}
+	return available
+}
+
+// Probe lists the codecs installed on a connected host
+func Probe(ctx context.Context, t transport.Transport, client *transport.SSHClient) ([]core.Compression, error) {
+	result, err := t.ExecuteCommand(ctx, client, ProbeCommand)
+	if err != nil {
+		return nil, fmt.Errorf("probe codecs: %w", err)
+	}
+	return ParseProbe(string(result.Stdout)), nil
+}
+
+// Intersect returns the codecs available on every host
+func Intersect(hosts ...[]core.Compression) []core.Compression {
+	var common []core.Compression
+	for _, name := range Preference {
+		everywhere := true
+		for _, available := range hosts {
+			if !slices.Contains(available, name) {
+				everywhere = false
+				break
+			}
+		}
+		if everywhere {
+			common = append(common, name)
+		}
+	}
+	return common
+}
+
+// Negotiate picks the codec to use given what every end of the transfer
+// supports. An explicit codec is kept when available; otherwise, and for
+// auto, the most preferred available codec is used, or none.
+func Negotiate(requested Codec, available []core.Compression) Codec {
+	if requested.Name == core.CompressionNone {
+		return requested
+	}
+	if requested.Enabled() && slices.Contains(available, requested.Name) {
+		return requested
+	}
+
+	for _, name := range Preference {
+		if !slices.Contains(available, name) {
+			continue
+		}
+		// A level only carries over from auto, and only when in range
+		if requested.Name == core.CompressionAuto {
+			if c, err := New(name, requested.Level); err == nil {
+				return c
+			}
+		}
+		return Codec{Name: name}
+	}
+	return Codec{Name: core.CompressionNone}
+}
+
+// Preferred returns the codec a transfer would use when every codec is
+// available, for describing engines without probing hosts
+func Preferred(requested Codec) Codec {
+	return Negotiate(requested, Preference)
+}
@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
 )
 
 // Config represents the complete configuration
@@ -29,12 +31,29 @@ type TransferConfig struct {
 type SourceConfig struct {
 	Path string                 `json:"path" yaml:"path"`
 	Auth map[string]interface{} `json:"auth,omitempty" yaml:"auth,omitempty"`
+	Jump []JumpConfig           `json:"jump,omitempty" yaml:"jump,omitempty"` // Bastions to go through, in order
 }
 
 // DestinationConfig defines the destination location
 type DestinationConfig struct {
 	Path string                 `json:"path" yaml:"path"`
 	Auth map[string]interface{} `json:"auth,omitempty" yaml:"auth,omitempty"`
+	Jump []JumpConfig           `json:"jump,omitempty" yaml:"jump,omitempty"` // Bastions to go through, in order
+}
+
+// JumpConfig defines a jump host on the way to an SSH endpoint
+type JumpConfig struct {
+	Host string                 `json:"host" yaml:"host"` // user@host or user@host:port
+	Auth map[string]interface{} `json:"auth,omitempty" yaml:"auth,omitempty"`
+}
+
+// JumpHosts converts jump host settings for the transfer options
+func JumpHosts(jumps []JumpConfig) []core.JumpHost {
+	var hosts []core.JumpHost
+	for _, jump := range jumps {
+		hosts = append(hosts, core.JumpHost{Host: jump.Host, Auth: jump.Auth})
+	}
+	return hosts
 }
 
 // TransferOptions contains transfer behavior settings
@@ -42,9 +61,15 @@ type TransferOptions struct {
 	Strategy    string              `json:"strategy" yaml:"strategy"`       // auto, rclone, rsync, tar
 	Parallel    int                 `json:"parallel" yaml:"parallel"`
 	Checkpoint  bool                `json:"checkpoint" yaml:"checkpoint"`
-	Compression string              `json:"compression" yaml:"compression"` // auto, none, zstd, gzip
+	Verify      bool                `json:"verify" yaml:"verify"` // Checksum the copy against the source
+	Compression string              `json:"compression" yaml:"compression"` // auto, none, zstd, lz4, gzip
+	CompressionLevel int            `json:"compression_level,omitempty" yaml:"compression_level,omitempty"` // 0 = codec default; gzip/lz4 1-9, zstd 1-19
 	DryRun      bool                `json:"dry_run" yaml:"dry_run"`
+	ScanDestination bool            `json:"scan_destination,omitempty" yaml:"scan_destination,omitempty"` // Compare with destination contents
+	Preserve    []string            `json:"preserve,omitempty" yaml:"preserve,omitempty"` // hardlinks, sparse, xattrs, acls, all or none
+	HostKeyPolicy string            `json:"host_key_policy,omitempty" yaml:"host_key_policy,omitempty"` // tofu (default), strict or insecure
 	Thresholds  *ThresholdSettings  `json:"thresholds,omitempty" yaml:"thresholds,omitempty"`
+	Rules       []RuleConfig        `json:"rules,omitempty" yaml:"rules,omitempty"`           // Evaluated in order before the built-in heuristics
 	Batching    *BatchingSettings   `json:"batching,omitempty" yaml:"batching,omitempty"`
 	Buffering   *BufferingSettings  `json:"buffering,omitempty" yaml:"buffering,omitempty"`
 	Workers     *WorkersSettings    `json:"workers,omitempty" yaml:"workers,omitempty"`
@@ -61,6 +86,59 @@ type ThresholdSettings struct {
 	MaxSampleSize    int     `json:"max_sample_size" yaml:"max_sample_size"`         // Maximum files to sample (default: 10000)
 }
 
+// RuleConfig selects a strategy when all conditions in Match hold
+type RuleConfig struct {
+	Name     string    `json:"name,omitempty" yaml:"name,omitempty"`
+	Match    RuleMatch `json:"match" yaml:"match"`
+	Strategy string    `json:"strategy" yaml:"strategy"`                   // rclone, rsync, tar, proxy
+	Staging  string    `json:"staging,omitempty" yaml:"staging,omitempty"` // Transfer through this location first, data is left there
+
+	// Credentials and bastions for the staging location; those of the source
+	// and destination are never sent to it
+	StagingAuth map[string]interface{} `json:"staging_auth,omitempty" yaml:"staging_auth,omitempty"`
+	StagingJump []JumpConfig           `json:"staging_jump,omitempty" yaml:"staging_jump,omitempty"`
+}
+
+// RuleMatch lists rule conditions, unset conditions are not checked
+type RuleMatch struct {
+	SourceProtocols     []string `json:"source_protocols,omitempty" yaml:"source_protocols,omitempty"`           // local, ssh, s3, gcs, azure, http, ftp
+	DestProtocols       []string `json:"dest_protocols,omitempty" yaml:"dest_protocols,omitempty"`
+	SourceHosts         []string `json:"source_hosts,omitempty" yaml:"source_hosts,omitempty"`                   // Glob patterns, "local" for local paths
+	DestHosts           []string `json:"dest_hosts,omitempty" yaml:"dest_hosts,omitempty"`
+	MinFiles            int64    `json:"min_files,omitempty" yaml:"min_files,omitempty"`
+	MaxFiles            int64    `json:"max_files,omitempty" yaml:"max_files,omitempty"`
+	MinTotalSizeMB      int64    `json:"min_total_size_mb,omitempty" yaml:"min_total_size_mb,omitempty"`
+	MaxTotalSizeMB      int64    `json:"max_total_size_mb,omitempty" yaml:"max_total_size_mb,omitempty"`
+	MinSmallFilePercent float64  `json:"min_small_file_percent,omitempty" yaml:"min_small_file_percent,omitempty"`
+	MaxSmallFilePercent float64  `json:"max_small_file_percent,omitempty" yaml:"max_small_file_percent,omitempty"`
+	MinLargeFilePercent float64  `json:"min_large_file_percent,omitempty" yaml:"min_large_file_percent,omitempty"`
+	MaxLargeFilePercent float64  `json:"max_large_file_percent,omitempty" yaml:"max_large_file_percent,omitempty"`
+	Extensions          []string `json:"extensions,omitempty" yaml:"extensions,omitempty"`                       // e.g. [".log", ".csv"]
+	MinExtensionPercent float64  `json:"min_extension_percent,omitempty" yaml:"min_extension_percent,omitempty"` // Share of files with a listed extension
+}
+
+// Validate checks a rule for unknown strategies and protocols
+func (r RuleConfig) Validate() error {
+	switch r.Strategy {
+	case "rclone", "rsync", "tar", "proxy":
+	case "":
+		return fmt.Errorf("rule %q: strategy is required", r.Name)
+	default:
+		return fmt.Errorf("rule %q: unknown strategy: %s", r.Name, r.Strategy)
+	}
+
+	protocols := append(append([]string{}, r.Match.SourceProtocols...), r.Match.DestProtocols...)
+	for _, p := range protocols {
+		switch p {
+		case "local", "ssh", "s3", "gcs", "azure", "http", "ftp", "webdav":
+		default:
+			return fmt.Errorf("rule %q: unknown protocol: %s", r.Name, p)
+		}
+	}
+
+	return nil
+}
+
 // BatchingSettings defines batching configuration for tar transfers
 type BatchingSettings struct {
 	Enabled     bool `json:"enabled" yaml:"enabled"`           // Enable batching (default: true for tar)
@@ -160,8 +238,11 @@ func FromEnv() *Config {
 				Strategy:    getEnvOrDefault("DIFPIPE_STRATEGY", "auto"),
 				Parallel:    getEnvInt("DIFPIPE_PARALLEL", 4),
 				Checkpoint:  getEnvBool("DIFPIPE_CHECKPOINT", true),
+				Verify:      getEnvBool("DIFPIPE_VERIFY", true),
 				Compression: getEnvOrDefault("DIFPIPE_COMPRESSION", "auto"),
 				DryRun:      getEnvBool("DIFPIPE_DRY_RUN", false),
+				ScanDestination: getEnvBool("DIFPIPE_SCAN_DESTINATION", false),
+				HostKeyPolicy: os.Getenv("DIFPIPE_HOST_KEY_POLICY"),
 			},
 		},
 		Output: OutputConfig{
@@ -190,6 +271,9 @@ func Merge(configs ...*Config) *Config {
 		if cfg.Transfer.Source.Auth != nil && len(cfg.Transfer.Source.Auth) > 0 {
 			result.Transfer.Source.Auth = cfg.Transfer.Source.Auth
 		}
+		if len(cfg.Transfer.Source.Jump) > 0 {
+			result.Transfer.Source.Jump = cfg.Transfer.Source.Jump
+		}
 
 		// Merge destination
 		if cfg.Transfer.Destination.Path != "" {
@@ -198,6 +282,9 @@ func Merge(configs ...*Config) *Config {
 		if cfg.Transfer.Destination.Auth != nil && len(cfg.Transfer.Destination.Auth) > 0 {
 			result.Transfer.Destination.Auth = cfg.Transfer.Destination.Auth
 		}
+		if len(cfg.Transfer.Destination.Jump) > 0 {
+			result.Transfer.Destination.Jump = cfg.Transfer.Destination.Jump
+		}
 
 		// Merge options
 		if cfg.Transfer.Options.Strategy != "" && cfg.Transfer.Options.Strategy != "auto" {
@@ -209,11 +296,25 @@ func Merge(configs ...*Config) *Config {
 		if cfg.Transfer.Options.Compression != "" {
 			result.Transfer.Options.Compression = cfg.Transfer.Options.Compression
 		}
+		if cfg.Transfer.Options.CompressionLevel != 0 {
+			result.Transfer.Options.CompressionLevel = cfg.Transfer.Options.CompressionLevel
+		}
+		if len(cfg.Transfer.Options.Preserve) > 0 {
+			result.Transfer.Options.Preserve = cfg.Transfer.Options.Preserve
+		}
+		if cfg.Transfer.Options.HostKeyPolicy != "" {
+			result.Transfer.Options.HostKeyPolicy = cfg.Transfer.Options.HostKeyPolicy
+		}
 		result.Transfer.Options.Checkpoint = cfg.Transfer.Options.Checkpoint
+		result.Transfer.Options.Verify = cfg.Transfer.Options.Verify
 		result.Transfer.Options.DryRun = cfg.Transfer.Options.DryRun
+		result.Transfer.Options.ScanDestination = cfg.Transfer.Options.ScanDestination
 		if cfg.Transfer.Options.Thresholds != nil {
 			result.Transfer.Options.Thresholds = cfg.Transfer.Options.Thresholds
 		}
+		if len(cfg.Transfer.Options.Rules) > 0 {
+			result.Transfer.Options.Rules = cfg.Transfer.Options.Rules
+		}
 
 		// Merge filters
 		if len(cfg.Transfer.Filters.Include) > 0 {
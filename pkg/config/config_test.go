@@ -144,6 +144,19 @@ func TestMerge(t *testing.T) {
 	}
 }
 
+func TestMerge_ScanDestination(t *testing.T) {
+	on := &Config{Transfer: TransferConfig{Options: TransferOptions{ScanDestination: true}}}
+	off := &Config{}
+
+	// The config with higher priority can turn it off again
+	if Merge(off, on).Transfer.Options.ScanDestination {
+		t.Error("Expected scan destination off from cfg1")
+	}
+	if !Merge(on, off).Transfer.Options.ScanDestination {
+		t.Error("Expected scan destination on from cfg1")
+	}
+}
+
 func TestLoadConfig_Stdin(t *testing.T) {
 	jsonConfig := `{"transfer":{"source":{"path":"/src"},"destination":{"path":"/dst"}}}`
 
@@ -257,3 +270,91 @@ func TestMerge_WithAuth(t *testing.T) {
 		t.Errorf("Expected dest password 'dest-secret', got %v", merged.Transfer.Destination.Auth["password"])
 	}
 }
+
+func TestLoadConfig_Rules(t *testing.T) {
+	yamlConfig := `
+transfer:
+  source:
+    path: /source
+  destination:
+    path: s3://bucket/dest
+  options:
+    rules:
+      - name: small-to-s3
+        match:
+          dest_protocols: [s3]
+          min_small_file_percent: 90
+        strategy: tar
+        staging: root@staging:/tmp/stage
+      - match:
+          dest_hosts: ["archive*"]
+        strategy: rsync
+`
+
+	cfg, err := ParseAuto([]byte(yamlConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse YAML config: %v", err)
+	}
+
+	rules := cfg.Transfer.Options.Rules
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+	if rules[0].Match.MinSmallFilePercent != 90 || rules[0].Staging != "root@staging:/tmp/stage" {
+		t.Errorf("Unexpected first rule: %+v", rules[0])
+	}
+	if rules[1].Strategy != "rsync" || rules[1].Match.DestHosts[0] != "archive*" {
+		t.Errorf("Unexpected second rule: %+v", rules[1])
+	}
+
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			t.Errorf("Expected valid rule, got %v", err)
+		}
+	}
+}
+
+func TestRuleConfig_Validate(t *testing.T) {
+	if err := (RuleConfig{Name: "bad", Strategy: "ftp"}).Validate(); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+	if err := (RuleConfig{Strategy: "rsync", Match: RuleMatch{DestProtocols: []string{"nfs"}}}).Validate(); err == nil {
+		t.Error("Expected error for unknown protocol")
+	}
+}
+
+func TestLoadConfig_WithJumpHosts(t *testing.T) {
+	yamlConfig := `
+transfer:
+  source:
+    path: "root@db1:/data"
+    jump:
+      - host: "ops@bastion.example.com:2222"
+        auth:
+          key: "~/.ssh/bastion"
+      - host: "root@inner"
+  destination:
+    path: "/backup"
+`
+
+	cfg, err := ParseAuto([]byte(yamlConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse YAML config with jump hosts: %v", err)
+	}
+
+	jumps := JumpHosts(cfg.Transfer.Source.Jump)
+	if len(jumps) != 2 {
+		t.Fatalf("Expected 2 jump hosts, got %d", len(jumps))
+	}
+	if jumps[0].Host != "ops@bastion.example.com:2222" || jumps[0].Auth["key"] != "~/.ssh/bastion" {
+		t.Errorf("Unexpected first jump host: %+v", jumps[0])
+	}
+	if jumps[1].Host != "root@inner" || jumps[1].Auth != nil {
+		t.Errorf("Unexpected second jump host: %+v", jumps[1])
+	}
+
+	merged := Merge(cfg, FromEnv())
+	if len(merged.Transfer.Source.Jump) != 2 {
+		t.Error("Jump hosts were lost during merge")
+	}
+}
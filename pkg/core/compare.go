@@ -0,0 +1,105 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// EngineCapabilities describes what an engine needs and offers for a transfer
+type EngineCapabilities struct {
+	Resume           bool        // An interrupted transfer continues where it stopped
+	RequiredBinaries []string    // Commands run locally, or "name (remote)" on an endpoint
+	Compression      Compression // Compression applied on the wire
+}
+
+// StrategyComparison is the estimate of one strategy for a transfer
+type StrategyComparison struct {
+	Strategy         Strategy
+	EstimatedTime    time.Duration
+	EstimatedSpeed   string
+	BytesTotal       int64
+	WireBytes        int64 // Bytes sent after compression
+	Compression      Compression
+	Resume           bool
+	RequiredBinaries []string
+	MissingBinaries  []string // Local binaries not found in PATH
+	Recommended      bool
+	Error            string // Set when the engine could not estimate
+}
+
+// Comparison lists every strategy able to perform a transfer, fastest first
+type Comparison struct {
+	Strategies []StrategyComparison
+}
+
+// String renders the comparison as a table
+func (c *Comparison) String() string {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STRATEGY\tEST. TIME\tSPEED\tON WIRE\tCOMPRESSION\tRESUME\tREQUIRES")
+	for _, s := range c.Strategies {
+		name := string(s.Strategy)
+		if s.Recommended {
+			name += " *"
+		}
+
+		requires := strings.Join(s.RequiredBinaries, ", ")
+		if requires == "" {
+			requires = "-"
+		}
+		if len(s.MissingBinaries) > 0 {
+			requires += fmt.Sprintf(" (missing: %s)", strings.Join(s.MissingBinaries, ", "))
+		}
+
+		if s.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t%s\t%s\t%s\n", name, s.Compression, yesNo(s.Resume), requires)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, roundDuration(s.EstimatedTime),
+			s.EstimatedSpeed, formatSize(s.WireBytes), s.Compression, yesNo(s.Resume), requires)
+	}
+	w.Flush()
+
+	for _, s := range c.Strategies {
+		if s.Error != "" {
+			fmt.Fprintf(&b, "%s: %s\n", s.Strategy, s.Error)
+		}
+	}
+	b.WriteString("* recommended\n")
+
+	return b.String()
+}
+
+// roundDuration rounds an estimate for display
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// yesNo renders a flag for tables
+func yesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+// formatSize formats a byte count as a human-readable string
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
+}
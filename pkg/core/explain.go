@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explain stages, in the order they are evaluated
+const (
+	ExplainProtocol  = "protocol"  // Decided by source/destination protocols alone
+	ExplainRule      = "rule"      // Config rules
+	ExplainHeuristic = "heuristic" // Built-in thresholds
+	ExplainHistory   = "history"   // Past transfer performance
+)
+
+// Explanation records how a strategy was selected
+type Explanation struct {
+	Selected  Strategy
+	DecidedBy string // Stage that made the final choice
+	Steps     []ExplainStep
+	Rejected  []RejectedStrategy
+}
+
+// ExplainStep is one rule condition or threshold evaluated during selection
+type ExplainStep struct {
+	Stage     string
+	Check     string
+	Measured  string
+	Threshold string
+	Passed    bool
+	Selects   Strategy // Strategy chosen when this check passes
+}
+
+// RejectedStrategy explains why an alternative strategy was not selected
+type RejectedStrategy struct {
+	Strategy Strategy
+	Reason   string
+}
+
+// Summary describes the step on one line
+func (s ExplainStep) Summary() string {
+	parts := []string{s.Check}
+	if s.Measured != "" {
+		parts = append(parts, s.Measured)
+	}
+	if s.Threshold != "" {
+		parts = append(parts, s.Threshold)
+	}
+	return strings.Join(parts, " ")
+}
+
+// String renders the explanation as text
+func (e *Explanation) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Selected: %s (decided by %s)\n", e.Selected, e.DecidedBy)
+
+	b.WriteString("Evaluated:\n")
+	for _, step := range e.Steps {
+		result := "fail"
+		if step.Passed {
+			result = "pass"
+		}
+		fmt.Fprintf(&b, "  [%s] %-9s %s", result, step.Stage, step.Check)
+		if step.Measured != "" {
+			fmt.Fprintf(&b, ": measured %s", step.Measured)
+		}
+		if step.Threshold != "" {
+			fmt.Fprintf(&b, ", threshold %s", step.Threshold)
+		}
+		if step.Selects != "" {
+			fmt.Fprintf(&b, " → %s", step.Selects)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(e.Rejected) > 0 {
+		b.WriteString("Rejected:\n")
+		for _, r := range e.Rejected {
+			fmt.Fprintf(&b, "  %-7s %s\n", r.Strategy, r.Reason)
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -20,6 +20,16 @@ type TransferEngine interface {
 	Estimate(ctx context.Context, opts *TransferOptions) (*TransferEstimate, error)
 }
 
+// EngineDescriber is implemented by engines that can describe how they would
+// run a transfer, so strategies can be compared
+type EngineDescriber interface {
+	// SupportsTransfer checks if this engine can transfer between two protocols
+	SupportsTransfer(source, dest Protocol) bool
+
+	// Capabilities describes the engine for the given options
+	Capabilities(opts *TransferOptions) EngineCapabilities
+}
+
 // ProgressReporter receives progress updates during transfer
 type ProgressReporter interface {
 	// Start signals the beginning of a transfer
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PreserveOptions selects the file metadata tar transfers carry beyond
+// modes, modification times and symlinks
+type PreserveOptions struct {
+	Hardlinks bool // Files sharing an inode stay linked instead of being copied twice
+	Sparse    bool // Holes in sparse files are recreated instead of written as zeros
+	Xattrs    bool // Extended attributes other than ACLs
+	ACLs      bool // POSIX access and default ACLs
+}
+
+// PreserveNames are the metadata names accepted by ParsePreserve
+var PreserveNames = []string{"hardlinks", "sparse", "xattrs", "acls"}
+
+// DefaultPreserve is used when no preserve option is given
+func DefaultPreserve() PreserveOptions {
+	return PreserveOptions{Hardlinks: true}
+}
+
+// PreserveOrDefault returns p, or the default set when p is nil
+func PreserveOrDefault(p *PreserveOptions) PreserveOptions {
+	if p == nil {
+		return DefaultPreserve()
+	}
+	return *p
+}
+
+// ParsePreserve parses metadata names, each entry may hold several
+// separated by commas. "all" and "none" select everything or nothing, no
+// names at all select the default set.
+func ParsePreserve(names []string) (PreserveOptions, error) {
+	var p PreserveOptions
+	seen := false
+	for _, entry := range names {
+		for _, name := range strings.Split(entry, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			seen = true
+
+			switch name {
+			case "hardlinks":
+				p.Hardlinks = true
+			case "sparse":
+				p.Sparse = true
+			case "xattrs":
+				p.Xattrs = true
+			case "acls":
+				p.ACLs = true
+			case "all":
+				p = PreserveOptions{Hardlinks: true, Sparse: true, Xattrs: true, ACLs: true}
+			case "none":
+			default:
+				return PreserveOptions{}, fmt.Errorf("unknown preserve option %q, want %s, all or none",
+					name, strings.Join(PreserveNames, ", "))
+			}
+		}
+	}
+	if !seen {
+		return DefaultPreserve(), nil
+	}
+	return p, nil
+}
+
+// String lists the selected metadata, e.g. "hardlinks,xattrs"
+func (p PreserveOptions) String() string {
+	var names []string
+	for i, on := range []bool{p.Hardlinks, p.Sparse, p.Xattrs, p.ACLs} {
+		if on {
+			names = append(names, PreserveNames[i])
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// KeepsXattr reports whether an extended attribute is carried. ACLs are
+// stored in the system.posix_acl_* attributes.
+func (p PreserveOptions) KeepsXattr(name string) bool {
+	if strings.HasPrefix(name, "system.posix_acl_") {
+		return p.ACLs
+	}
+	return p.Xattrs
+}
+
+// TarFlags returns GNU tar options carrying the selected metadata. They
+// are accepted both when creating and extracting archives.
+func (p PreserveOptions) TarFlags() []string {
+	var flags []string
+	if !p.Hardlinks {
+		flags = append(flags, "--hard-dereference")
+	}
+	if p.Sparse {
+		flags = append(flags, "--sparse")
+	}
+	switch {
+	case p.Xattrs && p.ACLs:
+		flags = append(flags, "--xattrs", "--xattrs-include=*")
+	case p.Xattrs:
+		flags = append(flags, "--xattrs", "--xattrs-include=*", "--xattrs-exclude=system.posix_acl_*")
+	case p.ACLs:
+		flags = append(flags, "--xattrs", "--xattrs-include=system.posix_acl_*")
+	}
+	return flags
+}
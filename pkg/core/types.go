@@ -1,6 +1,8 @@
 package core
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -48,12 +50,27 @@ type TransferOptions struct {
 	Destination string
 	Strategy    Strategy
 	Compression Compression
+	CompressionLevel int // 0 uses the codec's default
 	Parallel    int
 	Checkpoint  bool
+	Verify      bool // Compare a checksum of the copy with the source
 	DryRun      bool
 	Filters     *FilterOptions
 	Auth        *AuthOptions
 	Thresholds  *ThresholdSettings
+	Rules       []StrategyRule
+
+	// ScanDestination enumerates the destination during analysis so only
+	// new and changed files count towards strategy selection and estimates
+	ScanDestination bool
+
+	// ArchiveTo writes a single tar archive to this local file instead of
+	// copying into the destination
+	ArchiveTo string
+
+	// Preserve selects the metadata tar transfers carry, nil uses
+	// DefaultPreserve
+	Preserve *PreserveOptions
 }
 
 // ThresholdSettings defines thresholds for strategy selection
@@ -67,6 +84,37 @@ type ThresholdSettings struct {
 	MaxSampleSize    int
 }
 
+// StrategyRule selects a strategy when all of its conditions match.
+// Zero-valued conditions are not checked.
+type StrategyRule struct {
+	Name     string
+	Strategy Strategy
+	Staging  string // Transfer to this location first, then on to the destination
+
+	// Credentials and jump hosts for the staging location, which never gets
+	// those of the source or destination
+	StagingAuth  map[string]interface{}
+	StagingJumps []JumpHost
+
+	SourceProtocols []Protocol
+	DestProtocols   []Protocol
+	SourceHosts     []string // Glob patterns
+	DestHosts       []string // Glob patterns
+
+	MinFiles     int64
+	MaxFiles     int64
+	MinTotalSize int64 // Bytes
+	MaxTotalSize int64 // Bytes
+
+	MinSmallFilePercent float64
+	MaxSmallFilePercent float64
+	MinLargeFilePercent float64
+	MaxLargeFilePercent float64
+
+	Extensions          []string // e.g. ".log", matched case-insensitively
+	MinExtensionPercent float64  // Share of files with a listed extension (default: any)
+}
+
 // FilterOptions defines include/exclude patterns
 type FilterOptions struct {
 	Include []string
@@ -77,6 +125,39 @@ type FilterOptions struct {
 type AuthOptions struct {
 	SourceAuth map[string]interface{}
 	DestAuth   map[string]interface{}
+
+	// Jump hosts SSH connections to each endpoint go through, in order
+	SourceJumps []JumpHost
+	DestJumps   []JumpHost
+
+	// Set when the endpoint is a rule's staging location, so the endpoint
+	// passwords in the environment are not sent to it
+	SourceStaging bool
+	DestStaging   bool
+}
+
+// SourcePasswordEnv returns the environment variable holding the source
+// password, none for a staging location
+func (a *AuthOptions) SourcePasswordEnv() string {
+	if a != nil && a.SourceStaging {
+		return ""
+	}
+	return "DIFPIPE_SOURCE_PASSWORD"
+}
+
+// DestPasswordEnv returns the environment variable holding the destination
+// password, none for a staging location
+func (a *AuthOptions) DestPasswordEnv() string {
+	if a != nil && a.DestStaging {
+		return ""
+	}
+	return "DIFPIPE_DEST_PASSWORD"
+}
+
+// JumpHost is a bastion on the way to an endpoint
+type JumpHost struct {
+	Host string                 // user@host or user@host:port
+	Auth map[string]interface{} // Like an endpoint's, the agent or default keys when empty
 }
 
 // TransferResult contains the outcome of a transfer
@@ -91,6 +172,9 @@ type TransferResult struct {
 	AverageSpeed string // e.g., "32 MB/s"
 	Message      string
 	Error        error
+	ChangedFiles []string // Files still changing after every retry, their copies may be inconsistent
+	Compression  Compression // Codec used on the wire after negotiating with the hosts
+	Checksum     string      // SHA-256 of the transferred file, hex, when it was verified
 }
 
 // TransferEstimate provides transfer estimates
@@ -112,12 +196,99 @@ type FileAnalysis struct {
 	MediumFiles     int64  // 10 KB - 100 MB
 	LargeFiles      int64  // > 100 MB
 	FileTypes       map[string]int64
+	ContentTypes    map[string]int64 // Sniffed files by header bytes, e.g. "gzip", "png", "text"
+	ContentFamilies map[string]int64 // Sniffed files by family, e.g. "archive", "image", "text"
 	Compressibility float64 // 0.0 = incompressible, 1.0 = highly compressible
+	Compression     Compression // Recommended compression for the sniffed content
 	SourceProtocol  Protocol
 	DestProtocol    Protocol
 	SampleTime      time.Duration
 	Recommendation  Strategy
 	RecommendReason string
+	Delta           *DeltaAnalysis // Set when the destination was scanned
+	MatchedRule     string         // Name of the config rule that chose the strategy
+	Staging         string         // Intermediate location required by the matched rule
+	StagingRule     *StrategyRule  `json:"-" yaml:"-"` // The matched rule when it stages, kept out of output for its credentials
+	Explanation     *Explanation   // Set when explain mode is enabled
+	Cache           *CacheInfo     // Set when cached results were used or refreshed
+	Duplicates      *DuplicateAnalysis // Set when duplicate detection is enabled
+	Comparison      *Comparison        // Set when strategies were compared
+	Active          *ActiveFiles       // Set when sampled files were modified within the settle window
+}
+
+// ActiveFiles reports files modified so recently they may still be written
+type ActiveFiles struct {
+	SettleWindow time.Duration
+	Files        int64
+	Bytes        int64
+	Paths        []string // Most recently modified first, capped
+}
+
+// String summarizes the active files
+func (a *ActiveFiles) String() string {
+	return fmt.Sprintf("%d files (%s) modified within %s, e.g. %s",
+		a.Files, formatSize(a.Bytes), a.SettleWindow, strings.Join(a.Paths, ", "))
+}
+
+// DuplicateAnalysis reports files with identical content among the sampled
+// files. When the source was sampled, duplicates are a lower bound.
+type DuplicateAnalysis struct {
+	FilesChecked   int64
+	BytesHashed    int64
+	GroupCount     int64
+	DuplicateFiles int64   // Copies beyond the first in each group
+	DuplicateBytes int64   // Bytes in those copies
+	DedupRatio     float64 // Checked bytes / unique bytes
+	Groups         []DuplicateGroup // Largest waste first, capped
+	ScanTime       time.Duration
+}
+
+// DuplicateGroup is a set of files with identical content
+type DuplicateGroup struct {
+	Size  int64
+	Hash  string // SHA-256
+	Files []string
+}
+
+// CacheInfo describes how much of an analysis came from the cache
+type CacheInfo struct {
+	Age         time.Duration // Age of the oldest reused result
+	ReusedDirs  int64
+	ScannedDirs int64
+}
+
+// String summarizes cache use
+func (c *CacheInfo) String() string {
+	if c.ReusedDirs == 0 {
+		return fmt.Sprintf("scanned %d directories, nothing reused", c.ScannedDirs)
+	}
+	return fmt.Sprintf("reused %d directories (age %s), rescanned %d",
+		c.ReusedDirs, c.Age.Round(time.Second), c.ScannedDirs)
+}
+
+// DeltaAnalysis compares the source against what is already at the destination
+type DeltaAnalysis struct {
+	NewFiles       int64 // Only at source
+	NewBytes       int64
+	ChangedFiles   int64 // At both, but size or modification time differs
+	ChangedBytes   int64
+	UnchangedFiles int64 // At both with matching size and modification time
+	UnchangedBytes int64
+	ExtraFiles     int64 // Only at destination
+	ExtraBytes     int64
+	SmallFiles     int64 // New and changed files below the analyzer's small file size
+	LargeFiles     int64 // New and changed files above its large file size
+	ScanTime       time.Duration
+}
+
+// TransferFiles returns the number of files that need to be sent
+func (d *DeltaAnalysis) TransferFiles() int64 {
+	return d.NewFiles + d.ChangedFiles
+}
+
+// TransferBytes returns the number of bytes that need to be sent
+func (d *DeltaAnalysis) TransferBytes() int64 {
+	return d.NewBytes + d.ChangedBytes
 }
 
 // CheckpointState stores state for resuming transfers
@@ -135,6 +306,13 @@ type CheckpointState struct {
 	CurrentFile     string
 	CompletedFiles  []string
 	FailedFiles     map[string]string // file -> error
+	CompletedRanges []ByteRange       // Parts of a file copied by a range-split transfer
+}
+
+// ByteRange is a span of bytes in a file
+type ByteRange struct {
+	Offset int64
+	Length int64
 }
 
 // TransferStatus represents the current status of a transfer
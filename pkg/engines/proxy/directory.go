@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/engines/tarstream"
+	"github.com/larrydiffey/difpipe/pkg/stream"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// isRemoteDir reports whether path is a directory on the host
+func (e *Engine) isRemoteDir(ctx context.Context, client *transport.SSHClient, path string) (bool, error) {
+	result, err := e.transport.ExecuteCommand(ctx, client, "test -d "+transport.QuoteRemotePath(path))
+	if err != nil {
+		return false, fmt.Errorf("execute test command: %w", err)
+	}
+	return result.ExitCode == 0, nil
+}
+
+// transferDirectory streams a directory between the hosts: tar on the
+// source archives it, the proxy relays the archive and tar on the
+// destination extracts it. Filters are applied on the source.
+func (e *Engine) transferDirectory(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult, sourceClient, destClient *transport.SSHClient, sourceLoc, destLoc *transport.RemoteLocation) error {
+	files, bytes, err := e.scanDirectory(ctx, sourceClient, sourceLoc.Path, opts.Filters)
+	if err != nil {
+		return fmt.Errorf("scan source: %w", err)
+	}
+	result.FilesTotal = files
+	result.BytesTotal = bytes
+
+	if e.progress != nil {
+		e.progress.Start(bytes, fmt.Sprintf("Transferring %d files (%d bytes)", files, bytes))
+	}
+
+	c, err := e.negotiateCodec(ctx, opts, sourceClient, destClient)
+	if err != nil {
+		return err
+	}
+	result.Compression = c.Name
+
+	preserve := core.PreserveOrDefault(opts.Preserve)
+	sourceCmd := c.CompressOutput(sourceTarCommand(sourceLoc.Path, opts.Filters, preserve))
+	// The streams are only closed once the archive is through. Closing the
+	// connections ends both commands when the transfer fails midway.
+	sourceStream, err := e.transport.StreamCommand(ctx, sourceClient, sourceCmd)
+	if err != nil {
+		return fmt.Errorf("start source stream: %w", err)
+	}
+
+	destStream, err := e.transport.StreamWrite(ctx, destClient, destTarCommand(destLoc.Path, c, preserve))
+	if err != nil {
+		return fmt.Errorf("start destination stream: %w", err)
+	}
+
+	// Follow the archive as it passes to count the files in it
+	counter := &archiveCounter{}
+	tapReader, tapWriter := io.Pipe()
+	counted := make(chan struct{})
+	go func() {
+		counter.follow(tapReader, c)
+		close(counted)
+	}()
+
+	pipelineConfig := &stream.Config{
+		BufferSize: 1024 * 1024, // 1MB buffer
+		ProgressFunc: func(bytesTransferred int64, speed float64) {
+			if e.progress != nil {
+				e.progress.Update(counter.bytes.Load(), fmt.Sprintf("%d/%d files, %.1f MB/s",
+					counter.files.Load(), files, speed/(1024*1024)))
+			}
+		},
+	}
+	pipeline := stream.New(io.TeeReader(sourceStream, tapWriter), destStream, pipelineConfig)
+	pipelineErr := pipeline.Start(ctx)
+	tapWriter.Close()
+	<-counted
+
+	result.FilesDone = counter.files.Load()
+	result.BytesDone = counter.bytes.Load()
+	if pipelineErr != nil {
+		// Writes mostly fail because tar on the destination stopped, its
+		// exit explains why. The source may still be writing, so it is left
+		// to the connection to end.
+		if err := remoteTarError(destStream.Close(), destLoc.Host); err != nil {
+			return err
+		}
+		return fmt.Errorf("pipeline error: %w", pipelineErr)
+	}
+
+	// A failing tar explains the transfer better than the stream it broke
+	if err := remoteTarError(sourceStream.Close(), sourceLoc.Host); err != nil {
+		return err
+	}
+	return remoteTarError(destStream.Close(), destLoc.Host)
+}
+
+// scanDirectory counts the files below dir that pass the filters and the
+// bytes in the regular ones
+func (e *Engine) scanDirectory(ctx context.Context, client *transport.SSHClient, dir string, filters *core.FilterOptions) (int64, int64, error) {
+	result, err := e.transport.ExecuteCommand(ctx, client, scanCommand(dir, filters))
+	if err != nil {
+		return 0, 0, fmt.Errorf("execute find command: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return 0, 0, fmt.Errorf("find failed: %s", strings.TrimSpace(string(result.Stderr)))
+	}
+
+	var files, bytes int64
+	if _, err := fmt.Sscanf(string(result.Stdout), "%d %d", &files, &bytes); err != nil {
+		return 0, 0, fmt.Errorf("parse find output: %w", err)
+	}
+	return files, bytes, nil
+}
+
+// scanCommand builds the command printing the number of files below dir
+// that pass the filters and the bytes in the regular ones. Where find
+// lacks GNU's -printf, as on BSD and busybox, ls -ln supplies the type and
+// size instead.
+func scanCommand(dir string, filters *core.FilterOptions) string {
+	find := "find . -mindepth 1 " + pruneExpression(filters) + "! -type d"
+	return fmt.Sprintf("cd %s && if find . -prune -printf '' 2>/dev/null; then %s -printf '%%y %%s\\n'; "+
+		"else %s -exec ls -ldn {} + | awk '{print substr($1, 1, 1) == \"-\" ? \"f\" : \"o\", $5}'; fi | "+
+		"awk '{n++} $1 == \"f\" {s+=$2} END {print n+0, s+0}'",
+		transport.QuoteRemotePath(dir), find, find)
+}
+
+// sourceTarCommand builds the tar command archiving dir to stdout. With
+// filters, find lists the entries so includes and excludes match base
+// names and a rejected directory is skipped whole, as in the tar engine.
+func sourceTarCommand(dir string, filters *core.FilterOptions, preserve core.PreserveOptions) string {
+	flags := tarFlags(preserve)
+	prune := pruneExpression(filters)
+	if prune == "" {
+		return fmt.Sprintf("tar -cf - -C %s%s .", transport.QuoteRemotePath(dir), flags)
+	}
+	return fmt.Sprintf("cd %s && find . -mindepth 1 %s-print0 | tar -cf - --null --no-recursion%s -T -",
+		transport.QuoteRemotePath(dir), prune, flags)
+}
+
+// destTarCommand builds the command extracting an archive read from stdin
+// into dir, creating it first
+func destTarCommand(dir string, c codec.Codec, preserve core.PreserveOptions) string {
+	extract := fmt.Sprintf("tar -xf - -C %s%s", transport.QuoteRemotePath(dir), tarFlags(preserve))
+	if c.Enabled() {
+		extract = c.DecompressCommand() + " | " + extract
+	}
+	return fmt.Sprintf("mkdir -p %s && %s", transport.QuoteRemotePath(dir), extract)
+}
+
+// tarFlags returns the preserve options as tar arguments, each preceded
+// by a space
+func tarFlags(preserve core.PreserveOptions) string {
+	var flags strings.Builder
+	for _, flag := range preserve.TarFlags() {
+		flags.WriteString(" " + transport.ShellQuote(flag))
+	}
+	return flags.String()
+}
+
+// pruneExpression returns find primaries pruning the entries the filters
+// reject, followed by -o and a space, or nothing without filters.
+// Excludes with a slash never match a base name and are left out.
+func pruneExpression(filters *core.FilterOptions) string {
+	if filters == nil {
+		return ""
+	}
+
+	var rejects []string
+	if len(filters.Include) > 0 {
+		var names []string
+		for _, pattern := range filters.Include {
+			names = append(names, "-name "+transport.ShellQuote(pattern))
+		}
+		rejects = append(rejects, `! \( `+strings.Join(names, " -o ")+` \)`)
+	}
+	for _, pattern := range filters.Exclude {
+		if !strings.Contains(pattern, "/") {
+			rejects = append(rejects, "-name "+transport.ShellQuote(pattern))
+		}
+	}
+	if len(rejects) == 0 {
+		return ""
+	}
+	return `\( ` + strings.Join(rejects, " -o ") + ` \) -prune -o `
+}
+
+// remoteTarError turns a failed remote command into a RemoteTarError
+func remoteTarError(err error, host string) error {
+	var cmdErr *transport.CommandError
+	if errors.As(err, &cmdErr) {
+		return &tarstream.RemoteTarError{Host: host, Status: cmdErr.Status, Stderr: cmdErr.Stderr}
+	}
+	return err
+}
+
+// archiveCounter counts the files and bytes of an archive relayed by the
+// proxy. Directories aren't counted.
+type archiveCounter struct {
+	files atomic.Int64
+	bytes atomic.Int64
+}
+
+// follow reads the archive from r to its end. A file counts once its
+// contents have been read. The proxy only relays the archive, so a stream
+// the counter can't parse leaves it short rather than failing the transfer.
+func (ac *archiveCounter) follow(r io.Reader, c codec.Codec) {
+	// Keep reading so the tee never blocks the transfer
+	defer io.Copy(io.Discard, r)
+
+	reader, err := c.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	tarReader := tar.NewReader(reader)
+	var pending *tar.Header
+	for {
+		header, err := tarReader.Next()
+		if pending != nil && (err == nil || err == io.EOF) {
+			ac.files.Add(1)
+			if pending.Typeflag == tar.TypeReg || pending.Typeflag == tar.TypeGNUSparse {
+				ac.bytes.Add(pending.Size)
+			}
+		}
+		if err != nil {
+			return
+		}
+
+		pending = nil
+		if header.Typeflag != tar.TypeDir {
+			pending = header
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestScanCommand(t *testing.T) {
+	find, err := exec.LookPath("find")
+	if err != nil {
+		t.Skip("find not available")
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "a.txt"), make([]byte, 12), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.log"), make([]byte, 3), 0644)
+	os.Symlink("a.txt", filepath.Join(dir, "link"))
+
+	// A find without -printf, as on BSD and busybox
+	shim := t.TempDir()
+	script := "#!/bin/sh\nfor a; do [ \"$a\" = -printf ] && exit 1; done\nexec " + find + " \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(shim, "find"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+	}{
+		{"gnu find", os.Getenv("PATH")},
+		{"without printf", shim + string(os.PathListSeparator) + os.Getenv("PATH")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(filters *core.FilterOptions) string {
+				cmd := exec.Command("sh", "-c", scanCommand(dir, filters))
+				cmd.Env = append(os.Environ(), "PATH="+tt.path)
+				out, err := cmd.Output()
+				if err != nil {
+					t.Fatalf("scan: %v", err)
+				}
+				return strings.TrimSpace(string(out))
+			}
+
+			// The symlink counts as a file without bytes
+			if got := run(nil); got != "3 15" {
+				t.Errorf("scan = %q, want \"3 15\"", got)
+			}
+			if got := run(&core.FilterOptions{Exclude: []string{"*.log"}}); got != "2 12" {
+				t.Errorf("filtered scan = %q, want \"2 12\"", got)
+			}
+		})
+	}
+}
+
+func TestSourceTarCommand(t *testing.T) {
+	got := sourceTarCommand("/data/my dir", nil, core.DefaultPreserve())
+	want := `tar -cf - -C '/data/my dir' .`
+	if got != want {
+		t.Errorf("command = %s, want %s", got, want)
+	}
+
+	filters := &core.FilterOptions{Include: []string{"*.txt", "sub"}, Exclude: []string{"*.log", "build/tmp"}}
+	got = sourceTarCommand("~/data", filters, core.PreserveOptions{Sparse: true})
+	want = `cd ~/'data' && find . -mindepth 1 \( ! \( -name '*.txt' -o -name 'sub' \) -o -name '*.log' \) -prune -o -print0 | ` +
+		`tar -cf - --null --no-recursion '--hard-dereference' '--sparse' -T -`
+	if got != want {
+		t.Errorf("filtered command = %s, want %s", got, want)
+	}
+}
+
+func TestDestTarCommand(t *testing.T) {
+	c, err := codec.New(core.CompressionZstd, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := destTarCommand("/backup", c, core.DefaultPreserve())
+	want := `mkdir -p '/backup' && zstd -q -d -c | tar -xf - -C '/backup'`
+	if got != want {
+		t.Errorf("command = %s, want %s", got, want)
+	}
+}
+
+func TestArchiveCounter(t *testing.T) {
+	var buf bytes.Buffer
+	c := codec.Codec{Name: core.CompressionGzip}
+	compressor, err := c.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarWriter := tar.NewWriter(compressor)
+	tarWriter.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755})
+	tarWriter.WriteHeader(&tar.Header{Name: "./a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	tarWriter.Write([]byte("hello"))
+	tarWriter.WriteHeader(&tar.Header{Name: "./link", Typeflag: tar.TypeSymlink, Linkname: "a.txt"})
+	tarWriter.Close()
+	compressor.Close()
+
+	counter := &archiveCounter{}
+	counter.follow(&buf, c)
+	if files, bytes := counter.files.Load(), counter.bytes.Load(); files != 2 || bytes != 5 {
+		t.Errorf("counted %d files, %d bytes, want 2 files, 5 bytes", files, bytes)
+	}
+}
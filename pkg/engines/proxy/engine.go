@@ -3,9 +3,12 @@ package proxy
 import (
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"time"
 
+	"github.com/larrydiffey/difpipe/pkg/benchmark"
+	"github.com/larrydiffey/difpipe/pkg/checkpoint"
+	"github.com/larrydiffey/difpipe/pkg/codec"
 	"github.com/larrydiffey/difpipe/pkg/core"
 	"github.com/larrydiffey/difpipe/pkg/stream"
 	"github.com/larrydiffey/difpipe/pkg/transport"
@@ -13,14 +16,15 @@ import (
 
 // Engine implements TransferEngine for remote-to-remote streaming proxy
 type Engine struct {
-	transport transport.Transport
-	progress  core.ProgressReporter
+	transport   transport.Transport
+	progress    core.ProgressReporter
+	checkpoints *checkpoint.Manager
 }
 
 // New creates a new proxy engine
 func New() *Engine {
 	return &Engine{
-		transport: transport.New(),
+		transport: transport.Shared(),
 	}
 }
 
@@ -30,6 +34,13 @@ func (e *Engine) WithProgress(reporter core.ProgressReporter) *Engine {
 	return e
 }
 
+// WithCheckpoints sets where file transfers are checkpointed, instead of
+// ~/.difpipe/checkpoints
+func (e *Engine) WithCheckpoints(m *checkpoint.Manager) *Engine {
+	e.checkpoints = m
+	return e
+}
+
 // Name returns the engine name
 func (e *Engine) Name() string {
 	return "proxy"
@@ -41,6 +52,26 @@ func (e *Engine) SupportsProtocol(protocol string) bool {
 	return protocol == "ssh"
 }
 
+// SupportsTransfer checks if the proxy can transfer between two protocols
+func (e *Engine) SupportsTransfer(source, dest core.Protocol) bool {
+	return source == core.ProtocolSSH && dest == core.ProtocolSSH
+}
+
+// Capabilities describes the proxy for the given options. Files go over
+// SFTP unless a codec is asked for, which both hosts are assumed to have.
+func (e *Engine) Capabilities(opts *core.TransferOptions) core.EngineCapabilities {
+	caps := core.EngineCapabilities{
+		Resume:           opts.Checkpoint, // Files continue from the destination's partial copy
+		RequiredBinaries: []string{"sftp (remote)"},
+		Compression:      core.CompressionNone,
+	}
+	if requested, err := codec.New(opts.Compression, opts.CompressionLevel); err == nil && requested.Enabled() {
+		caps.Compression = requested.Name
+		caps.RequiredBinaries = []string{requested.Binary() + " (remote)"}
+	}
+	return caps
+}
+
 // Transfer performs the remote-to-remote transfer
 func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*core.TransferResult, error) {
 	startTime := time.Now()
@@ -93,6 +124,27 @@ func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*cor
 	}
 	defer e.transport.Close(destClient)
 
+	// Directories go through tar on both hosts
+	isDir, err := e.isRemoteDir(ctx, sourceClient, sourceLoc.Path)
+	if err != nil {
+		return nil, fmt.Errorf("check source: %w", err)
+	}
+	if isDir {
+		if err := e.transferDirectory(ctx, opts, result, sourceClient, destClient, sourceLoc, destLoc); err != nil {
+			return e.fail(result, err), err
+		}
+		return e.complete(result, startTime), nil
+	}
+
+	// Files go over SFTP, the shell is left for codecs and hosts without it
+	if session := e.openSFTP(ctx, opts, sourceClient, destClient); session != nil {
+		defer session.Close()
+		if err := e.transferSFTP(ctx, opts, result, session, sourceLoc, destLoc, sourceAuth, destAuth); err != nil {
+			return e.fail(result, err), err
+		}
+		return e.complete(result, startTime), nil
+	}
+
 	// Get file size from source
 	fileSize, err := transport.GetFileSize(ctx, e.transport, sourceClient, sourceLoc.Path)
 	if err != nil {
@@ -105,47 +157,147 @@ func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*cor
 		e.progress.Start(fileSize, fmt.Sprintf("Transferring %d bytes", fileSize))
 	}
 
-	// Start source stream: cat file
-	sourceCmd := fmt.Sprintf("cat %s", sourceLoc.Path)
-	sourceStream, err := e.transport.StreamCommand(ctx, sourceClient, sourceCmd)
+	// Compress on the source and decompress on the destination with a codec
+	// both hosts have
+	c, err := e.negotiateCodec(ctx, opts, sourceClient, destClient)
+	if err != nil {
+		return nil, err
+	}
+	result.Compression = c.Name
+
+	// Large files go as ranges over several connections when dd allows
+	if opts.Parallel > 1 && fileSize >= 2*minRangeSize {
+		ranges, err := e.supportsRanges(ctx, sourceClient, destClient)
+		if err != nil {
+			return nil, err
+		}
+		if ranges {
+			copier := &shellRanges{e: e, c: c, sourceLoc: sourceLoc, destLoc: destLoc, sourceAuth: sourceAuth, destAuth: destAuth, destClient: destClient}
+			if err := e.transferRanges(ctx, opts, result, copier, e.shellHashers(sourceClient, destClient), sourceLoc, destLoc, destLoc.Path, fileSize); err != nil {
+				return e.fail(result, err), err
+			}
+			return e.complete(result, startTime), nil
+		}
+	}
+
+	// Continue the partial copy of an interrupted run
+	var checkpoints *checkpoint.Manager
+	var state *core.CheckpointState
+	var offset int64
+	if opts.Checkpoint {
+		if checkpoints, err = e.checkpointManager(); err != nil {
+			return nil, err
+		}
+		partialSize := func(ctx context.Context) (int64, error) {
+			return e.partialSize(ctx, destClient, destLoc.Path)
+		}
+		state, offset, err = e.resume(ctx, checkpoints, opts, e.shellHashers(sourceClient, destClient), sourceLoc, destLoc.Path, partialSize, fileSize)
+		if err != nil {
+			return nil, err
+		}
+		_ = checkpoints.Save(state.TransferID, state)
+		if offset > 0 && e.progress != nil {
+			e.progress.Start(fileSize, fmt.Sprintf("Resuming at %d of %d bytes", offset, fileSize))
+		}
+	}
+
+	sourceStream, err := e.transport.StreamCommand(ctx, sourceClient, sourceCommand(sourceLoc.Path, offset, c))
 	if err != nil {
 		return nil, fmt.Errorf("start source stream: %w", err)
 	}
-	defer sourceStream.Close()
 
-	// Start destination stream: cat > file
-	destCmd := fmt.Sprintf("cat > %s", destLoc.Path)
-	destStream, err := e.transport.StreamWrite(ctx, destClient, destCmd)
+	// Hash the file as it passes. A resumed copy is hashed on both hosts
+	// instead, once it is complete.
+	var digest *streamDigest
+	var source io.Reader = sourceStream
+	if opts.Verify && offset == 0 {
+		digest = newStreamDigest(c)
+		source = io.TeeReader(sourceStream, digest)
+	}
+
+	destStream, err := e.transport.StreamWrite(ctx, destClient, destCommand(destLoc.Path, offset, c))
 	if err != nil {
+		sourceStream.Close()
 		return nil, fmt.Errorf("start destination stream: %w", err)
 	}
-	defer destStream.Close()
 
 	// Create streaming pipeline
 	pipelineConfig := &stream.Config{
 		BufferSize: 1024 * 1024, // 1MB buffer
 		ProgressFunc: func(bytesTransferred int64, speed float64) {
 			if e.progress != nil {
-				e.progress.Update(bytesTransferred, fmt.Sprintf("%.1f MB/s", speed/(1024*1024)))
+				e.progress.Update(offset+bytesTransferred, fmt.Sprintf("%.1f MB/s", speed/(1024*1024)))
+			}
+			// Compressed bytes say nothing about the file, a resumed run
+			// asks the destination anyway
+			if state != nil && !c.Enabled() {
+				state.BytesDone = offset + bytesTransferred
+				_ = checkpoints.Save(state.TransferID, state)
 			}
 		},
 	}
 
-	pipeline := stream.New(sourceStream, destStream, pipelineConfig)
+	pipeline := stream.New(source, destStream, pipelineConfig)
 
-	// Start pipeline
-	if err := pipeline.Start(ctx); err != nil {
-		result.Success = false
-		result.Error = err
-		if e.progress != nil {
-			e.progress.Error(err)
-		}
-		return result, fmt.Errorf("pipeline error: %w", err)
+	// Start pipeline, the destination must also have written everything
+	err = pipeline.Start(ctx)
+	if closeErr := destStream.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("pipeline error: %w", err)
+	}
+	// A failed source command ends its stream early, only its exit status
+	// tells a truncated copy from a complete one
+	if closeErr := sourceStream.Close(); err == nil {
+		err = closeErr
 	}
 
 	// Get final stats
 	stats := pipeline.Stats()
-	result.BytesDone = stats.BytesWritten
+	result.BytesDone = offset + stats.BytesWritten
+	if c.Enabled() {
+		// The pipeline only saw compressed bytes
+		result.BytesDone = fileSize
+	}
+	if err == nil && result.BytesDone != fileSize {
+		err = fmt.Errorf("copied %d of %d bytes, the source changed", result.BytesDone, fileSize)
+	}
+
+	if err != nil {
+		if digest != nil {
+			digest.Sum()
+		}
+		if state != nil {
+			_ = checkpoints.Save(state.TransferID, state)
+		}
+		return e.fail(result, err), err
+	}
+	if state != nil {
+		_ = checkpoints.Delete(state.TransferID)
+	}
+
+	if opts.Verify {
+		if result.Checksum, err = e.verifyFile(ctx, e.shellHashers(sourceClient, destClient), sourceLoc.Path, destLoc.Path, digest); err != nil {
+			return e.fail(result, err), fmt.Errorf("verify: %w", err)
+		}
+	}
+
+	return e.complete(result, startTime), nil
+}
+
+// fail marks a transfer failed
+func (e *Engine) fail(result *core.TransferResult, err error) *core.TransferResult {
+	result.Success = false
+	result.Error = err
+	if e.progress != nil {
+		e.progress.Error(err)
+	}
+	return result
+}
+
+// complete marks a transfer successful and fills in its timing
+func (e *Engine) complete(result *core.TransferResult, startTime time.Time) *core.TransferResult {
 	result.Duration = time.Since(startTime)
 	result.Success = true
 	result.Message = "Transfer completed successfully"
@@ -160,7 +312,7 @@ func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*cor
 		e.progress.Complete(result.Message)
 	}
 
-	return result, nil
+	return result
 }
 
 // Estimate provides transfer estimation
@@ -190,61 +342,89 @@ func (e *Engine) Estimate(ctx context.Context, opts *core.TransferOptions) (*cor
 	}
 	defer e.transport.Close(sourceClient)
 
-	// Get file size
-	fileSize, err := transport.GetFileSize(ctx, e.transport, sourceClient, sourceLoc.Path)
+	isDir, err := e.isRemoteDir(ctx, sourceClient, sourceLoc.Path)
 	if err != nil {
-		return nil, fmt.Errorf("get file size: %w", err)
+		return nil, fmt.Errorf("check source: %w", err)
+	}
+
+	var fileSize, files int64
+	if isDir {
+		files, fileSize, err = e.scanDirectory(ctx, sourceClient, sourceLoc.Path, opts.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("scan source: %w", err)
+		}
+	} else {
+		fileSize, err = transport.GetFileSize(ctx, e.transport, sourceClient, sourceLoc.Path)
+		if err != nil {
+			return nil, fmt.Errorf("get file size: %w", err)
+		}
+		files = 1
 	}
 
 	estimate.BytesTotal = fileSize
-	estimate.FilesTotal = 1
+	estimate.FilesTotal = files
 
-	// Estimate time (assume 50 MB/s for SSH transfers)
+	// Estimate time from benchmarks (assume 50 MB/s for SSH transfers)
 	if fileSize > 0 {
-		estimatedSeconds := float64(fileSize) / (50 * 1024 * 1024)
-		estimate.EstimatedTime = time.Duration(estimatedSeconds * float64(time.Second))
-		estimate.EstimatedSpeed = "~50 MB/s"
+		timing := benchmark.EstimateTransfer(opts.Source, opts.Destination, fileSize, files, 50*1024*1024)
+		estimate.EstimatedTime = timing.Duration
+		estimate.EstimatedSpeed = timing.Speed
 	}
 
 	return estimate, nil
 }
 
+// negotiateCodec picks the requested codec, or the best one both hosts
+// have when it is missing on either
+func (e *Engine) negotiateCodec(ctx context.Context, opts *core.TransferOptions, sourceClient, destClient *transport.SSHClient) (codec.Codec, error) {
+	requested, err := codec.New(opts.Compression, opts.CompressionLevel)
+	if err != nil {
+		return codec.Codec{}, err
+	}
+	if requested.Name == core.CompressionNone {
+		return requested, nil
+	}
+
+	sourceCodecs, err := codec.Probe(ctx, e.transport, sourceClient)
+	if err != nil {
+		return codec.Codec{}, fmt.Errorf("source: %w", err)
+	}
+	destCodecs, err := codec.Probe(ctx, e.transport, destClient)
+	if err != nil {
+		return codec.Codec{}, fmt.Errorf("destination: %w", err)
+	}
+	return codec.Negotiate(requested, codec.Intersect(sourceCodecs, destCodecs)), nil
+}
+
 // getAuthentication gets authentication methods for source and destination
+// and sets the jump hosts of their locations
 func (e *Engine) getAuthentication(opts *core.TransferOptions, sourceLoc, destLoc *transport.RemoteLocation) (transport.AuthMethod, transport.AuthMethod, error) {
-	var sourceAuth, destAuth transport.AuthMethod
-
-	// Try environment variable for source password
-	if sourcePassword := os.Getenv("DIFPIPE_SOURCE_PASSWORD"); sourcePassword != "" {
-		sourceAuth = transport.NewPasswordAuth(sourcePassword)
-	} else if opts.Auth != nil && opts.Auth.SourceAuth != nil && len(opts.Auth.SourceAuth) > 0 {
-		var err error
-		sourceAuth, err = transport.AuthFromConfig(opts.Auth.SourceAuth)
-		if err != nil {
-			return nil, nil, fmt.Errorf("source auth: %w", err)
-		}
-	} else {
-		// Try default authentication methods
-		sourceAuth = transport.NewMultiAuth(
-			transport.NewAgentAuth(),
-		)
+	var sourceConfig, destConfig map[string]interface{}
+	var sourceJumps, destJumps []core.JumpHost
+	if opts.Auth != nil {
+		sourceConfig = opts.Auth.SourceAuth
+		destConfig = opts.Auth.DestAuth
+		sourceJumps = opts.Auth.SourceJumps
+		destJumps = opts.Auth.DestJumps
+	}
+
+	sourceAuth, err := transport.ResolveAuth(sourceConfig, opts.Auth.SourcePasswordEnv())
+	if err != nil {
+		return nil, nil, fmt.Errorf("source auth: %w", err)
+	}
+	if err = sourceLoc.ResolveJumps(sourceJumps); err != nil {
+		return nil, nil, fmt.Errorf("source: %w", err)
 	}
 
 	// Get dest auth
+	var destAuth transport.AuthMethod
 	if destLoc != nil {
-		// Try environment variable for dest password
-		if destPassword := os.Getenv("DIFPIPE_DEST_PASSWORD"); destPassword != "" {
-			destAuth = transport.NewPasswordAuth(destPassword)
-		} else if opts.Auth != nil && opts.Auth.DestAuth != nil && len(opts.Auth.DestAuth) > 0 {
-			var err error
-			destAuth, err = transport.AuthFromConfig(opts.Auth.DestAuth)
-			if err != nil {
-				return nil, nil, fmt.Errorf("dest auth: %w", err)
-			}
-		} else {
-			// Try default authentication methods
-			destAuth = transport.NewMultiAuth(
-				transport.NewAgentAuth(),
-			)
+		destAuth, err = transport.ResolveAuth(destConfig, opts.Auth.DestPasswordEnv())
+		if err != nil {
+			return nil, nil, fmt.Errorf("dest auth: %w", err)
+		}
+		if err = destLoc.ResolveJumps(destJumps); err != nil {
+			return nil, nil, fmt.Errorf("destination: %w", err)
 		}
 	}
 
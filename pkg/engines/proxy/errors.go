@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// ChecksumError is returned when the destination's copy doesn't hash like
+// the source
+type ChecksumError struct {
+	Path        string // On the destination
+	Source      string // SHA-256 of the source, hex
+	Destination string // SHA-256 of the copy, hex
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: source sha256 %s, destination sha256 %s",
+		e.Path, e.Source, e.Destination)
+}
+
+// ExitCode reports the mismatch as a failed integrity check
+func (e *ChecksumError) ExitCode() int {
+	return core.ExitChecksumMismatch
+}
+
+// SFTPError is returned when an SFTP operation on the source or
+// destination fails
+type SFTPError struct {
+	Host string // "source" or "destination"
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *SFTPError) Error() string {
+	return fmt.Sprintf("%s %s %s: %v", e.Op, e.Host, e.Path, e.Err)
+}
+
+func (e *SFTPError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode maps missing sources and unwritable destinations to exit codes
+func (e *SFTPError) ExitCode() int {
+	switch {
+	case errors.Is(e.Err, fs.ErrPermission):
+		return core.ExitPermissionDenied
+	case e.Host == "source" && errors.Is(e.Err, fs.ErrNotExist):
+		return core.ExitSourceNotFound
+	case e.Host == "destination" && errors.Is(e.Err, fs.ErrNotExist):
+		return core.ExitDestNotWritable
+	default:
+		return core.ExitTransferFailed
+	}
+}
@@ -0,0 +1,398 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/larrydiffey/difpipe/internal/parallel"
+	"github.com/larrydiffey/difpipe/pkg/checkpoint"
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/stream"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+const (
+	// minRangeSize is the smallest range a file is split into. Files under
+	// two ranges go over a single stream.
+	minRangeSize = 64 << 20
+
+	// maxRangeSize bounds the work redone for a range that was interrupted
+	maxRangeSize = 1 << 30
+
+	// rangeProbe fails where dd can't skip, count and seek in bytes
+	rangeProbe = "dd if=/dev/null of=/dev/null bs=1M iflag=skip_bytes,count_bytes oflag=seek_bytes status=none"
+)
+
+// supportsRanges reports whether dd on both hosts can copy byte ranges
+func (e *Engine) supportsRanges(ctx context.Context, sourceClient, destClient *transport.SSHClient) (bool, error) {
+	for _, client := range []*transport.SSHClient{sourceClient, destClient} {
+		result, err := e.transport.ExecuteCommand(ctx, client, rangeProbe)
+		if err != nil {
+			return false, fmt.Errorf("probe dd: %w", err)
+		}
+		if result.ExitCode != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rangeCopier copies byte ranges of a file in place into its copy on the
+// destination
+type rangeCopier interface {
+	// partialSize returns the size of the copy, zero when it doesn't exist
+	partialSize(ctx context.Context) (int64, error)
+
+	// create empties the copy before a run that starts over
+	create(ctx context.Context) error
+
+	// open connects one worker to both hosts
+	open(ctx context.Context) (rangeSender, error)
+}
+
+// rangeSender sends ranges over the connections of one worker
+type rangeSender interface {
+	send(ctx context.Context, r core.ByteRange, progress func(int64)) error
+	close()
+}
+
+// transferRanges copies a file as ranges sent concurrently, each worker
+// over connections of its own, into destPath on the destination.
+// Completed ranges are checkpointed so a rerun only sends the rest.
+func (e *Engine) transferRanges(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult, copier rangeCopier, h hashers, sourceLoc, destLoc *transport.RemoteLocation, destPath string, size int64) error {
+	var checkpoints *checkpoint.Manager
+	state := newCheckpointState(opts, sourceLoc, size)
+	if opts.Checkpoint {
+		var err error
+		if checkpoints, err = e.checkpointManager(); err != nil {
+			return err
+		}
+		if state, err = e.resumeRanges(ctx, checkpoints, opts, copier, h, sourceLoc, destPath, size); err != nil {
+			return err
+		}
+		_ = checkpoints.Save(state.TransferID, state)
+	}
+
+	// Ranges are written in place, so start from an empty file
+	if len(state.CompletedRanges) == 0 {
+		if err := copier.create(ctx); err != nil {
+			return fmt.Errorf("create destination: %w", err)
+		}
+	}
+
+	plan := planRanges(size, state.CompletedRanges, rangeSize(size, opts.Parallel))
+	workers := min(e.rangeWorkers(opts.Parallel, sourceLoc, destLoc), len(plan))
+	progress := &rangeProgress{parent: e.progress, inflight: make([]int64, workers)}
+	for _, r := range mergeRanges(state.CompletedRanges) {
+		progress.done += r.Length
+	}
+	if e.progress != nil {
+		e.progress.Start(size, fmt.Sprintf("Transferring %d bytes as %d ranges over %d connections",
+			size-progress.done, len(plan), workers))
+	}
+
+	ranges := make(chan core.ByteRange, len(plan))
+	for _, r := range plan {
+		ranges <- r
+	}
+	close(ranges)
+
+	// A failing worker stops the others
+	rangeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mutex sync.Mutex
+	completed := func(r core.ByteRange) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		state.CompletedRanges = mergeRanges(append(state.CompletedRanges, r))
+		state.BytesDone += r.Length
+		if checkpoints != nil {
+			_ = checkpoints.Save(state.TransferID, state)
+		}
+	}
+
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = sendRanges(rangeCtx, copier, ranges, progress, i, completed); errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	result.BytesDone = state.BytesDone
+	if err := parallel.FirstError(errs); err != nil {
+		return err
+	}
+	if checkpoints != nil {
+		_ = checkpoints.Delete(state.TransferID)
+	}
+	result.BytesDone = size
+
+	// Nothing saw the whole file pass, so both hosts hash theirs
+	if opts.Verify {
+		checksum, err := e.verifyFile(ctx, h, sourceLoc.Path, destPath, nil)
+		if err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+		result.Checksum = checksum
+	}
+	return nil
+}
+
+// rangeWorkers returns how many workers send ranges at once. Each holds a
+// session on both hosts and the transfer holds one on each, so when both
+// are the same host fewer workers fit its session limit; more would wait
+// on each other's sessions for good.
+func (e *Engine) rangeWorkers(parallel int, sourceLoc, destLoc *transport.RemoteLocation) int {
+	pool, ok := e.transport.(*transport.Pool)
+	if !ok || sourceLoc.Host != destLoc.Host || sourceLoc.Port != destLoc.Port {
+		return parallel
+	}
+	return max(1, min(parallel, (pool.MaxSessions()-2)/2))
+}
+
+// resumeRanges loads the checkpoint of an interrupted run. The ranges it
+// completed count when the destination's copy still exists; a run that
+// used a single stream continues after its verified partial copy.
+func (e *Engine) resumeRanges(ctx context.Context, m *checkpoint.Manager, opts *core.TransferOptions, copier rangeCopier, h hashers, sourceLoc *transport.RemoteLocation, destPath string, size int64) (*core.CheckpointState, error) {
+	state, err := m.Load(checkpointID(opts.Source, opts.Destination))
+	if err != nil || state.BytesTotal != size {
+		return newCheckpointState(opts, sourceLoc, size), nil
+	}
+
+	if len(state.CompletedRanges) == 0 {
+		state, offset, err := e.resume(ctx, m, opts, h, sourceLoc, destPath, copier.partialSize, size)
+		if err != nil {
+			return nil, err
+		}
+		if offset > 0 {
+			state.CompletedRanges = []core.ByteRange{{Offset: 0, Length: offset}}
+		}
+		return state, nil
+	}
+
+	partial, err := copier.partialSize(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("check partial copy: %w", err)
+	}
+	if partial == 0 {
+		state.CompletedRanges = nil
+		state.BytesDone = 0
+	}
+	return state, nil
+}
+
+// sendRanges opens a worker's connections and sends ranges until none are
+// left
+func sendRanges(ctx context.Context, copier rangeCopier, ranges <-chan core.ByteRange, progress *rangeProgress, worker int, completed func(core.ByteRange)) error {
+	sender, err := copier.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer sender.close()
+
+	for r := range ranges {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := sender.send(ctx, r, func(n int64) {
+			progress.update(worker, n)
+		})
+		if err != nil {
+			return fmt.Errorf("range at %d: %w", r.Offset, err)
+		}
+		progress.finish(worker, r.Length)
+		completed(r)
+	}
+	return nil
+}
+
+// shellRanges copies ranges with dd on both hosts, compressed with a codec
+type shellRanges struct {
+	e                    *Engine
+	c                    codec.Codec
+	sourceLoc, destLoc   *transport.RemoteLocation
+	sourceAuth, destAuth transport.AuthMethod
+	destClient           *transport.SSHClient
+}
+
+func (s *shellRanges) partialSize(ctx context.Context) (int64, error) {
+	return s.e.partialSize(ctx, s.destClient, s.destLoc.Path)
+}
+
+func (s *shellRanges) create(ctx context.Context) error {
+	truncate, err := s.e.transport.ExecuteCommand(ctx, s.destClient, ": > "+transport.QuoteRemotePath(s.destLoc.Path))
+	if err != nil {
+		return err
+	}
+	if truncate.ExitCode != 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(truncate.Stderr)))
+	}
+	return nil
+}
+
+func (s *shellRanges) open(ctx context.Context) (rangeSender, error) {
+	sourceClient, err := s.e.transport.Connect(ctx, s.sourceLoc.SSHConfig(s.sourceAuth).WithExclusive())
+	if err != nil {
+		return nil, fmt.Errorf("connect to source: %w", err)
+	}
+
+	destClient, err := s.e.transport.Connect(ctx, s.destLoc.SSHConfig(s.destAuth).WithExclusive())
+	if err != nil {
+		s.e.transport.Close(sourceClient)
+		return nil, fmt.Errorf("connect to destination: %w", err)
+	}
+	return &shellSender{shellRanges: s, sourceClient: sourceClient, destClient: destClient}, nil
+}
+
+// shellSender streams ranges over one worker's connections
+type shellSender struct {
+	*shellRanges
+	sourceClient, destClient *transport.SSHClient
+}
+
+// send streams one range from the source to the destination
+func (s *shellSender) send(ctx context.Context, r core.ByteRange, progress func(int64)) error {
+	sourceStream, err := s.e.transport.StreamCommand(ctx, s.sourceClient, rangeSourceCommand(s.sourceLoc.Path, r, s.c))
+	if err != nil {
+		return fmt.Errorf("start source stream: %w", err)
+	}
+
+	// Like directories, the streams are left to the connections to end when
+	// the range fails
+	destStream, err := s.e.transport.StreamWrite(ctx, s.destClient, rangeDestCommand(s.destLoc.Path, r, s.c))
+	if err != nil {
+		return fmt.Errorf("start destination stream: %w", err)
+	}
+
+	pipeline := stream.New(sourceStream, destStream, &stream.Config{
+		BufferSize: 1024 * 1024, // 1MB buffer
+		ProgressFunc: func(bytesTransferred int64, speed float64) {
+			progress(bytesTransferred)
+		},
+	})
+	err = pipeline.Start(ctx)
+	if closeErr := destStream.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return sourceStream.Close()
+}
+
+func (s *shellSender) close() {
+	s.e.transport.Close(s.sourceClient)
+	s.e.transport.Close(s.destClient)
+}
+
+// rangeSourceCommand reads a range of a file, compressed with c
+func rangeSourceCommand(path string, r core.ByteRange, c codec.Codec) string {
+	return c.CompressOutput(fmt.Sprintf("dd if=%s bs=1M iflag=skip_bytes,count_bytes skip=%d count=%d status=none",
+		transport.QuoteRemotePath(path), r.Offset, r.Length))
+}
+
+// rangeDestCommand writes a range read from stdin in place into a file
+func rangeDestCommand(path string, r core.ByteRange, c codec.Codec) string {
+	write := fmt.Sprintf("dd of=%s bs=1M oflag=seek_bytes seek=%d conv=notrunc status=none",
+		transport.QuoteRemotePath(path), r.Offset)
+	if c.Enabled() {
+		write = c.DecompressCommand() + " | " + write
+	}
+	return write
+}
+
+// rangeSize picks the range size for a file sent by n workers, several
+// ranges each so one slow range doesn't hold up the end
+func rangeSize(size int64, n int) int64 {
+	return min(max(size/int64(n*8), minRangeSize), maxRangeSize)
+}
+
+// planRanges splits the parts of a file of size bytes that aren't done
+// into ranges of at most rangeLen bytes
+func planRanges(size int64, done []core.ByteRange, rangeLen int64) []core.ByteRange {
+	var plan []core.ByteRange
+	var pos int64
+	fill := func(end int64) {
+		for pos < end {
+			length := min(rangeLen, end-pos)
+			plan = append(plan, core.ByteRange{Offset: pos, Length: length})
+			pos += length
+		}
+	}
+	for _, r := range mergeRanges(done) {
+		fill(min(r.Offset, size))
+		pos = max(pos, r.Offset+r.Length)
+	}
+	fill(size)
+	return plan
+}
+
+// mergeRanges sorts ranges, joining those that overlap or touch
+func mergeRanges(ranges []core.ByteRange) []core.ByteRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b core.ByteRange) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	var merged []core.ByteRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if r.Offset <= last.Offset+last.Length {
+				last.Length = max(last.Offset+last.Length, r.Offset+r.Length) - last.Offset
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// rangeProgress adds up the bytes of completed ranges and of those in
+// flight. Compressed ranges count wire bytes until they complete.
+type rangeProgress struct {
+	parent   core.ProgressReporter
+	mutex    sync.Mutex
+	done     int64
+	inflight []int64
+}
+
+// update records the bytes a worker's current range has sent
+func (p *rangeProgress) update(worker int, n int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inflight[worker] = n
+	p.report()
+}
+
+// finish moves a worker's completed range to the done bytes
+func (p *rangeProgress) finish(worker int, length int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inflight[worker] = 0
+	p.done += length
+	p.report()
+}
+
+func (p *rangeProgress) report() {
+	if p.parent == nil {
+		return
+	}
+	total := p.done
+	for _, n := range p.inflight {
+		total += n
+	}
+	p.parent.Update(total, fmt.Sprintf("%d connections", len(p.inflight)))
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+func TestPlanRanges(t *testing.T) {
+	got := planRanges(250, nil, 100)
+	want := []core.ByteRange{{Offset: 0, Length: 100}, {Offset: 100, Length: 100}, {Offset: 200, Length: 50}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fresh plan = %v, want %v", got, want)
+	}
+
+	done := []core.ByteRange{{Offset: 100, Length: 100}, {Offset: 0, Length: 40}}
+	got = planRanges(250, done, 100)
+	want = []core.ByteRange{{Offset: 40, Length: 60}, {Offset: 200, Length: 50}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed plan = %v, want %v", got, want)
+	}
+
+	if got := planRanges(250, []core.ByteRange{{Offset: 0, Length: 250}}, 100); len(got) != 0 {
+		t.Errorf("complete plan = %v, want none", got)
+	}
+}
+
+func TestMergeRanges(t *testing.T) {
+	got := mergeRanges([]core.ByteRange{{Offset: 200, Length: 50}, {Offset: 0, Length: 100}, {Offset: 100, Length: 20}, {Offset: 110, Length: 5}})
+	want := []core.ByteRange{{Offset: 0, Length: 120}, {Offset: 200, Length: 50}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged = %v, want %v", got, want)
+	}
+}
+
+func TestRangeSize(t *testing.T) {
+	if got := rangeSize(200<<20, 4); got != minRangeSize {
+		t.Errorf("small file range = %d, want %d", got, minRangeSize)
+	}
+	if got := rangeSize(500<<30, 4); got != maxRangeSize {
+		t.Errorf("large file range = %d, want %d", got, maxRangeSize)
+	}
+}
+
+func TestRangeWorkers(t *testing.T) {
+	e := &Engine{transport: transport.NewPool(nil).WithMaxSessions(10)}
+	a := &transport.RemoteLocation{Host: "a", Port: 22}
+	b := &transport.RemoteLocation{Host: "b", Port: 22}
+	other := &transport.RemoteLocation{Host: "a", Port: 2222}
+
+	if got := e.rangeWorkers(8, a, b); got != 8 {
+		t.Errorf("workers between hosts = %d, want 8", got)
+	}
+	if got := e.rangeWorkers(8, a, other); got != 8 {
+		t.Errorf("workers between ports = %d, want 8", got)
+	}
+	// Two sessions each and two for the transfer fit ten
+	if got := e.rangeWorkers(8, a, a); got != 4 {
+		t.Errorf("workers within a host = %d, want 4", got)
+	}
+	if got := e.rangeWorkers(2, a, a); got != 2 {
+		t.Errorf("workers within a host = %d, want 2", got)
+	}
+}
+
+func TestRangeCommands(t *testing.T) {
+	r := core.ByteRange{Offset: 1 << 20, Length: 4096}
+	none := codec.Codec{Name: core.CompressionNone}
+
+	got := rangeSourceCommand("/data/dump", r, none)
+	want := `dd if='/data/dump' bs=1M iflag=skip_bytes,count_bytes skip=1048576 count=4096 status=none`
+	if got != want {
+		t.Errorf("source = %s, want %s", got, want)
+	}
+
+	gzip, err := codec.New(core.CompressionGzip, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = rangeDestCommand("/data/dump", r, gzip)
+	want = `gzip -d -c | dd of='/data/dump' bs=1M oflag=seek_bytes seek=1048576 conv=notrunc status=none`
+	if got != want {
+		t.Errorf("dest = %s, want %s", got, want)
+	}
+}
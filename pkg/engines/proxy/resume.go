@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/checkpoint"
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// checkpointID names the checkpoint of a file transfer, the same for every
+// run between the same source and destination
+func checkpointID(source, dest string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + dest))
+	return "proxy-" + hex.EncodeToString(sum[:8])
+}
+
+// checkpointManager returns the manager set with WithCheckpoints, or one
+// storing under ~/.difpipe/checkpoints
+func (e *Engine) checkpointManager() (*checkpoint.Manager, error) {
+	if e.checkpoints != nil {
+		return e.checkpoints, nil
+	}
+	m, err := checkpoint.New("")
+	if err != nil {
+		return nil, fmt.Errorf("open checkpoints: %w", err)
+	}
+	e.checkpoints = m
+	return m, nil
+}
+
+// newCheckpointState starts the checkpoint of a file transfer
+func newCheckpointState(opts *core.TransferOptions, sourceLoc *transport.RemoteLocation, size int64) *core.CheckpointState {
+	return &core.CheckpointState{
+		TransferID:  checkpointID(opts.Source, opts.Destination),
+		Source:      opts.Source,
+		Destination: opts.Destination,
+		Strategy:    core.StrategyProxy,
+		StartTime:   time.Now(),
+		BytesTotal:  size,
+		FilesTotal:  1,
+		CurrentFile: sourceLoc.Path,
+	}
+}
+
+// resume loads the checkpoint of an interrupted run and returns the offset
+// the transfer continues from: the size of the partial copy at destPath,
+// as reported by partialSize, when its contents match the start of the
+// source. Without a checkpoint, or when the source size changed, a fresh
+// state starting at zero is returned.
+func (e *Engine) resume(ctx context.Context, m *checkpoint.Manager, opts *core.TransferOptions, h hashers, sourceLoc *transport.RemoteLocation, destPath string, partialSize func(context.Context) (int64, error), size int64) (*core.CheckpointState, int64, error) {
+	state, err := m.Load(checkpointID(opts.Source, opts.Destination))
+	if err != nil || state.BytesTotal != size {
+		return newCheckpointState(opts, sourceLoc, size), 0, nil
+	}
+
+	partial, err := partialSize(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("check partial copy: %w", err)
+	}
+	state.BytesDone = 0
+	if partial == 0 || partial > size {
+		return state, 0, nil
+	}
+
+	matches, err := prefixMatches(ctx, h, sourceLoc.Path, destPath, partial)
+	if err != nil {
+		return nil, 0, fmt.Errorf("verify partial copy: %w", err)
+	}
+	if !matches {
+		return state, 0, nil
+	}
+	state.BytesDone = partial
+	return state, partial, nil
+}
+
+// partialSize returns the size of a file on the host, zero when it doesn't
+// exist
+func (e *Engine) partialSize(ctx context.Context, client *transport.SSHClient, path string) (int64, error) {
+	quoted := transport.QuoteRemotePath(path)
+	cmd := fmt.Sprintf("if [ -f %[1]s ]; then stat -c%%s %[1]s 2>/dev/null || stat -f%%z %[1]s; else echo 0; fi", quoted)
+	result, err := e.transport.ExecuteCommand(ctx, client, cmd)
+	if err != nil {
+		return 0, fmt.Errorf("execute stat command: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return 0, fmt.Errorf("stat failed: %s", strings.TrimSpace(string(result.Stderr)))
+	}
+
+	var size int64
+	if _, err := fmt.Sscanf(string(result.Stdout), "%d", &size); err != nil {
+		return 0, fmt.Errorf("parse size: %w", err)
+	}
+	return size, nil
+}
+
+// prefixMatches hashes the first n bytes of the source and destination
+// files and reports whether they are equal
+func prefixMatches(ctx context.Context, h hashers, sourcePath, destPath string, n int64) (bool, error) {
+	sourceSum, destSum, err := h.both(ctx, sourcePath, destPath, n)
+	if err != nil {
+		return false, err
+	}
+	return sourceSum == destSum, nil
+}
+
+// sourceCommand streams a file from offset, compressed with c
+func sourceCommand(path string, offset int64, c codec.Codec) string {
+	quoted := transport.QuoteRemotePath(path)
+	if offset == 0 {
+		if c.Enabled() {
+			return fmt.Sprintf("%s < %s", c.CompressCommand(), quoted)
+		}
+		return fmt.Sprintf("cat %s", quoted)
+	}
+	// tail counts bytes from one
+	return c.CompressOutput(fmt.Sprintf("tail -c +%d %s", offset+1, quoted))
+}
+
+// destCommand writes the stream to a file, appending to the partial copy
+// when resuming at offset
+func destCommand(path string, offset int64, c codec.Codec) string {
+	redirect := ">"
+	if offset > 0 {
+		redirect = ">>"
+	}
+	if c.Enabled() {
+		return fmt.Sprintf("%s %s %s", c.DecompressCommand(), redirect, transport.QuoteRemotePath(path))
+	}
+	return fmt.Sprintf("cat %s %s", redirect, transport.QuoteRemotePath(path))
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestCheckpointID(t *testing.T) {
+	id := checkpointID("root@a:/data/f", "root@b:/data/f")
+	if id != checkpointID("root@a:/data/f", "root@b:/data/f") {
+		t.Error("checkpoint ID differs between runs")
+	}
+	if id == checkpointID("root@b:/data/f", "root@a:/data/f") {
+		t.Error("reversed transfer shares the checkpoint")
+	}
+}
+
+func TestResumeCommands(t *testing.T) {
+	none := codec.Codec{Name: core.CompressionNone}
+	lz4, err := codec.New(core.CompressionLz4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		c      codec.Codec
+		source string
+		dest   string
+	}{
+		{"fresh", 0, none, `cat '/data/a b'`, `cat > '/data/a b'`},
+		{"fresh compressed", 0, lz4, `lz4 -c -q < '/data/a b'`, `lz4 -q -d -c > '/data/a b'`},
+		{"resumed", 1024, none, `tail -c +1025 '/data/a b'`, `cat >> '/data/a b'`},
+		{"resumed compressed", 1024, lz4, lz4.CompressOutput(`tail -c +1025 '/data/a b'`), `lz4 -q -d -c >> '/data/a b'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceCommand("/data/a b", tt.offset, tt.c); got != tt.source {
+				t.Errorf("source = %s, want %s", got, tt.source)
+			}
+			if got := destCommand("/data/a b", tt.offset, tt.c); got != tt.dest {
+				t.Errorf("dest = %s, want %s", got, tt.dest)
+			}
+		})
+	}
+}
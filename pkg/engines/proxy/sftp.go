@@ -0,0 +1,338 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/pkg/sftp"
+
+	"github.com/larrydiffey/difpipe/pkg/checkpoint"
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/stream"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// sftpBufferSize is how much is read or written per SFTP call, split into
+// concurrent requests by the client
+const sftpBufferSize = 1 << 20
+
+// sftpSession holds SFTP clients on both hosts
+type sftpSession struct {
+	source, dest *sftp.Client
+}
+
+// openSFTP starts SFTP on both hosts for a file transfer. It returns nil
+// when a codec was asked for, which only the shell path applies, or when
+// either host has no SFTP subsystem.
+func (e *Engine) openSFTP(ctx context.Context, opts *core.TransferOptions, sourceClient, destClient *transport.SSHClient) *sftpSession {
+	requested, err := codec.New(opts.Compression, opts.CompressionLevel)
+	if err != nil || requested.Enabled() {
+		return nil
+	}
+
+	source, err := e.transport.OpenSFTP(ctx, sourceClient)
+	if err != nil {
+		return nil
+	}
+	dest, err := e.transport.OpenSFTP(ctx, destClient)
+	if err != nil {
+		source.Close()
+		return nil
+	}
+	return &sftpSession{source: source, dest: dest}
+}
+
+func (s *sftpSession) Close() {
+	s.source.Close()
+	s.dest.Close()
+}
+
+// partPath names the file a copy is written to until it is complete
+func partPath(dest string) string {
+	dir, base := path.Split(dest)
+	return dir + "." + base + ".difpipe-part"
+}
+
+// sftpSize returns the size of a file over SFTP, zero when it doesn't exist
+func sftpSize(client *sftp.Client, remotePath string) func(context.Context) (int64, error) {
+	return func(ctx context.Context) (int64, error) {
+		info, err := client.Stat(transport.SFTPPath(remotePath))
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+}
+
+// transferSFTP copies a file over SFTP into a part file next to the
+// destination, which replaces the destination once it is complete and
+// verified. The source's mode and modification time are kept.
+func (e *Engine) transferSFTP(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult, session *sftpSession, sourceLoc, destLoc *transport.RemoteLocation, sourceAuth, destAuth transport.AuthMethod) error {
+	info, err := session.source.Stat(transport.SFTPPath(sourceLoc.Path))
+	if err != nil {
+		return &SFTPError{Host: "source", Op: "stat", Path: sourceLoc.Path, Err: err}
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("source %s is not a regular file", sourceLoc.Path)
+	}
+	size := info.Size()
+	part := partPath(destLoc.Path)
+
+	result.BytesTotal = size
+	result.Compression = core.CompressionNone
+	if e.progress != nil {
+		e.progress.Start(size, fmt.Sprintf("Transferring %d bytes over SFTP", size))
+	}
+
+	// Large files go as ranges over several connections
+	if opts.Parallel > 1 && size >= 2*minRangeSize {
+		copier := &sftpRanges{e: e, sourceLoc: sourceLoc, destLoc: destLoc, sourceAuth: sourceAuth, destAuth: destAuth, dest: session.dest, destPath: part}
+		err = e.transferRanges(ctx, opts, result, copier, session.hashers(), sourceLoc, destLoc, part, size)
+	} else {
+		err = e.streamSFTP(ctx, opts, result, session, sourceLoc, part, size)
+	}
+	if err != nil {
+		// Without a checkpoint the part file can't be resumed
+		if !opts.Checkpoint {
+			_ = session.dest.Remove(transport.SFTPPath(part))
+		}
+		return err
+	}
+
+	partFile := transport.SFTPPath(part)
+	if err := session.dest.Chmod(partFile, info.Mode().Perm()); err != nil {
+		return &SFTPError{Host: "destination", Op: "chmod", Path: part, Err: err}
+	}
+	if err := session.dest.Chtimes(partFile, info.ModTime(), info.ModTime()); err != nil {
+		return &SFTPError{Host: "destination", Op: "chtimes", Path: part, Err: err}
+	}
+	if err := replaceFile(session.dest, partFile, transport.SFTPPath(destLoc.Path)); err != nil {
+		return &SFTPError{Host: "destination", Op: "rename", Path: destLoc.Path, Err: err}
+	}
+	return nil
+}
+
+// streamSFTP copies a file as one stream into the part file, continuing
+// the part file of an interrupted run
+func (e *Engine) streamSFTP(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult, session *sftpSession, sourceLoc *transport.RemoteLocation, part string, size int64) error {
+	var checkpoints *checkpoint.Manager
+	var state *core.CheckpointState
+	var offset int64
+	if opts.Checkpoint {
+		var err error
+		if checkpoints, err = e.checkpointManager(); err != nil {
+			return err
+		}
+		state, offset, err = e.resume(ctx, checkpoints, opts, session.hashers(), sourceLoc, part, sftpSize(session.dest, part), size)
+		if err != nil {
+			return err
+		}
+		_ = checkpoints.Save(state.TransferID, state)
+		if offset > 0 && e.progress != nil {
+			e.progress.Start(size, fmt.Sprintf("Resuming at %d of %d bytes", offset, size))
+		}
+	}
+
+	sourceFile, err := session.source.Open(transport.SFTPPath(sourceLoc.Path))
+	if err != nil {
+		return &SFTPError{Host: "source", Op: "open", Path: sourceLoc.Path, Err: err}
+	}
+	defer sourceFile.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	destFile, err := session.dest.OpenFile(transport.SFTPPath(part), flags)
+	if err != nil {
+		return &SFTPError{Host: "destination", Op: "open", Path: part, Err: err}
+	}
+	defer destFile.Close()
+
+	if offset > 0 {
+		if _, err := sourceFile.Seek(offset, io.SeekStart); err != nil {
+			return &SFTPError{Host: "source", Op: "seek", Path: sourceLoc.Path, Err: err}
+		}
+		if _, err := destFile.Seek(offset, io.SeekStart); err != nil {
+			return &SFTPError{Host: "destination", Op: "seek", Path: part, Err: err}
+		}
+	}
+
+	// Hash the file as it passes, a resumed copy is hashed on both hosts
+	var digest *streamDigest
+	var source io.Reader = bufio.NewReaderSize(sourceFile, sftpBufferSize)
+	if opts.Verify && offset == 0 {
+		digest = newStreamDigest(codec.Codec{Name: core.CompressionNone})
+		source = io.TeeReader(source, digest)
+	}
+	dest := bufio.NewWriterSize(destFile, sftpBufferSize)
+
+	pipeline := stream.New(source, dest, &stream.Config{
+		BufferSize: 1024 * 1024, // 1MB buffer
+		ProgressFunc: func(bytesTransferred int64, speed float64) {
+			if e.progress != nil {
+				e.progress.Update(offset+bytesTransferred, fmt.Sprintf("%.1f MB/s", speed/(1024*1024)))
+			}
+			if state != nil {
+				state.BytesDone = offset + bytesTransferred
+				_ = checkpoints.Save(state.TransferID, state)
+			}
+		},
+	})
+	err = pipeline.Start(ctx)
+	if err == nil {
+		err = dest.Flush()
+	}
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if digest != nil {
+			digest.Sum()
+		}
+		if state != nil {
+			_ = checkpoints.Save(state.TransferID, state)
+		}
+		return fmt.Errorf("pipeline error: %w", err)
+	}
+	if state != nil {
+		_ = checkpoints.Delete(state.TransferID)
+	}
+
+	result.BytesDone = offset + pipeline.Stats().BytesWritten
+	if result.BytesDone != size {
+		return fmt.Errorf("copied %d of %d bytes, the source changed", result.BytesDone, size)
+	}
+
+	if opts.Verify {
+		if result.Checksum, err = e.verifyFile(ctx, session.hashers(), sourceLoc.Path, part, digest); err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+	}
+	return nil
+}
+
+// replaceFile renames a file over another in one step where the server
+// supports it, else removes the target first
+func replaceFile(client *sftp.Client, from, to string) error {
+	if err := client.PosixRename(from, to); err == nil {
+		return nil
+	}
+	if err := client.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return client.Rename(from, to)
+}
+
+// sftpRanges copies ranges by reading and writing at offsets over SFTP
+type sftpRanges struct {
+	e                    *Engine
+	sourceLoc, destLoc   *transport.RemoteLocation
+	sourceAuth, destAuth transport.AuthMethod
+	dest                 *sftp.Client
+	destPath             string
+}
+
+func (s *sftpRanges) partialSize(ctx context.Context) (int64, error) {
+	return sftpSize(s.dest, s.destPath)(ctx)
+}
+
+func (s *sftpRanges) create(ctx context.Context) error {
+	file, err := s.dest.Create(transport.SFTPPath(s.destPath))
+	if err != nil {
+		return &SFTPError{Host: "destination", Op: "create", Path: s.destPath, Err: err}
+	}
+	return file.Close()
+}
+
+func (s *sftpRanges) open(ctx context.Context) (rangeSender, error) {
+	sender := &sftpSender{e: s.e}
+	ok := false
+	defer func() {
+		if !ok {
+			sender.close()
+		}
+	}()
+
+	var err error
+	if sender.sourceClient, err = s.e.transport.Connect(ctx, s.sourceLoc.SSHConfig(s.sourceAuth).WithExclusive()); err != nil {
+		return nil, fmt.Errorf("connect to source: %w", err)
+	}
+	if sender.destClient, err = s.e.transport.Connect(ctx, s.destLoc.SSHConfig(s.destAuth).WithExclusive()); err != nil {
+		return nil, fmt.Errorf("connect to destination: %w", err)
+	}
+	if sender.source, err = s.e.transport.OpenSFTP(ctx, sender.sourceClient); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	if sender.dest, err = s.e.transport.OpenSFTP(ctx, sender.destClient); err != nil {
+		return nil, fmt.Errorf("destination: %w", err)
+	}
+	if sender.sourceFile, err = sender.source.Open(transport.SFTPPath(s.sourceLoc.Path)); err != nil {
+		return nil, &SFTPError{Host: "source", Op: "open", Path: s.sourceLoc.Path, Err: err}
+	}
+	if sender.destFile, err = sender.dest.OpenFile(transport.SFTPPath(s.destPath), os.O_WRONLY); err != nil {
+		return nil, &SFTPError{Host: "destination", Op: "open", Path: s.destPath, Err: err}
+	}
+	ok = true
+	return sender, nil
+}
+
+// sftpSender copies ranges over one worker's connections and files
+type sftpSender struct {
+	e                        *Engine
+	sourceClient, destClient *transport.SSHClient
+	source, dest             *sftp.Client
+	sourceFile, destFile     *sftp.File
+}
+
+// send copies one range from the source file to the same offset in the
+// destination file
+func (s *sftpSender) send(ctx context.Context, r core.ByteRange, progress func(int64)) error {
+	source := bufio.NewReaderSize(io.NewSectionReader(s.sourceFile, r.Offset, r.Length), sftpBufferSize)
+	dest := bufio.NewWriterSize(io.NewOffsetWriter(s.destFile, r.Offset), sftpBufferSize)
+
+	pipeline := stream.New(source, dest, &stream.Config{
+		BufferSize: 1024 * 1024, // 1MB buffer
+		ProgressFunc: func(bytesTransferred int64, speed float64) {
+			progress(bytesTransferred)
+		},
+	})
+	if err := pipeline.Start(ctx); err != nil {
+		return err
+	}
+	if err := dest.Flush(); err != nil {
+		return err
+	}
+	if n := pipeline.Stats().BytesWritten; n != r.Length {
+		return fmt.Errorf("copied %d of %d bytes, the source changed", n, r.Length)
+	}
+	return nil
+}
+
+func (s *sftpSender) close() {
+	for _, file := range []*sftp.File{s.sourceFile, s.destFile} {
+		if file != nil {
+			file.Close()
+		}
+	}
+	for _, client := range []*sftp.Client{s.source, s.dest} {
+		if client != nil {
+			client.Close()
+		}
+	}
+	for _, client := range []*transport.SSHClient{s.sourceClient, s.destClient} {
+		if client != nil {
+			s.e.transport.Close(client)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+func TestPartPath(t *testing.T) {
+	tests := map[string]string{
+		"/data/a b.bin":  "/data/.a b.bin.difpipe-part",
+		"~/backup.tar":   "~/.backup.tar.difpipe-part",
+		"relative.bin":   ".relative.bin.difpipe-part",
+		"/data/sub/file": "/data/sub/.file.difpipe-part",
+	}
+	for dest, want := range tests {
+		if got := partPath(dest); got != want {
+			t.Errorf("partPath(%q) = %q, want %q", dest, got, want)
+		}
+	}
+
+	// The shell and SFTP name the same file
+	if got := transport.SFTPPath(partPath("~/backup.tar")); got != ".backup.tar.difpipe-part" {
+		t.Errorf("SFTP part path = %q", got)
+	}
+}
+
+func TestSFTPError_ExitCode(t *testing.T) {
+	tests := []struct {
+		host string
+		err  error
+		want int
+	}{
+		{"source", fs.ErrNotExist, core.ExitSourceNotFound},
+		{"destination", fs.ErrNotExist, core.ExitDestNotWritable},
+		{"destination", fs.ErrPermission, core.ExitPermissionDenied},
+		{"source", fmt.Errorf("connection lost"), core.ExitTransferFailed},
+	}
+	for _, tt := range tests {
+		err := &SFTPError{Host: tt.host, Op: "open", Path: "/data/a", Err: tt.err}
+		if got := err.ExitCode(); got != tt.want {
+			t.Errorf("%s %v: exit code %d, want %d", tt.host, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/sftp"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// streamDigest computes the SHA-256 of a file while its stream is relayed,
+// decompressing the stream in-process when it is compressed
+type streamDigest struct {
+	pipe *io.PipeWriter
+	done chan struct{}
+	sum  string
+	err  error
+}
+
+// newStreamDigest starts hashing whatever is written to the digest
+func newStreamDigest(c codec.Codec) *streamDigest {
+	pipeReader, pipeWriter := io.Pipe()
+	d := &streamDigest{pipe: pipeWriter, done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		// Keep reading so the relay never blocks on a failed hash
+		defer io.Copy(io.Discard, pipeReader)
+
+		reader, err := c.NewReader(pipeReader)
+		if err != nil {
+			d.err = fmt.Errorf("start %s: %w", c.Name, err)
+			return
+		}
+		defer reader.Close()
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, reader); err != nil {
+			d.err = fmt.Errorf("hash stream: %w", err)
+			return
+		}
+		d.sum = hex.EncodeToString(hash.Sum(nil))
+	}()
+	return d
+}
+
+func (d *streamDigest) Write(p []byte) (int, error) {
+	return d.pipe.Write(p)
+}
+
+// Sum ends the stream and returns its hex digest
+func (d *streamDigest) Sum() (string, error) {
+	d.pipe.Close()
+	<-d.done
+	return d.sum, d.err
+}
+
+// fileHasher returns the hex SHA-256 of a file on one host, or of its
+// first n bytes when n is not negative
+type fileHasher func(ctx context.Context, path string, n int64) (string, error)
+
+// hashers hash files on the source and destination hosts
+type hashers struct {
+	source, dest fileHasher
+}
+
+// shellHashers hash files with sha256sum on the hosts, for copies made
+// without SFTP
+func (e *Engine) shellHashers(sourceClient, destClient *transport.SSHClient) hashers {
+	shellHasher := func(client *transport.SSHClient) fileHasher {
+		return func(ctx context.Context, path string, n int64) (string, error) {
+			cmd := "cat " + transport.QuoteRemotePath(path)
+			if n >= 0 {
+				cmd = fmt.Sprintf("head -c %d %s", n, transport.QuoteRemotePath(path))
+			}
+			return e.remoteHash(ctx, client, cmd)
+		}
+	}
+	return hashers{source: shellHasher(sourceClient), dest: shellHasher(destClient)}
+}
+
+// sftpHasher hashes a file as it is read over SFTP
+func sftpHasher(client *sftp.Client, host string) fileHasher {
+	return func(ctx context.Context, remotePath string, n int64) (string, error) {
+		file, err := client.Open(transport.SFTPPath(remotePath))
+		if err != nil {
+			return "", &SFTPError{Host: host, Op: "open", Path: remotePath, Err: err}
+		}
+		defer file.Close()
+		stop := context.AfterFunc(ctx, func() { file.Close() })
+		defer stop()
+
+		var reader io.Reader = bufio.NewReaderSize(file, sftpBufferSize)
+		if n >= 0 {
+			reader = io.LimitReader(reader, n)
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, reader); err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", &SFTPError{Host: host, Op: "read", Path: remotePath, Err: err}
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+}
+
+// hashers hash files over the session's SFTP clients
+func (s *sftpSession) hashers() hashers {
+	return hashers{source: sftpHasher(s.source, "source"), dest: sftpHasher(s.dest, "destination")}
+}
+
+// verifyFile compares the destination's copy with the source and returns
+// its digest. A fresh copy is checked against the digest of the relayed
+// stream; a resumed one was only partly relayed, so both hosts hash their
+// whole file.
+func (e *Engine) verifyFile(ctx context.Context, h hashers, sourcePath, destPath string, digest *streamDigest) (string, error) {
+	var sourceSum, destSum string
+	var err error
+	if digest != nil {
+		if sourceSum, err = digest.Sum(); err != nil {
+			return "", err
+		}
+		if destSum, err = h.dest(ctx, destPath, -1); err != nil {
+			return "", fmt.Errorf("destination: %w", err)
+		}
+	} else {
+		sourceSum, destSum, err = h.both(ctx, sourcePath, destPath, -1)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if sourceSum != destSum {
+		return "", &ChecksumError{Path: destPath, Source: sourceSum, Destination: destSum}
+	}
+	return sourceSum, nil
+}
+
+// both hashes the files on both hosts at once
+func (h hashers) both(ctx context.Context, sourcePath, destPath string, n int64) (string, string, error) {
+	type hashResult struct {
+		sum string
+		err error
+	}
+	sourceHash := make(chan hashResult, 1)
+	go func() {
+		sum, err := h.source(ctx, sourcePath, n)
+		sourceHash <- hashResult{sum, err}
+	}()
+
+	destSum, err := h.dest(ctx, destPath, n)
+	source := <-sourceHash
+	if source.err != nil {
+		return "", "", fmt.Errorf("source: %w", source.err)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("destination: %w", err)
+	}
+	return source.sum, destSum, nil
+}
+
+// remoteHash returns the hex SHA-256 of the output of cmd on the host.
+// A pipeline exits with the hasher's status, which is content with empty
+// input, so cmd's status is passed out on another descriptor and becomes
+// the exit status; pipefail isn't available in every sh.
+func (e *Engine) remoteHash(ctx context.Context, client *transport.SSHClient, cmd string) (string, error) {
+	hashCmd := fmt.Sprintf(`{ status=$({ { %s; echo $? >&3; } | (sha256sum 2>/dev/null || shasum -a 256) >&4; } 3>&1); } 4>&1; exit "$status"`, cmd)
+	result, err := e.transport.ExecuteCommand(ctx, client, hashCmd)
+	if err != nil {
+		return "", fmt.Errorf("execute hash command: %w", err)
+	}
+	fields := strings.Fields(string(result.Stdout))
+	if result.ExitCode != 0 || len(fields) == 0 {
+		return "", fmt.Errorf("hash failed: %s", strings.TrimSpace(string(result.Stderr)))
+	}
+	return fields[0], nil
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+func TestStreamDigest_HashesDecompressedFile(t *testing.T) {
+	data := bytes.Repeat([]byte("difpipe "), 10000)
+	want := sha256.Sum256(data)
+
+	for _, name := range []core.Compression{core.CompressionNone, core.CompressionZstd, core.CompressionLz4} {
+		t.Run(string(name), func(t *testing.T) {
+			c, err := codec.New(name, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var compressed bytes.Buffer
+			w, err := c.NewWriter(&compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+			w.Close()
+
+			digest := newStreamDigest(c)
+			if _, err := digest.Write(compressed.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			sum, err := digest.Sum()
+			if err != nil {
+				t.Fatalf("sum: %v", err)
+			}
+			if sum != hex.EncodeToString(want[:]) {
+				t.Errorf("sum = %s, want %x", sum, want)
+			}
+		})
+	}
+}
+
+func TestChecksumError_ExitCode(t *testing.T) {
+	err := fmt.Errorf("verify: %w", &ChecksumError{Path: "/data/f", Source: "aa", Destination: "bb"})
+
+	var coded interface{ ExitCode() int }
+	if !errors.As(err, &coded) || coded.ExitCode() != core.ExitChecksumMismatch {
+		t.Errorf("exit code not %d", core.ExitChecksumMismatch)
+	}
+}
+
+// localSFTP returns an SFTP client served from the local filesystem
+func localSFTP(t *testing.T) *sftp.Client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSFTPHasher(t *testing.T) {
+	data := bytes.Repeat([]byte("difpipe "), 300000)
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash := sftpHasher(localSFTP(t), "source")
+
+	for _, n := range []int64{-1, 0, 1000, int64(len(data))} {
+		want := data
+		if n >= 0 {
+			want = data[:n]
+		}
+		wantSum := sha256.Sum256(want)
+		sum, err := hash(context.Background(), path, n)
+		if err != nil {
+			t.Fatalf("n = %d: %v", n, err)
+		}
+		if sum != hex.EncodeToString(wantSum[:]) {
+			t.Errorf("n = %d: sum = %s, want %x", n, sum, wantSum)
+		}
+	}
+
+	_, err := hash(context.Background(), path+".missing", -1)
+	var sftpErr *SFTPError
+	if !errors.As(err, &sftpErr) || sftpErr.ExitCode() != core.ExitSourceNotFound {
+		t.Errorf("missing file: err = %v, want source not found", err)
+	}
+}
+
+// localShell runs commands with the local sh instead of over SSH
+type localShell struct {
+	transport.Transport
+}
+
+func (localShell) ExecuteCommand(ctx context.Context, client *transport.SSHClient, cmd string) (*transport.CommandResult, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.CommandContext(ctx, "sh", "-c", cmd)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	result := &transport.CommandResult{}
+	if err := command.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
+	return result, nil
+}
+
+func TestShellHasher(t *testing.T) {
+	data := bytes.Repeat([]byte("difpipe "), 300000)
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{transport: localShell{}}
+	hash := e.shellHashers(nil, nil).source
+
+	for _, n := range []int64{-1, 0, 1000, int64(len(data))} {
+		want := data
+		if n >= 0 {
+			want = data[:n]
+		}
+		wantSum := sha256.Sum256(want)
+		sum, err := hash(context.Background(), path, n)
+		if err != nil {
+			t.Fatalf("n = %d: %v", n, err)
+		}
+		if sum != hex.EncodeToString(wantSum[:]) {
+			t.Errorf("n = %d: sum = %s, want %x", n, sum, wantSum)
+		}
+	}
+
+	// The hasher succeeds on the empty input, the missing file must not
+	for _, n := range []int64{-1, 1000} {
+		if sum, err := hash(context.Background(), path+".missing", n); err == nil {
+			t.Errorf("n = %d: missing file hashed to %s", n, sum)
+		}
+	}
+}
+
+func TestPrefixMatches(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	partial := filepath.Join(dir, "partial")
+	os.WriteFile(source, []byte("hello world"), 0644)
+	os.WriteFile(partial, []byte("hello"), 0644)
+	client := localSFTP(t)
+	h := hashers{source: sftpHasher(client, "source"), dest: sftpHasher(client, "destination")}
+
+	if ok, err := prefixMatches(context.Background(), h, source, partial, 5); err != nil || !ok {
+		t.Errorf("matching prefix = %v, %v", ok, err)
+	}
+	os.WriteFile(partial, []byte("jello"), 0644)
+	if ok, err := prefixMatches(context.Background(), h, source, partial, 5); err != nil || ok {
+		t.Errorf("differing prefix = %v, %v", ok, err)
+	}
+}
@@ -59,6 +59,20 @@ func (e *Engine) SupportsProtocol(protocol string) bool {
 	return supported[strings.ToLower(protocol)]
 }
 
+// SupportsTransfer checks if rclone can transfer between two protocols
+func (e *Engine) SupportsTransfer(source, dest core.Protocol) bool {
+	return e.SupportsProtocol(string(source)) && e.SupportsProtocol(string(dest))
+}
+
+// Capabilities describes rclone for the given options
+func (e *Engine) Capabilities(opts *core.TransferOptions) core.EngineCapabilities {
+	return core.EngineCapabilities{
+		Resume:           true, // Sync skips files already at the destination
+		RequiredBinaries: []string{e.binPath},
+		Compression:      core.CompressionNone,
+	}
+}
+
 // Transfer performs the transfer using rclone
 func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*core.TransferResult, error) {
 	startTime := time.Now()
@@ -288,10 +302,12 @@ func (e *Engine) convertToSFTP(path string, auth *core.AuthOptions, isSource boo
 
 	// Try environment variables as fallback
 	if password == "" {
+		env := auth.DestPasswordEnv()
 		if isSource {
-			password = os.Getenv("DIFPIPE_SOURCE_PASSWORD")
-		} else {
-			password = os.Getenv("DIFPIPE_DEST_PASSWORD")
+			env = auth.SourcePasswordEnv()
+		}
+		if env != "" {
+			password = os.Getenv(env)
 		}
 	}
 
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/larrydiffey/difpipe/pkg/benchmark"
 	"github.com/larrydiffey/difpipe/pkg/core"
 )
 
@@ -53,6 +54,31 @@ func (e *Engine) SupportsProtocol(protocol string) bool {
 	return supported[strings.ToLower(protocol)]
 }
 
+// SupportsTransfer checks if rsync can transfer between two protocols.
+// Rsync cannot copy between two remote hosts.
+func (e *Engine) SupportsTransfer(source, dest core.Protocol) bool {
+	if source == core.ProtocolSSH && dest == core.ProtocolSSH {
+		return false
+	}
+	return e.SupportsProtocol(string(source)) && e.SupportsProtocol(string(dest))
+}
+
+// Capabilities describes rsync for the given options
+func (e *Engine) Capabilities(opts *core.TransferOptions) core.EngineCapabilities {
+	caps := core.EngineCapabilities{
+		Resume:           true, // Completed files are skipped on rerun
+		RequiredBinaries: []string{e.binPath},
+		Compression:      core.CompressionNone,
+	}
+	if opts.Compression != core.CompressionNone && opts.Compression != core.CompressionAuto {
+		caps.Compression = opts.Compression
+	}
+	if strings.Contains(opts.Source, ":") || strings.Contains(opts.Destination, ":") {
+		caps.RequiredBinaries = append(caps.RequiredBinaries, "ssh", "rsync (remote)")
+	}
+	return caps
+}
+
 // Transfer performs the transfer using rsync
 func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*core.TransferResult, error) {
 	startTime := time.Now()
@@ -161,9 +187,22 @@ func (e *Engine) Estimate(ctx context.Context, opts *core.TransferOptions) (*cor
 	// Parse stats output
 	e.parseStats(string(output), estimate)
 
+	// Estimate time from benchmarks, or typical rsync speed (50 MB/s)
+	if estimate.BytesTotal > 0 {
+		timing := benchmark.EstimateTransfer(opts.Source, opts.Destination, estimate.BytesTotal, estimate.FilesTotal, 50*1024*1024)
+		estimate.EstimatedTime = timing.Duration
+		estimate.EstimatedSpeed = timing.Speed
+	}
+
 	return estimate, nil
 }
 
+// compressChoices maps codecs to rsync's --compress-choice names
+var compressChoices = map[core.Compression]string{
+	core.CompressionZstd: "zstd",
+	core.CompressionLz4:  "lz4",
+}
+
 // buildCommand constructs the rsync command arguments
 func (e *Engine) buildCommand(opts *core.TransferOptions) []string {
 	args := []string{
@@ -172,9 +211,16 @@ func (e *Engine) buildCommand(opts *core.TransferOptions) []string {
 		"--progress", // Show progress
 	}
 
-	// Add compression if requested
+	// Add compression if requested; zstd and lz4 need rsync 3.2 on both
+	// ends, gzip is rsync's default zlib
 	if opts.Compression != core.CompressionNone && opts.Compression != core.CompressionAuto {
 		args = append(args, "-z")
+		if choice, ok := compressChoices[opts.Compression]; ok {
+			args = append(args, "--compress-choice="+choice)
+		}
+		if opts.CompressionLevel != 0 {
+			args = append(args, fmt.Sprintf("--compress-level=%d", opts.CompressionLevel))
+		}
 	}
 
 	// Add checkpoint/partial support
@@ -291,12 +337,6 @@ func (e *Engine) parseStats(output string, estimate *core.TransferEstimate) {
 		}
 	}
 
-	// Estimate time based on typical rsync speed (50 MB/s)
-	if estimate.BytesTotal > 0 {
-		estimatedSeconds := float64(estimate.BytesTotal) / (50 * 1024 * 1024)
-		estimate.EstimatedTime = time.Duration(estimatedSeconds * float64(time.Second))
-		estimate.EstimatedSpeed = "~50 MB/s"
-	}
 }
 
 // generateTransferID creates a unique transfer ID
@@ -1,30 +1,34 @@
 package tarstream
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/larrydiffey/difpipe/pkg/benchmark"
+	"github.com/larrydiffey/difpipe/pkg/codec"
 	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
 )
 
 // Engine implements the TransferEngine interface using tar streaming
 // This is optimal for many small files where tar's single-stream approach
 // is more efficient than transferring files individually
 type Engine struct {
-	progress core.ProgressReporter
+	transport transport.Transport
+	progress  core.ProgressReporter
 }
 
 // New creates a new tar streaming engine
 func New() *Engine {
-	return &Engine{}
+	return &Engine{
+		transport: transport.Shared(),
+	}
 }
 
 // WithProgress sets a progress reporter
@@ -48,6 +52,34 @@ func (e *Engine) SupportsProtocol(protocol string) bool {
 	return supported[strings.ToLower(protocol)]
 }
 
+// SupportsTransfer checks if tar streaming can transfer between two
+// protocols. At least one end must be local.
+func (e *Engine) SupportsTransfer(source, dest core.Protocol) bool {
+	if source == core.ProtocolSSH {
+		return dest == core.ProtocolLocal
+	}
+	return source == core.ProtocolLocal && (dest == core.ProtocolLocal || dest == core.ProtocolSSH)
+}
+
+// Capabilities describes tar streaming for the given options. Remote
+// transfers use the preferred codec the remote host is expected to have.
+func (e *Engine) Capabilities(opts *core.TransferOptions) core.EngineCapabilities {
+	caps := core.EngineCapabilities{
+		Compression: core.CompressionNone,
+	}
+	if !isLocalPath(opts.Source) || !isLocalPath(opts.Destination) {
+		caps.RequiredBinaries = []string{"tar (remote)"}
+		if requested, err := codec.New(opts.Compression, opts.CompressionLevel); err == nil {
+			preferred := codec.Preferred(requested)
+			caps.Compression = preferred.Name
+			if preferred.Enabled() {
+				caps.RequiredBinaries = append(caps.RequiredBinaries, preferred.Binary()+" (remote)")
+			}
+		}
+	}
+	return caps
+}
+
 // Transfer performs the transfer using tar streaming
 func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*core.TransferResult, error) {
 	startTime := time.Now()
@@ -67,13 +99,33 @@ func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*cor
 		return result, nil
 	}
 
-	// Check if source is local
-	if !isLocalPath(opts.Source) {
-		return nil, fmt.Errorf("tar streaming requires local source path")
+	// Remote sources are pulled into a local destination
+	if !isLocalPath(opts.Source) && (opts.ArchiveTo != "" || !isLocalPath(opts.Destination)) {
+		return nil, fmt.Errorf("tar streaming from a remote source requires a local destination directory")
 	}
 
-	// Determine if destination is local or remote
-	if isLocalPath(opts.Destination) {
+	// Determine if this is a pull, or the destination an archive, local or remote
+	if !isLocalPath(opts.Source) {
+		err := e.transferPull(ctx, opts, result)
+		if err != nil {
+			result.Success = false
+			result.Error = err
+			if e.progress != nil {
+				e.progress.Error(err)
+			}
+			return result, err
+		}
+	} else if opts.ArchiveTo != "" {
+		err := e.writeArchive(ctx, opts, result)
+		if err != nil {
+			result.Success = false
+			result.Error = err
+			if e.progress != nil {
+				e.progress.Error(err)
+			}
+			return result, err
+		}
+	} else if isLocalPath(opts.Destination) {
 		err := e.transferLocal(ctx, opts, result)
 		if err != nil {
 			result.Success = false
@@ -98,6 +150,13 @@ func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*cor
 	result.Duration = time.Since(startTime)
 	result.Success = true
 	result.Message = "Transfer completed successfully"
+	if opts.ArchiveTo != "" {
+		result.Message = fmt.Sprintf("Archive written to %s", opts.ArchiveTo)
+	}
+	if len(result.ChangedFiles) > 0 {
+		result.Message = fmt.Sprintf("Transfer completed, %d files kept changing while being read and may be inconsistent: %s",
+			len(result.ChangedFiles), strings.Join(result.ChangedFiles, ", "))
+	}
 
 	// Calculate average speed
 	if result.Duration > 0 && result.BytesDone > 0 {
@@ -112,57 +171,155 @@ func (e *Engine) Transfer(ctx context.Context, opts *core.TransferOptions) (*cor
 	return result, nil
 }
 
-// transferLocal performs local to local tar transfer
-func (e *Engine) transferLocal(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult) error {
-	// Create destination directory if needed
-	if err := os.MkdirAll(opts.Destination, 0755); err != nil {
-		return fmt.Errorf("create destination: %w", err)
+// transferRemote streams the archive over SSH into tar on the destination
+// host, compressing in-stream with a codec the host supports
+func (e *Engine) transferRemote(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult) error {
+	destLoc, err := transport.ParseRemotePath(opts.Destination)
+	if err != nil {
+		return fmt.Errorf("parse destination: %w", err)
 	}
 
-	// Create tar file
-	tarPath := filepath.Join(opts.Destination, "transfer.tar")
-	if opts.Compression == core.CompressionGzip || opts.Compression == core.CompressionAuto {
-		tarPath += ".gz"
+	var authConfig map[string]interface{}
+	var jumps []core.JumpHost
+	if opts.Auth != nil {
+		authConfig = opts.Auth.DestAuth
+		jumps = opts.Auth.DestJumps
+	}
+	auth, err := transport.ResolveAuth(authConfig, opts.Auth.DestPasswordEnv())
+	if err != nil {
+		return fmt.Errorf("dest auth: %w", err)
 	}
+	if err = destLoc.ResolveJumps(jumps); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+
+	client, err := e.transport.Connect(ctx, destLoc.SSHConfig(auth))
+	if err != nil {
+		return fmt.Errorf("connect to destination: %w", err)
+	}
+	defer e.transport.Close(client)
 
-	outFile, err := os.Create(tarPath)
+	// Compress with the best codec the destination can decompress
+	requested, err := codec.New(opts.Compression, opts.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	available, err := codec.Probe(ctx, e.transport, client)
 	if err != nil {
-		return fmt.Errorf("create tar file: %w", err)
+		return err
 	}
-	defer outFile.Close()
+	c := codec.Negotiate(requested, available)
+	result.Compression = c.Name
 
-	// Create tar writer with optional compression
-	var writer io.Writer = outFile
-	var gzipWriter *gzip.Writer
-	if opts.Compression == core.CompressionGzip || opts.Compression == core.CompressionAuto {
-		gzipWriter = gzip.NewWriter(outFile)
-		writer = gzipWriter
-		defer gzipWriter.Close()
+	// Several streams over their own connections fill high-latency links
+	// better than one
+	if opts.Parallel > 1 {
+		return e.transferSharded(ctx, opts, result, destLoc, auth, client, c)
 	}
 
-	tarWriter := tar.NewWriter(writer)
-	defer tarWriter.Close()
+	if e.progress != nil {
+		total, files := sourceSize(opts.Source, opts.Filters)
+		e.progress.Start(total, fmt.Sprintf("Streaming %d files to %s", files, destLoc.Host))
+	}
+
+	preserve := core.PreserveOrDefault(opts.Preserve)
+	return e.streamArchive(ctx, client, destLoc, c, preserve, func(aw *archiveWriter) error {
+		return e.walkAndTar(ctx, opts.Source, aw, result, opts.Filters)
+	})
+}
+
+// streamArchive pipes the archive produced by write into tar on the
+// destination host
+func (e *Engine) streamArchive(ctx context.Context, client *transport.SSHClient, destLoc *transport.RemoteLocation, c codec.Codec, preserve core.PreserveOptions, write func(*archiveWriter) error) error {
+	dest := transport.QuoteRemotePath(destLoc.Path)
+	extract := strings.Join(append([]string{"tar", "-xf", "-", "-C", dest}, remoteTarFlags(preserve)...), " ")
+	if c.Enabled() {
+		extract = c.DecompressCommand() + " | " + extract
+	}
+	remoteCmd := fmt.Sprintf("mkdir -p %s && %s", dest, extract)
 
-	// Walk source directory and add files to tar
-	err = e.walkAndTar(ctx, opts.Source, tarWriter, result, opts.Filters)
+	stream, err := e.transport.StreamWrite(ctx, client, remoteCmd)
 	if err != nil {
-		return fmt.Errorf("tar creation: %w", err)
+		return fmt.Errorf("start remote tar: %w", err)
+	}
+
+	compressor, err := c.NewWriter(stream)
+	if err != nil {
+		stream.Close()
+		return fmt.Errorf("start %s: %w", c.Name, err)
+	}
+	aw := newArchiveWriter(compressor, preserve)
+
+	// Finish the archive even on failure so the remote tar exits; its exit
+	// status and stderr explain most write errors
+	walkErr := write(aw)
+	if walkErr == nil {
+		walkErr = aw.Close()
+	}
+	if walkErr == nil {
+		walkErr = compressor.Close()
+	}
+	closeErr := stream.Close()
+
+	// The remote tar fails too when the archive is cut short, but the cause
+	// is here
+	if walkErr != nil && localFailure(walkErr) {
+		return fmt.Errorf("tar stream: %w", walkErr)
+	}
+	if closeErr != nil {
+		var cmdErr *transport.CommandError
+		if errors.As(closeErr, &cmdErr) {
+			return &RemoteTarError{Host: destLoc.Host, Status: cmdErr.Status, Stderr: cmdErr.Stderr}
+		}
+		if walkErr == nil {
+			return fmt.Errorf("close remote tar: %w", closeErr)
+		}
+	}
+	if walkErr != nil {
+		return fmt.Errorf("tar stream: %w", walkErr)
 	}
 
 	return nil
 }
 
-// transferRemote performs local to remote tar transfer via SSH
-func (e *Engine) transferRemote(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult) error {
-	// For now, this is a simplified implementation
-	// A full implementation would use SSH to pipe tar directly
-	// For v0.2.0, we'll create the tar locally and use rsync/scp to transfer
-	return fmt.Errorf("remote tar streaming not yet implemented - use local destination")
+// localFailure reports whether an archive could not be written for reasons
+// on this host: cancellation or an error reading the source
+func localFailure(err error) bool {
+	var pathErr *fs.PathError
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &pathErr)
 }
 
-// walkAndTar walks the source directory and adds files to tar archive
-func (e *Engine) walkAndTar(ctx context.Context, source string, tarWriter *tar.Writer, result *core.TransferResult, filters *core.FilterOptions) error {
-	return filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+// sourceSize adds up the files a transfer will read
+func sourceSize(source string, filters *core.FilterOptions) (int64, int64) {
+	var total, files int64
+	filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil // Skip errors
+		}
+		if rel, err := filepath.Rel(source, path); err == nil && filters != nil && !matchesFilters(rel, filters) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+			files++
+		}
+		return nil
+	})
+	return total, files
+}
+
+// maxChangeRetries is how often a file that changed while being read is
+// added again at the end of the archive, later entries replace earlier ones
+// on extraction
+const maxChangeRetries = 2
+
+// walkAndTar walks the source directory and adds files to tar archive.
+// Files that change while being read are added again once the walk is
+// done; those still changing are listed in result.ChangedFiles.
+func (e *Engine) walkAndTar(ctx context.Context, source string, aw *archiveWriter, result *core.TransferResult, filters *core.FilterOptions) error {
+	var changed []string
+
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
 		// Check for cancellation
 		select {
 		case <-ctx.Done():
@@ -201,45 +358,105 @@ func (e *Engine) walkAndTar(ctx context.Context, source string, tarWriter *tar.W
 			return err
 		}
 
-		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
+		fileChanged, err := e.addEntry(aw, path, relPath, info, result)
 		if err != nil {
 			return err
 		}
+		if fileChanged {
+			changed = append(changed, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		// Use relative path in archive
-		header.Name = relPath
+	result.ChangedFiles, err = e.retryChanged(ctx, source, changed, aw, result)
+	return err
+}
 
-		// Write header
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
+// addEntry adds one file, directory or link to the archive and reports
+// whether a regular file changed while being read
+func (e *Engine) addEntry(aw *archiveWriter, path, relPath string, info fs.FileInfo, result *core.TransferResult) (bool, error) {
+	// Directories and other non-regular entries have no contents
+	if !info.Mode().IsRegular() {
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(path); err != nil {
+				return false, err
+			}
 		}
+		header, err := aw.header(path, relPath, info, link)
+		if err != nil {
+			return false, err
+		}
+		if err := aw.WriteHeader(header); err != nil {
+			return false, err
+		}
+		result.FilesDone++
+		return false, nil
+	}
 
-		// If it's a file, write contents
-		if !d.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
+	// Further names of an archived file become links to it
+	linked, err := aw.addLink(relPath, info)
+	if err != nil {
+		return false, err
+	}
+	if linked {
+		result.FilesDone++
+		return false, nil
+	}
+
+	written, changed, err := aw.addFile(path, relPath, info)
+	if err != nil {
+		return false, err
+	}
+
+	result.BytesDone += written
+	result.FilesDone++
+
+	if e.progress != nil {
+		e.progress.Update(result.BytesDone, fmt.Sprintf("Adding: %s", relPath))
+	}
+
+	return changed, nil
+}
+
+// retryChanged adds files that changed mid-read again once everything else
+// is archived, returning those that kept changing
+func (e *Engine) retryChanged(ctx context.Context, source string, changed []string, aw *archiveWriter, result *core.TransferResult) ([]string, error) {
+	for attempt := 0; attempt < maxChangeRetries && len(changed) > 0; attempt++ {
+		var still []string
+		for _, relPath := range changed {
+			if err := ctx.Err(); err != nil {
+				return nil, err
 			}
-			defer file.Close()
 
-			written, err := io.Copy(tarWriter, file)
-			if err != nil {
-				return err
+			path := filepath.Join(source, relPath)
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				// Gone or replaced, the copy already archived stays
+				still = append(still, relPath)
+				continue
 			}
 
-			result.BytesDone += written
-			result.FilesDone++
+			_, fileChanged, err := aw.addFile(path, relPath, info)
+			if err != nil {
+				return nil, err
+			}
+			if fileChanged {
+				still = append(still, relPath)
+			}
 
+			// The file's bytes were counted when first archived
 			if e.progress != nil {
-				e.progress.Update(result.BytesDone, fmt.Sprintf("Adding: %s", relPath))
+				e.progress.Update(result.BytesDone, fmt.Sprintf("Retrying changed file: %s", relPath))
 			}
-		} else {
-			result.FilesDone++
 		}
-
-		return nil
-	})
+		changed = still
+	}
+	return changed, nil
 }
 
 // Estimate provides transfer estimation
@@ -276,11 +493,11 @@ func (e *Engine) Estimate(ctx context.Context, opts *core.TransferOptions) (*cor
 	estimate.BytesTotal = totalSize
 	estimate.FilesTotal = fileCount
 
-	// Estimate time (tar is fast for small files, ~100 MB/s)
+	// Estimate time from benchmarks (tar is fast for small files, ~100 MB/s)
 	if totalSize > 0 {
-		estimatedSeconds := float64(totalSize) / (100 * 1024 * 1024)
-		estimate.EstimatedTime = time.Duration(estimatedSeconds * float64(time.Second))
-		estimate.EstimatedSpeed = "~100 MB/s"
+		timing := benchmark.EstimateTransfer(opts.Source, opts.Destination, totalSize, fileCount, 100*1024*1024)
+		estimate.EstimatedTime = timing.Duration
+		estimate.EstimatedSpeed = timing.Speed
 	}
 
 	return estimate, nil
@@ -314,8 +531,9 @@ func matchesFilters(path string, filters *core.FilterOptions) bool {
 
 // isLocalPath checks if a path is local
 func isLocalPath(path string) bool {
-	// Simple check - if it contains @ or :// it's likely remote
-	return !strings.Contains(path, "@") && !strings.Contains(path, "://")
+	// Simple check - if it contains @ or :// it's likely remote, as are
+	// host:path paths for ssh config aliases
+	return !strings.Contains(path, "@") && !strings.Contains(path, "://") && !transport.IsRemotePath(path)
 }
 
 // generateTransferID creates a unique transfer ID
@@ -0,0 +1,42 @@
+package tarstream
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// RemoteTarError is returned when tar on the source or destination host
+// fails
+type RemoteTarError struct {
+	Host   string
+	Status int    // Exit status of the remote tar, -1 when killed
+	Stderr string // End of the remote tar's stderr
+}
+
+func (e *RemoteTarError) Error() string {
+	msg := fmt.Sprintf("remote tar on %s failed with status %d", e.Host, e.Status)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// ExitCode maps common remote tar failures to exit codes
+func (e *RemoteTarError) ExitCode() int {
+	stderr := strings.ToLower(e.Stderr)
+	switch {
+	case strings.Contains(stderr, "no space left"), strings.Contains(stderr, "disk quota exceeded"):
+		return core.ExitInsufficientSpace
+	case strings.Contains(stderr, "permission denied"):
+		return core.ExitPermissionDenied
+	case strings.Contains(stderr, "read-only file system"), strings.Contains(stderr, "cannot mkdir"),
+		strings.Contains(stderr, "cannot create directory"):
+		return core.ExitDestNotWritable
+	case strings.Contains(stderr, "no such file or directory"):
+		return core.ExitSourceNotFound
+	default:
+		return core.ExitTransferFailed
+	}
+}
@@ -0,0 +1,76 @@
+package tarstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+func TestRemoteTarError_ExitCode(t *testing.T) {
+	tests := []struct {
+		stderr   string
+		expected int
+	}{
+		{"tar: ./big.iso: Wrote only 512 of 10240 bytes: No space left on device", core.ExitInsufficientSpace},
+		{"tar: ./data: Cannot write: Disk quota exceeded", core.ExitInsufficientSpace},
+		{"tar: ./secret: Cannot open: Permission denied", core.ExitPermissionDenied},
+		{"tar: ./out: Cannot open: Read-only file system", core.ExitDestNotWritable},
+		{"mkdir: cannot create directory '/backup': File exists", core.ExitDestNotWritable},
+		{"tar: /srv/missing: Cannot open: No such file or directory", core.ExitSourceNotFound},
+		{"tar: Unexpected EOF in archive", core.ExitTransferFailed},
+		{"", core.ExitTransferFailed},
+	}
+
+	for _, tt := range tests {
+		err := &RemoteTarError{Host: "backup", Status: 2, Stderr: tt.stderr}
+		if got := err.ExitCode(); got != tt.expected {
+			t.Errorf("ExitCode(%q) = %d, want %d", tt.stderr, got, tt.expected)
+		}
+	}
+}
+
+// failingTransport runs remote tar that fails once its input is closed
+type failingTransport struct {
+	transport.Transport
+}
+
+func (failingTransport) StreamWrite(ctx context.Context, client *transport.SSHClient, cmd string) (io.WriteCloser, error) {
+	return failingStream{}, nil
+}
+
+type failingStream struct{}
+
+func (failingStream) Write(p []byte) (int, error) { return len(p), nil }
+
+func (failingStream) Close() error {
+	return &transport.CommandError{Command: "tar", Status: 2, Stderr: "tar: Unexpected EOF in archive"}
+}
+
+func TestStreamArchive_LocalFailureFirst(t *testing.T) {
+	e := &Engine{transport: failingTransport{}}
+	destLoc := &transport.RemoteLocation{Host: "backup", Path: "/srv"}
+	none := codec.Codec{Name: core.CompressionNone}
+	stream := func(writeErr error) error {
+		return e.streamArchive(context.Background(), nil, destLoc, none, core.DefaultPreserve(), func(*archiveWriter) error {
+			return writeErr
+		})
+	}
+
+	var remoteErr *RemoteTarError
+	if err := stream(nil); !errors.As(err, &remoteErr) {
+		t.Errorf("err = %v, want the remote tar failure", err)
+	}
+	if err := stream(context.Canceled); !errors.Is(err, context.Canceled) || errors.As(err, &remoteErr) {
+		t.Errorf("err = %v, want cancellation", err)
+	}
+	readErr := &fs.PathError{Op: "read", Path: "/data/a.bin", Err: errors.New("input/output error")}
+	if err := stream(readErr); !errors.Is(err, readErr) || errors.As(err, &remoteErr) {
+		t.Errorf("err = %v, want the read error", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package tarstream
+
+import "io/fs"
+
+// fileID identifies a file across its hard links
+type fileID struct {
+	dev, ino uint64
+}
+
+// linkedFileID reports no links, link counts are not available on this
+// platform
+func linkedFileID(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package tarstream
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file across its hard links
+type fileID struct {
+	dev, ino uint64
+}
+
+// linkedFileID returns the identity of a file with more than one link
+func linkedFileID(info fs.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,372 @@
+package tarstream
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// transferLocal copies the source tree into the destination directory by
+// piping the archive into an in-process extractor. Nothing crosses a
+// network, so the stream is never compressed.
+func (e *Engine) transferLocal(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult) error {
+	if err := os.MkdirAll(opts.Destination, 0755); err != nil {
+		return fmt.Errorf("create destination: %w", err)
+	}
+
+	if e.progress != nil {
+		total, files := sourceSize(opts.Source, opts.Filters)
+		e.progress.Start(total, fmt.Sprintf("Copying %d files to %s", files, opts.Destination))
+	}
+
+	preserve := core.PreserveOrDefault(opts.Preserve)
+	pipeReader, pipeWriter := io.Pipe()
+	walkDone := make(chan error, 1)
+	go func() {
+		aw := newArchiveWriter(pipeWriter, preserve)
+		err := e.walkAndTar(ctx, opts.Source, aw, result, opts.Filters)
+		if err == nil {
+			err = aw.Close()
+		}
+		pipeWriter.CloseWithError(err)
+		walkDone <- err
+	}()
+
+	// Unblock the walk if extraction stops early
+	x := &extractor{dest: opts.Destination, preserve: preserve}
+	extractErr := x.extract(ctx, tar.NewReader(pipeReader))
+	pipeReader.Close()
+	walkErr := <-walkDone
+
+	if walkErr != nil && !errors.Is(walkErr, io.ErrClosedPipe) {
+		return fmt.Errorf("tar creation: %w", walkErr)
+	}
+	if extractErr != nil {
+		return fmt.Errorf("extract: %w", extractErr)
+	}
+
+	return nil
+}
+
+// writeArchive writes the source tree to a single archive file, compressed
+// according to its extension (.gz, .tgz, .zst, .tzst, .lz4) or else the
+// requested codec
+func (e *Engine) writeArchive(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult) error {
+	if !isLocalPath(opts.ArchiveTo) {
+		return fmt.Errorf("archive path must be local: %s", opts.ArchiveTo)
+	}
+	c, err := archiveCodec(opts.ArchiveTo, opts.Compression, opts.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	result.Compression = c.Name
+
+	if err := os.MkdirAll(filepath.Dir(opts.ArchiveTo), 0755); err != nil {
+		return fmt.Errorf("create archive directory: %w", err)
+	}
+
+	if e.progress != nil {
+		total, files := sourceSize(opts.Source, opts.Filters)
+		e.progress.Start(total, fmt.Sprintf("Archiving %d files to %s", files, opts.ArchiveTo))
+	}
+
+	outFile, err := os.Create(opts.ArchiveTo)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+
+	compressor, err := c.NewWriter(outFile)
+	if err != nil {
+		outFile.Close()
+		os.Remove(opts.ArchiveTo)
+		return fmt.Errorf("start %s: %w", c.Name, err)
+	}
+	aw := newArchiveWriter(compressor, core.PreserveOrDefault(opts.Preserve))
+
+	err = e.walkAndTar(ctx, opts.Source, aw, result, opts.Filters)
+	if err == nil {
+		err = aw.Close()
+	}
+	if err == nil {
+		err = compressor.Close()
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(opts.ArchiveTo)
+		return fmt.Errorf("write archive: %w", err)
+	}
+
+	return nil
+}
+
+// archiveExtensions map archive file extensions to their codec
+var archiveExtensions = map[string]core.Compression{
+	".gz":   core.CompressionGzip,
+	".tgz":  core.CompressionGzip,
+	".zst":  core.CompressionZstd,
+	".tzst": core.CompressionZstd,
+	".lz4":  core.CompressionLz4,
+}
+
+// archiveCodec picks the codec for an archive file. The extension wins;
+// without one an explicit codec is used and auto means uncompressed.
+func archiveCodec(path string, compression core.Compression, level int) (codec.Codec, error) {
+	if name, ok := archiveExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		if compression != name && compression != core.CompressionAuto && compression != "" {
+			level = 0 // The level was meant for another codec
+		}
+		return codec.New(name, level)
+	}
+	if compression == core.CompressionAuto || compression == "" {
+		return codec.Codec{Name: core.CompressionNone}, nil
+	}
+	return codec.New(compression, level)
+}
+
+// extractor writes the entries of an archive below dest with their modes,
+// modification times and the metadata selected by preserve. Later entries
+// replace earlier ones with the same name. Device and FIFO entries are
+// skipped.
+type extractor struct {
+	dest     string
+	preserve core.PreserveOptions
+	filters  *core.FilterOptions  // Applied while extracting, nil when the sender filtered
+	result   *core.TransferResult // Counts extracted entries when set
+	progress core.ProgressReporter
+	retry    bool            // Entries replace earlier copies and aren't counted again
+	skipped  []string        // Directories rejected by the filters
+	symlinks map[string]bool // Symlinks extracted so far, nothing is written through them
+}
+
+// extract reads the archive to its end. Directory times are applied last
+// since extracting into a directory changes them.
+func (x *extractor) extract(ctx context.Context, tarReader *tar.Reader) error {
+	var dirs []*tar.Header
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+
+		// Remote tar names entries ./path
+		header.Name = path.Clean(header.Name)
+		if header.Name == "." {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(header.Name)) {
+			return fmt.Errorf("unsafe path in archive: %s", header.Name)
+		}
+		// A symlink from the archive could point anywhere
+		if x.belowSymlink(header.Name) || (header.Typeflag == tar.TypeDir && x.symlinks[header.Name]) {
+			return fmt.Errorf("unsafe path in archive: %s goes through a symlink", header.Name)
+		}
+		if x.filtered(header.Name, header.Typeflag == tar.TypeDir) {
+			continue
+		}
+		target := filepath.Join(x.dest, filepath.FromSlash(header.Name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// Keep the directory writable until its contents are extracted
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, header)
+
+		case tar.TypeReg, tar.TypeGNUSparse:
+			if err := extractFile(tarReader, header, target, x.preserve); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			linkname := path.Clean(header.Linkname)
+			if !filepath.IsLocal(filepath.FromSlash(linkname)) || x.belowSymlink(linkname) || x.symlinks[linkname] {
+				return fmt.Errorf("unsafe link in archive: %s -> %s", header.Name, header.Linkname)
+			}
+			if err := extractLink(filepath.Join(x.dest, filepath.FromSlash(linkname)), target); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			if x.symlinks == nil {
+				x.symlinks = make(map[string]bool)
+			}
+			x.symlinks[header.Name] = true
+
+		default:
+			continue
+		}
+
+		// Files and links replace a symlink of the same name
+		if header.Typeflag != tar.TypeSymlink {
+			delete(x.symlinks, header.Name)
+		}
+		x.count(header)
+	}
+
+	// Innermost directories first so setting a mode can't block its children
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// Replaced by a symlink since
+		if x.symlinks[dirs[i].Name] || x.belowSymlink(dirs[i].Name) {
+			continue
+		}
+		target := filepath.Join(x.dest, filepath.FromSlash(dirs[i].Name))
+		if err := os.Chmod(target, dirs[i].FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := applyXattrs(target, dirs[i], x.preserve); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, accessTime(dirs[i]), dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// belowSymlink reports whether a parent directory of name is a symlink
+// extracted earlier
+func (x *extractor) belowSymlink(name string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if x.symlinks[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// filtered reports whether an entry is rejected by the filters, either
+// itself or through a rejected parent directory
+func (x *extractor) filtered(name string, isDir bool) bool {
+	for _, dir := range x.skipped {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	if x.filters == nil || matchesFilters(name, x.filters) {
+		return false
+	}
+	if isDir {
+		x.skipped = append(x.skipped, name)
+	}
+	return true
+}
+
+// count adds an extracted entry to the result and reports progress
+func (x *extractor) count(header *tar.Header) {
+	if x.result == nil {
+		return
+	}
+	// Retried entries were counted when first extracted
+	if x.retry {
+		return
+	}
+	x.result.FilesDone++
+	if header.Typeflag != tar.TypeReg {
+		return
+	}
+	x.result.BytesDone += header.Size
+	if x.progress != nil {
+		x.progress.Update(x.result.BytesDone, fmt.Sprintf("Extracting: %s", header.Name))
+	}
+}
+
+// extractFile writes a regular file entry to target, with holes where the
+// contents are zero when sparse files are preserved
+func extractFile(tarReader *tar.Reader, header *tar.Header, target string, preserve core.PreserveOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// Never write through a symlink left by an earlier transfer
+	if info, err := os.Lstat(target); err == nil && !info.Mode().IsRegular() {
+		if err := removeExisting(target); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if preserve.Sparse {
+		err = copySparse(file, tarReader)
+	} else {
+		_, err = io.Copy(file, tarReader)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(target, header.FileInfo().Mode().Perm()); err != nil {
+		return err
+	}
+	if err := applyXattrs(target, header, preserve); err != nil {
+		return err
+	}
+	return os.Chtimes(target, accessTime(header), header.ModTime)
+}
+
+// extractLink makes target another name of the already extracted source
+func extractLink(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// removeExisting removes whatever is at path so a new entry can take its
+// place. Non-empty directories are not removed.
+func removeExisting(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// accessTime returns the entry's access time, or its modification time
+// when the archive doesn't record one
+func accessTime(header *tar.Header) time.Time {
+	if header.AccessTime.IsZero() {
+		return header.ModTime
+	}
+	return header.AccessTime
+}
@@ -0,0 +1,202 @@
+package tarstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestTransferLocal_ExtractsTree(t *testing.T) {
+	source := t.TempDir()
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(source, "sub", "run.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(script, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/run.sh", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(source, "sub"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "copy")
+	result, err := New().Transfer(context.Background(), &core.TransferOptions{Source: source, Destination: dest})
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if result.FilesDone != 3 {
+		t.Errorf("files done = %d, want 3", result.FilesDone)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "sub", "run.sh"))
+	if err != nil {
+		t.Fatalf("copied file: %v", err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("mode = %v, want 0750", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("file mtime = %v, want %v", info.ModTime(), modTime)
+	}
+
+	if link, err := os.Readlink(filepath.Join(dest, "link")); err != nil || link != "sub/run.sh" {
+		t.Errorf("symlink = %q, %v", link, err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dest, "sub")); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("directory mtime not kept: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "transfer.tar.gz")); err == nil {
+		t.Error("local transfer wrote an archive")
+	}
+}
+
+func TestTransfer_ArchiveTo(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "out", "source.tar.gz")
+	_, err := New().Transfer(context.Background(), &core.TransferOptions{
+		Source:      source,
+		Compression: core.CompressionNone,
+		ArchiveTo:   archive,
+	})
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("archive named .tar.gz is not gzipped: %v", err)
+	}
+
+	tarReader := tar.NewReader(gzipReader)
+	header, err := tarReader.Next()
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	data, _ := io.ReadAll(tarReader)
+	if header.Name != "a.txt" || string(data) != "hello" {
+		t.Errorf("entry = %s %q", header.Name, data)
+	}
+}
+
+func TestExtract_RejectsUnsafePaths(t *testing.T) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	tarWriter.WriteHeader(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644})
+	tarWriter.Close()
+
+	x := &extractor{dest: t.TempDir()}
+	err := x.extract(context.Background(), tar.NewReader(&buf))
+	if err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Errorf("err = %v, want unsafe path", err)
+	}
+}
+
+func TestExtract_RejectsPathsThroughSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "target"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+		want    string
+	}{
+		{"file below symlink", []*tar.Header{
+			{Name: "./a", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "./a/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+		}, "unsafe path"},
+		{"directory over symlink", []*tar.Header{
+			{Name: "./a", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "./a/", Typeflag: tar.TypeDir, Mode: 0777},
+		}, "unsafe path"},
+		{"hard link through symlink", []*tar.Header{
+			{Name: "./a", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "./b", Typeflag: tar.TypeLink, Linkname: "./a/target"},
+		}, "unsafe link"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tarWriter := tar.NewWriter(&buf)
+		for _, header := range tt.headers {
+			tarWriter.WriteHeader(header)
+		}
+		tarWriter.Close()
+
+		x := &extractor{dest: t.TempDir()}
+		err := x.extract(context.Background(), tar.NewReader(&buf))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %s", tt.name, err, tt.want)
+		}
+	}
+
+	entries, _ := os.ReadDir(outside)
+	if info, err := os.Stat(outside); len(entries) != 1 || err != nil || info.Mode().Perm() == 0777 {
+		t.Errorf("directory outside the destination changed: %d entries, %v", len(entries), err)
+	}
+
+	// A file replacing a symlink is written in the destination
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	tarWriter.WriteHeader(&tar.Header{Name: "./a", Typeflag: tar.TypeSymlink, Linkname: outside})
+	tarWriter.WriteHeader(&tar.Header{Name: "./a", Typeflag: tar.TypeReg, Mode: 0644, Size: 2})
+	tarWriter.Write([]byte("ok"))
+	tarWriter.Close()
+	dest := t.TempDir()
+	x := &extractor{dest: dest}
+	if err := x.extract(context.Background(), tar.NewReader(&buf)); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if info, err := os.Lstat(filepath.Join(dest, "a")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("a = %v, %v, want a regular file", info, err)
+	}
+}
+
+func TestRetryChanged_BytesNotCountedAgain(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := &core.TransferResult{BytesDone: 5, FilesDone: 1}
+	aw := newArchiveWriter(io.Discard, core.DefaultPreserve())
+	changed, err := New().retryChanged(context.Background(), source, []string{"a.txt"}, aw, result)
+	if err != nil {
+		t.Fatalf("retryChanged: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("changed = %v, want the settled file archived", changed)
+	}
+	if result.BytesDone != 5 || result.FilesDone != 1 {
+		t.Errorf("bytes done = %d, files done = %d, want 5 and 1", result.BytesDone, result.FilesDone)
+	}
+}
@@ -0,0 +1,368 @@
+package tarstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+const (
+	blockSize = 512 // Tar header and padding unit
+
+	// xattrPrefix names PAX records holding extended attributes, as
+	// written by GNU tar and star
+	xattrPrefix = "SCHILY.xattr."
+
+	// sparseBlock is the smallest run of zeros written as a hole
+	sparseBlock = 4096
+)
+
+// region is a range of a sparse file that holds data
+type region struct {
+	offset, length int64
+}
+
+// archiveWriter adds entries to a tar stream with the metadata selected by
+// the preserve options
+type archiveWriter struct {
+	*tar.Writer
+	raw      io.Writer // Beneath the tar.Writer, for sparse entries it can't encode
+	preserve core.PreserveOptions
+	links    map[fileID]string // First name archived for files with several links
+}
+
+// newArchiveWriter starts a tar stream on w
+func newArchiveWriter(w io.Writer, preserve core.PreserveOptions) *archiveWriter {
+	return &archiveWriter{
+		Writer:   tar.NewWriter(w),
+		raw:      w,
+		preserve: preserve,
+		links:    make(map[fileID]string),
+	}
+}
+
+// header builds the entry header for a file, with its extended attributes
+// as PAX records when they are preserved. Symlinks carry none.
+func (aw *archiveWriter) header(filePath, name string, info fs.FileInfo, link string) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+
+	if (aw.preserve.Xattrs || aw.preserve.ACLs) && (info.IsDir() || info.Mode().IsRegular()) {
+		xattrs, err := readXattrs(filePath, aw.preserve)
+		if err != nil {
+			return nil, fmt.Errorf("read attributes of %s: %w", name, err)
+		}
+		for attr, value := range xattrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords[xattrPrefix+attr] = value
+		}
+	}
+	return header, nil
+}
+
+// addLink writes a hard link entry when the file shares its inode with one
+// archived earlier, otherwise it remembers the file for later links
+func (aw *archiveWriter) addLink(name string, info fs.FileInfo) (bool, error) {
+	if !aw.preserve.Hardlinks {
+		return false, nil
+	}
+	id, ok := linkedFileID(info)
+	if !ok {
+		return false, nil
+	}
+	first, seen := aw.links[id]
+	if !seen {
+		aw.links[id] = name
+		return false, nil
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return false, err
+	}
+	header.Name = name
+	header.Typeflag = tar.TypeLink
+	header.Linkname = first
+	header.Size = 0
+	return true, aw.WriteHeader(header)
+}
+
+// addFile writes a regular file to the archive and reports whether it
+// changed between stat and the end of the read. The entry always matches
+// its header: a file that grew is cut at the stat size and one that shrank
+// is padded with zeros, so the archive stays valid.
+func (aw *archiveWriter) addFile(filePath, name string, info fs.FileInfo) (int64, bool, error) {
+	header, err := aw.header(filePath, name, info, "")
+	if err != nil {
+		return 0, false, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	var regions []region
+	if aw.preserve.Sparse {
+		if regions, err = dataRegions(file, info); err != nil {
+			return 0, false, err
+		}
+	}
+
+	var written int64
+	var changed bool
+	if regions != nil {
+		written, changed, err = aw.writeSparse(header, file, regions)
+	} else {
+		if err := aw.WriteHeader(header); err != nil {
+			return 0, false, err
+		}
+		written, changed, err = copyPadded(aw, file, header.Size)
+	}
+	if err != nil {
+		return written, changed, err
+	}
+
+	after, err := file.Stat()
+	if err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		changed = true
+	}
+
+	return written, changed, nil
+}
+
+// writeSparse writes a file as a PAX 1.0 sparse entry: a PAX header naming
+// the real file, then a map of the data regions and the regions themselves.
+// GNU tar and archive/tar read the format, but archive/tar can't write it,
+// so the entry goes straight to the underlying stream.
+func (aw *archiveWriter) writeSparse(header *tar.Header, file *os.File, regions []region) (int64, bool, error) {
+	if err := aw.Flush(); err != nil {
+		return 0, false, err
+	}
+
+	var sparseMap bytes.Buffer
+	var dataSize int64
+	fmt.Fprintf(&sparseMap, "%d\n", len(regions))
+	for _, r := range regions {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", r.offset, r.length)
+		dataSize += r.length
+	}
+	sparseMap.Write(make([]byte, blockPadding(int64(sparseMap.Len()))))
+	size := int64(sparseMap.Len()) + dataSize
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     header.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(header.Size, 10),
+		"size":                strconv.FormatInt(size, 10),
+		"uid":                 strconv.Itoa(header.Uid),
+		"gid":                 strconv.Itoa(header.Gid),
+		"uname":               header.Uname,
+		"gname":               header.Gname,
+	}
+	maps.Copy(records, header.PAXRecords)
+	var pax bytes.Buffer
+	for _, key := range slices.Sorted(maps.Keys(records)) {
+		pax.WriteString(paxRecord(key, records[key]))
+	}
+
+	dir, base := path.Split(header.Name)
+	blocks := [][]byte{
+		ustarHeader(path.Join(dir, "PaxHeaders.0", base), tar.TypeXHeader, int64(pax.Len()), header),
+		pax.Bytes(),
+		make([]byte, blockPadding(int64(pax.Len()))),
+		ustarHeader(path.Join(dir, "GNUSparseFile.0", base), tar.TypeReg, size, header),
+		sparseMap.Bytes(),
+	}
+	for _, block := range blocks {
+		if _, err := aw.raw.Write(block); err != nil {
+			return 0, false, err
+		}
+	}
+
+	var written int64
+	changed := false
+	for _, r := range regions {
+		n, short, err := copyPadded(aw.raw, io.NewSectionReader(file, r.offset, r.length), r.length)
+		written += n
+		changed = changed || short
+		if err != nil {
+			return written, changed, err
+		}
+	}
+	if _, err := aw.raw.Write(make([]byte, blockPadding(size))); err != nil {
+		return written, changed, err
+	}
+
+	// Holes count as transferred
+	return written + header.Size - dataSize, changed, nil
+}
+
+// copyPadded copies n bytes from r, padding with zeros when r ends early
+// and reporting that it did
+func copyPadded(w io.Writer, r io.Reader, n int64) (int64, bool, error) {
+	written, err := io.CopyN(w, r, n)
+	if err == io.EOF {
+		_, err := io.CopyN(w, zeroReader{}, n-written)
+		return written, true, err
+	}
+	return written, false, err
+}
+
+// zeroReader pads archive entries of files that shrank while being read
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// ustarHeader encodes a header block. Values that don't fit are written as
+// zero and must be given as PAX records.
+func ustarHeader(name string, typeflag byte, size int64, header *tar.Header) []byte {
+	block := make([]byte, blockSize)
+	copy(block[0:100], name)
+	putOctal(block[100:108], header.Mode&07777)
+	putOctal(block[108:116], int64(header.Uid))
+	putOctal(block[116:124], int64(header.Gid))
+	putOctal(block[124:136], size)
+	putOctal(block[136:148], header.ModTime.Unix())
+	block[156] = typeflag
+	copy(block[257:265], "ustar\x0000")
+	copy(block[265:297], header.Uname)
+	copy(block[297:329], header.Gname)
+
+	// The checksum is computed with its own field set to spaces
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	putOctal(block[148:155], sum)
+	return block
+}
+
+// putOctal writes v as zero-padded octal followed by a NUL
+func putOctal(field []byte, v int64) {
+	digits := strconv.FormatInt(v, 8)
+	if v < 0 || len(digits) > len(field)-1 {
+		digits = "0"
+	}
+	copy(field, strings.Repeat("0", len(field)-1-len(digits))+digits)
+	field[len(field)-1] = 0
+}
+
+// paxRecord formats a PAX record, which starts with its own length
+func paxRecord(key, value string) string {
+	const padding = 3 // ' ', '=' and '\n'
+	size := len(key) + len(value) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + key + "=" + value + "\n"
+
+	// The length may gain a digit by counting itself
+	if len(record) != size {
+		size = len(record)
+		record = strconv.Itoa(size) + " " + key + "=" + value + "\n"
+	}
+	return record
+}
+
+// blockPadding returns the bytes needed to fill the last block of n bytes
+func blockPadding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
+
+// remoteTarFlags returns the GNU tar options for the preserved metadata,
+// quoted for a remote shell
+func remoteTarFlags(preserve core.PreserveOptions) []string {
+	var flags []string
+	for _, flag := range preserve.TarFlags() {
+		flags = append(flags, transport.ShellQuote(flag))
+	}
+	return flags
+}
+
+// applyXattrs sets the preserved extended attributes an entry carries
+func applyXattrs(target string, header *tar.Header, preserve core.PreserveOptions) error {
+	for key, value := range header.PAXRecords {
+		attr, ok := strings.CutPrefix(key, xattrPrefix)
+		if !ok || !preserve.KeepsXattr(attr) {
+			continue
+		}
+		if err := setXattr(target, attr, value); err != nil {
+			return fmt.Errorf("set %s on %s: %w", attr, header.Name, err)
+		}
+	}
+	return nil
+}
+
+// copySparse writes r to file, seeking over runs of zero blocks so they
+// become holes
+func copySparse(file *os.File, r io.Reader) error {
+	buf := make([]byte, 32*sparseBlock)
+	var size int64
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		chunk := buf[:n]
+		for len(chunk) > 0 {
+			zero := isZero(chunk[:min(sparseBlock, len(chunk))])
+			run := 0
+			for run < len(chunk) {
+				end := min(run+sparseBlock, len(chunk))
+				if isZero(chunk[run:end]) != zero {
+					break
+				}
+				run = end
+			}
+
+			if zero {
+				if _, err := file.Seek(int64(run), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := file.Write(chunk[:run]); err != nil {
+				return err
+			}
+			chunk = chunk[run:]
+		}
+		size += int64(n)
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// A trailing hole only exists once the size is set
+	return file.Truncate(size)
+}
+
+// isZero reports whether p holds only zero bytes
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
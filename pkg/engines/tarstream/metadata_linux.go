@@ -0,0 +1,129 @@
+//go:build linux
+
+package tarstream
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// readXattrs returns the preserved extended attributes of a file. A
+// filesystem without attribute support has none.
+func readXattrs(path string, preserve core.PreserveOptions) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range splitNames(buf[:size]) {
+		if !preserve.KeepsXattr(name) {
+			continue
+		}
+		value, err := getXattr(path, name)
+		if errors.Is(err, unix.ENODATA) {
+			continue // Removed since listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+// getXattr reads one extended attribute
+func getXattr(path, name string) (string, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:size]), nil
+}
+
+// setXattr sets an extended attribute, skipped on filesystems without
+// attribute support
+func setXattr(path, name, value string) error {
+	err := unix.Lsetxattr(path, name, []byte(value), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil
+	}
+	return err
+}
+
+// splitNames splits the NUL separated list returned by listxattr
+func splitNames(buf []byte) []string {
+	var names []string
+	start := 0
+	for i, b := range buf {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names
+}
+
+// dataRegions returns the regions of a sparse file that hold data, or nil
+// when the file has no holes or the filesystem can't report them
+func dataRegions(file *os.File, info fs.FileInfo) ([]region, error) {
+	size := info.Size()
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Blocks*512 >= size {
+		return nil, nil
+	}
+
+	fd := int(file.Fd())
+	var regions []region
+	for offset := int64(0); offset < size; {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // Only a hole remains
+		}
+		if errors.Is(err, unix.EINVAL) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data >= size {
+			break
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		hole = min(hole, size)
+		regions = append(regions, region{offset: data, length: hole - data})
+		offset = hole
+	}
+
+	if len(regions) == 1 && regions[0].length == size {
+		return nil, nil
+	}
+	// An empty region at the end records the size of a trailing hole
+	if len(regions) == 0 || regions[len(regions)-1].offset+regions[len(regions)-1].length < size {
+		regions = append(regions, region{offset: size})
+	}
+	return regions, nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package tarstream
+
+import (
+	"io/fs"
+	"os"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// readXattrs returns no attributes, they are only read on Linux
+func readXattrs(path string, preserve core.PreserveOptions) (map[string]string, error) {
+	return nil, nil
+}
+
+// setXattr skips attributes, they are only set on Linux
+func setXattr(path, name, value string) error {
+	return nil
+}
+
+// dataRegions reports no holes, they are only detected on Linux
+func dataRegions(file *os.File, info fs.FileInfo) ([]region, error) {
+	return nil, nil
+}
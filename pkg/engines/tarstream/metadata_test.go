@@ -0,0 +1,142 @@
+//go:build unix
+
+package tarstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestTransferLocal_PreservesHardlinks(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte("shared"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(source, "a.txt"), filepath.Join(source, "b.txt")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	dest := t.TempDir()
+	if _, err := New().Transfer(context.Background(), &core.TransferOptions{Source: source, Destination: dest}); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	a, err := os.Stat(filepath.Join(dest, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(dest, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("hard linked files were copied separately")
+	}
+}
+
+func TestTransferLocal_PreservesSparseFiles(t *testing.T) {
+	source := t.TempDir()
+	sparse := filepath.Join(source, "disk.img")
+	file, err := os.Create(sparse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("data"), 4<<20)
+	file.Truncate(8 << 20)
+	file.Close()
+	if allocated(t, sparse) >= 8<<20 {
+		t.Skip("filesystem does not create sparse files")
+	}
+
+	dest := t.TempDir()
+	_, err = New().Transfer(context.Background(), &core.TransferOptions{
+		Source:      source,
+		Destination: dest,
+		Preserve:    &core.PreserveOptions{Sparse: true},
+	})
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	copied := filepath.Join(dest, "disk.img")
+	want, _ := os.ReadFile(sparse)
+	got, err := os.ReadFile(copied)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("contents differ: %v", err)
+	}
+	if allocated(t, copied) >= 1<<20 {
+		t.Errorf("copy is not sparse, %d bytes allocated", allocated(t, copied))
+	}
+}
+
+func TestWriteSparse_ReadableByArchiveTar(t *testing.T) {
+	source := t.TempDir()
+	name := filepath.Join(source, "holes")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("start"), 0)
+	file.WriteAt([]byte("end"), 3<<20)
+	file.Close()
+
+	file, err = os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, _ := file.Stat()
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Name = "dir/holes"
+
+	var buf bytes.Buffer
+	aw := newArchiveWriter(&buf, core.PreserveOptions{Sparse: true})
+	regions := []region{{offset: 0, length: 5}, {offset: 3 << 20, length: 3}}
+	if _, _, err := aw.writeSparse(header, file, regions); err != nil {
+		t.Fatalf("writeSparse: %v", err)
+	}
+	aw.WriteHeader(&tar.Header{Name: "after", Typeflag: tar.TypeReg, Mode: 0644})
+	aw.Close()
+
+	tarReader := tar.NewReader(&buf)
+	read, err := tarReader.Next()
+	if err != nil {
+		t.Fatalf("read sparse entry: %v", err)
+	}
+	if read.Name != "dir/holes" || read.Size != info.Size() {
+		t.Errorf("entry = %s %d bytes, want dir/holes %d bytes", read.Name, read.Size, info.Size())
+	}
+	data, err := io.ReadAll(tarReader)
+	want, _ := os.ReadFile(name)
+	if err != nil || !bytes.Equal(data, want) {
+		t.Errorf("sparse contents differ: %v", err)
+	}
+	if next, err := tarReader.Next(); err != nil || next.Name != "after" {
+		t.Errorf("entry after sparse file: %v", err)
+	}
+}
+
+// allocated returns the bytes a file occupies on disk
+func allocated(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		t.Skip("allocation not available on this platform")
+	}
+	return stat.Blocks * 512
+}
@@ -0,0 +1,172 @@
+package tarstream
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+var (
+	// changedFilePattern matches GNU tar warnings about files modified while
+	// they were being archived
+	changedFilePattern = regexp.MustCompile(`^tar: (.+): (?:file changed as we read it|File shrank by \d+ bytes; padding with zeros)$`)
+
+	// tarExitPattern accompanies those warnings
+	tarExitPattern = regexp.MustCompile(`^tar: Exiting with failure status due to previous errors$`)
+)
+
+// transferPull runs tar on the source host and extracts the stream locally.
+// Exclude patterns are passed to the remote tar; includes, and excludes it
+// can't express, are applied while extracting.
+func (e *Engine) transferPull(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult) error {
+	sourceLoc, err := transport.ParseRemotePath(opts.Source)
+	if err != nil {
+		return fmt.Errorf("parse source: %w", err)
+	}
+
+	var authConfig map[string]interface{}
+	var jumps []core.JumpHost
+	if opts.Auth != nil {
+		authConfig = opts.Auth.SourceAuth
+		jumps = opts.Auth.SourceJumps
+	}
+	auth, err := transport.ResolveAuth(authConfig, opts.Auth.SourcePasswordEnv())
+	if err != nil {
+		return fmt.Errorf("source auth: %w", err)
+	}
+	if err = sourceLoc.ResolveJumps(jumps); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+
+	client, err := e.transport.Connect(ctx, sourceLoc.SSHConfig(auth))
+	if err != nil {
+		return fmt.Errorf("connect to source: %w", err)
+	}
+	defer e.transport.Close(client)
+
+	// Decompression happens in-process, so only the source needs the codec
+	requested, err := codec.New(opts.Compression, opts.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	available, err := codec.Probe(ctx, e.transport, client)
+	if err != nil {
+		return err
+	}
+	c := codec.Negotiate(requested, available)
+	result.Compression = c.Name
+
+	if err := os.MkdirAll(opts.Destination, 0755); err != nil {
+		return fmt.Errorf("create destination: %w", err)
+	}
+
+	if e.progress != nil {
+		e.progress.Start(0, fmt.Sprintf("Pulling from %s", sourceLoc.Host))
+	}
+
+	x := &extractor{
+		dest:     opts.Destination,
+		preserve: core.PreserveOrDefault(opts.Preserve),
+		filters:  opts.Filters,
+		result:   result,
+		progress: e.progress,
+	}
+	changed, err := e.pullMembers(ctx, client, c, sourceLoc, opts.Filters, []string{"."}, x)
+	if err != nil {
+		return err
+	}
+
+	// Fetch files that changed mid-read again, later copies replace earlier
+	x.retry = true
+	for attempt := 0; attempt < maxChangeRetries && len(changed) > 0; attempt++ {
+		if changed, err = e.pullMembers(ctx, client, c, sourceLoc, opts.Filters, changed, x); err != nil {
+			return err
+		}
+	}
+	result.ChangedFiles = changed
+
+	return nil
+}
+
+// pullMembers streams the given members of the source directory and
+// extracts them, returning the files tar reported as changed while read
+func (e *Engine) pullMembers(ctx context.Context, client *transport.SSHClient, c codec.Codec, sourceLoc *transport.RemoteLocation, filters *core.FilterOptions, members []string, x *extractor) ([]string, error) {
+	remoteCmd := c.CompressOutput(remoteTarCommand(sourceLoc.Path, members, filters, x.preserve))
+	stream, err := e.transport.StreamCommand(ctx, client, remoteCmd)
+	if err != nil {
+		return nil, fmt.Errorf("start remote tar: %w", err)
+	}
+
+	reader, err := c.NewReader(stream)
+	if err != nil {
+		// Closing the connection stops the remote tar
+		return nil, fmt.Errorf("start %s: %w", c.Name, err)
+	}
+	if err := x.extract(ctx, tar.NewReader(reader)); err != nil {
+		return nil, fmt.Errorf("extract: %w", err)
+	}
+	reader.Close()
+
+	// Read the archive's trailing padding so the remote tar can exit
+	io.Copy(io.Discard, stream)
+	err = stream.Close()
+	if err == nil {
+		return nil, nil
+	}
+
+	var cmdErr *transport.CommandError
+	if !errors.As(err, &cmdErr) {
+		return nil, fmt.Errorf("close remote tar: %w", err)
+	}
+	if changed, ok := parseChangedFiles(cmdErr.Stderr); ok && (cmdErr.Status == 1 || cmdErr.Status == 2) {
+		return changed, nil
+	}
+	return nil, &RemoteTarError{Host: sourceLoc.Host, Status: cmdErr.Status, Stderr: cmdErr.Stderr}
+}
+
+// remoteTarCommand builds the tar command archiving members of dir to
+// stdout. Excludes without a slash match base names like matchesFilters.
+func remoteTarCommand(dir string, members []string, filters *core.FilterOptions, preserve core.PreserveOptions) string {
+	args := []string{"tar", "-cf", "-", "-C", transport.QuoteRemotePath(dir)}
+	args = append(args, remoteTarFlags(preserve)...)
+	if filters != nil {
+		for _, pattern := range filters.Exclude {
+			if !strings.Contains(pattern, "/") {
+				args = append(args, "--exclude="+transport.ShellQuote(pattern))
+			}
+		}
+	}
+	for _, member := range members {
+		if member != "." {
+			member = "./" + member
+		}
+		args = append(args, transport.ShellQuote(member))
+	}
+	return strings.Join(args, " ")
+}
+
+// parseChangedFiles returns the files named in tar's change warnings. ok is
+// false when stderr holds anything else.
+func parseChangedFiles(stderr string) (files []string, ok bool) {
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || tarExitPattern.MatchString(line) {
+			continue
+		}
+		m := changedFilePattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, false
+		}
+		files = append(files, strings.TrimPrefix(m[1], "./"))
+	}
+	return files, len(files) > 0
+}
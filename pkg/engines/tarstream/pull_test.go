@@ -0,0 +1,103 @@
+package tarstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestRemoteTarCommand(t *testing.T) {
+	filters := &core.FilterOptions{Exclude: []string{"*.log", "build/tmp"}}
+
+	got := remoteTarCommand("/data/my dir", []string{"."}, filters, core.DefaultPreserve())
+	want := `tar -cf - -C '/data/my dir' --exclude='*.log' '.'`
+	if got != want {
+		t.Errorf("command = %s, want %s", got, want)
+	}
+
+	got = remoteTarCommand("/data", []string{"a b.txt"}, nil, core.PreserveOptions{Sparse: true})
+	want = `tar -cf - -C '/data' '--hard-dereference' '--sparse' './a b.txt'`
+	if got != want {
+		t.Errorf("retry command = %s, want %s", got, want)
+	}
+}
+
+func TestParseChangedFiles(t *testing.T) {
+	stderr := "tar: ./logs/app.log: file changed as we read it\n" +
+		"tar: Exiting with failure status due to previous errors\n"
+	files, ok := parseChangedFiles(stderr)
+	if !ok || !reflect.DeepEqual(files, []string{"logs/app.log"}) {
+		t.Errorf("files = %v, %v", files, ok)
+	}
+
+	if _, ok := parseChangedFiles("tar: ./data: Cannot open: Permission denied\n"); ok {
+		t.Error("other errors parsed as changed files")
+	}
+}
+
+func TestExtract_AppliesFilters(t *testing.T) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, name := range []string{"./", "./keep.txt", "./skip/", "./skip/inner.txt", "./debug.log"} {
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}
+		if name[len(name)-1] == '/' {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		tarWriter.WriteHeader(header)
+	}
+	tarWriter.Close()
+
+	dest := t.TempDir()
+	result := &core.TransferResult{}
+	x := &extractor{
+		dest:    dest,
+		filters: &core.FilterOptions{Exclude: []string{"skip", "*.log"}},
+		result:  result,
+	}
+	if err := x.extract(context.Background(), tar.NewReader(&buf)); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "keep.txt")); err != nil {
+		t.Errorf("kept file missing: %v", err)
+	}
+	for _, name := range []string{"skip", "debug.log"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err == nil {
+			t.Errorf("%s extracted despite exclude", name)
+		}
+	}
+	if result.FilesDone != 1 {
+		t.Errorf("files done = %d, want 1", result.FilesDone)
+	}
+}
+
+func TestExtract_RetriesNotCountedAgain(t *testing.T) {
+	archive := func() *tar.Reader {
+		var buf bytes.Buffer
+		tarWriter := tar.NewWriter(&buf)
+		tarWriter.WriteHeader(&tar.Header{Name: "./data.bin", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+		tarWriter.Write([]byte("data"))
+		tarWriter.Close()
+		return tar.NewReader(&buf)
+	}
+
+	result := &core.TransferResult{}
+	x := &extractor{dest: t.TempDir(), result: result}
+	if err := x.extract(context.Background(), archive()); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	x.retry = true
+	if err := x.extract(context.Background(), archive()); err != nil {
+		t.Fatalf("extract retry: %v", err)
+	}
+	if result.FilesDone != 1 || result.BytesDone != 4 {
+		t.Errorf("files done = %d, bytes done = %d, want 1 and 4", result.FilesDone, result.BytesDone)
+	}
+}
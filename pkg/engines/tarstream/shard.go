@@ -0,0 +1,243 @@
+package tarstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/larrydiffey/difpipe/internal/parallel"
+	"github.com/larrydiffey/difpipe/pkg/codec"
+	"github.com/larrydiffey/difpipe/pkg/core"
+	"github.com/larrydiffey/difpipe/pkg/transport"
+)
+
+// entryOverhead weighs every file like this many bytes when balancing
+// shards, so a shard of many small files isn't treated as empty
+const entryOverhead = 4096
+
+// entry is a file, directory or link of the source tree
+type entry struct {
+	path string // On disk
+	name string // Relative to the source
+	info fs.FileInfo
+}
+
+// transferSharded splits the source tree into opts.Parallel streams of
+// similar size, each extracted by its own tar over its own connection.
+// Directories follow in a last stream so their times and modes are set
+// once every shard is done writing into them.
+func (e *Engine) transferSharded(ctx context.Context, opts *core.TransferOptions, result *core.TransferResult, destLoc *transport.RemoteLocation, auth transport.AuthMethod, client *transport.SSHClient, c codec.Codec) error {
+	entries, err := collectEntries(ctx, opts.Source, opts.Filters)
+	if err != nil {
+		return fmt.Errorf("scan source: %w", err)
+	}
+
+	preserve := core.PreserveOrDefault(opts.Preserve)
+	shards, dirs := planShards(entries, opts.Parallel, preserve.Hardlinks)
+
+	if e.progress != nil {
+		var total int64
+		for _, entry := range entries {
+			if entry.info.Mode().IsRegular() {
+				total += entry.info.Size()
+			}
+		}
+		e.progress.Start(total, fmt.Sprintf("Streaming %d entries to %s over %d connections",
+			len(entries), destLoc.Host, len(shards)))
+	}
+
+	// A failing shard stops the others
+	shardCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progress := &shardProgress{parent: e.progress, done: make([]int64, len(shards))}
+	results := make([]core.TransferResult, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := &Engine{transport: e.transport, progress: progress.view(i)}
+			if errs[i] = worker.sendShard(shardCtx, destLoc, auth, c, preserve, opts.Source, shard, &results[i]); errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range results {
+		result.BytesDone += results[i].BytesDone
+		result.FilesDone += results[i].FilesDone
+		result.ChangedFiles = append(result.ChangedFiles, results[i].ChangedFiles...)
+	}
+	slices.Sort(result.ChangedFiles)
+	if err := parallel.FirstError(errs); err != nil {
+		return err
+	}
+
+	return e.streamArchive(ctx, client, destLoc, c, preserve, func(aw *archiveWriter) error {
+		for _, dir := range dirs {
+			if _, err := e.addEntry(aw, dir.path, dir.name, dir.info, result); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sendShard streams one shard over a connection of its own
+func (e *Engine) sendShard(ctx context.Context, destLoc *transport.RemoteLocation, auth transport.AuthMethod, c codec.Codec, preserve core.PreserveOptions, source string, shard []entry, result *core.TransferResult) error {
+	client, err := e.transport.Connect(ctx, destLoc.SSHConfig(auth).WithExclusive())
+	if err != nil {
+		return fmt.Errorf("connect to destination: %w", err)
+	}
+	defer e.transport.Close(client)
+
+	return e.streamArchive(ctx, client, destLoc, c, preserve, func(aw *archiveWriter) error {
+		var changed []string
+		for _, entry := range shard {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// Stat again, the scan may be a while ago
+			info, err := os.Lstat(entry.path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			fileChanged, err := e.addEntry(aw, entry.path, entry.name, info, result)
+			if err != nil {
+				return err
+			}
+			if fileChanged {
+				changed = append(changed, entry.name)
+			}
+		}
+
+		var err error
+		result.ChangedFiles, err = e.retryChanged(ctx, source, changed, aw, result)
+		return err
+	})
+}
+
+// collectEntries walks the source tree like walkAndTar, listing the entries
+// that pass the filters
+func collectEntries(ctx context.Context, source string, filters *core.FilterOptions) ([]entry, error) {
+	var entries []entry
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		if filters != nil && !matchesFilters(relPath, filters) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path: path, name: relPath, info: info})
+		return nil
+	})
+	return entries, err
+}
+
+// planShards deals the non-directory entries into at most n shards,
+// each going to the least loaded one. Names of a hard linked file share a
+// shard so they stay linked. Directories are returned separately.
+func planShards(entries []entry, n int, hardlinks bool) ([][]entry, []entry) {
+	var dirs, files []entry
+	for _, entry := range entries {
+		if entry.info.IsDir() {
+			dirs = append(dirs, entry)
+		} else {
+			files = append(files, entry)
+		}
+	}
+
+	n = max(1, min(n, len(files)))
+	shards := make([][]entry, n)
+	loads := make([]int64, n)
+	linked := make(map[fileID]int)
+	for _, entry := range files {
+		shard := slices.Index(loads, slices.Min(loads))
+		if id, ok := linkedFileID(entry.info); ok && hardlinks {
+			if first, seen := linked[id]; seen {
+				shards[first] = append(shards[first], entry)
+				continue
+			}
+			linked[id] = shard
+		}
+
+		shards[shard] = append(shards[shard], entry)
+		loads[shard] += entryOverhead
+		if entry.info.Mode().IsRegular() {
+			loads[shard] += entry.info.Size()
+		}
+	}
+	return shards, dirs
+}
+
+// shardProgress adds up the progress of concurrent shards
+type shardProgress struct {
+	parent core.ProgressReporter
+	mutex  sync.Mutex
+	done   []int64
+}
+
+// view returns the reporter for one shard
+func (p *shardProgress) view(shard int) core.ProgressReporter {
+	if p.parent == nil {
+		return nil
+	}
+	return &shardReporter{progress: p, shard: shard}
+}
+
+// shardReporter forwards a shard's updates as the total of all shards.
+// Start, completion and errors are reported for the whole transfer.
+type shardReporter struct {
+	progress *shardProgress
+	shard    int
+}
+
+func (r *shardReporter) Start(total int64, message string) {}
+
+func (r *shardReporter) Update(done int64, message string) {
+	p := r.progress
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.done[r.shard] = done
+	var total int64
+	for _, d := range p.done {
+		total += d
+	}
+	p.parent.Update(total, message)
+}
+
+func (r *shardReporter) Complete(message string) {}
+
+func (r *shardReporter) Error(err error) {}
@@ -0,0 +1,86 @@
+package tarstream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanShards_BalancesBySize(t *testing.T) {
+	source := t.TempDir()
+	sizes := map[string]int{"big": 1 << 20, "mid": 600 << 10, "small1": 300 << 10, "small2": 300 << 10}
+	for name, size := range sizes {
+		if err := os.WriteFile(filepath.Join(source, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(source, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := collectEntries(context.Background(), source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, dirs := planShards(entries, 2, true)
+
+	if len(dirs) != 1 || dirs[0].name != "dir" {
+		t.Errorf("dirs = %v, want dir", dirs)
+	}
+	if len(shards) != 2 {
+		t.Fatalf("got %d shards, want 2", len(shards))
+	}
+	for i, shard := range shards {
+		var size int64
+		for _, entry := range shard {
+			size += entry.info.Size()
+		}
+		if size != 1<<20 && size != 1200<<10 {
+			t.Errorf("shard %d holds %d bytes, shards are unbalanced", i, size)
+		}
+	}
+}
+
+func TestPlanShards_KeepsHardlinksTogether(t *testing.T) {
+	source := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(source, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(filepath.Join(source, "a"), filepath.Join(source, "z")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	entries, err := collectEntries(context.Background(), source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, _ := planShards(entries, 3, true)
+
+	for _, shard := range shards {
+		var names []string
+		for _, entry := range shard {
+			names = append(names, entry.name)
+		}
+		if len(names) > 0 && names[0] == "a" && (len(names) != 2 || names[1] != "z") {
+			t.Errorf("linked names split across shards: %v", names)
+		}
+	}
+}
+
+func TestPlanShards_FewerFilesThanShards(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "only"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := collectEntries(context.Background(), source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shards, _ := planShards(entries, 8, true); len(shards) != 1 {
+		t.Errorf("got %d shards for one file, want 1", len(shards))
+	}
+}
@@ -0,0 +1,136 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/benchmark"
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// codecSavings is the fraction of compressible bytes each codec removes
+var codecSavings = map[core.Compression]float64{
+	core.CompressionGzip: 0.7,
+	core.CompressionZstd: 0.75,
+	core.CompressionLz4:  0.5,
+}
+
+// Compare analyzes a transfer and estimates it with every registered engine
+// that supports the protocol pair. Engines that fail to estimate are listed
+// with the error instead of failing the comparison.
+func (o *Orchestrator) Compare(ctx context.Context, opts *core.TransferOptions) (*core.FileAnalysis, error) {
+	analysis, err := o.AnalyzeTransfer(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("analyze transfer: %w", err)
+	}
+
+	bytesTotal, filesTotal := analysis.TotalSize, analysis.TotalFiles
+	if analysis.Delta != nil {
+		bytesTotal = analysis.Delta.TransferBytes()
+		filesTotal = analysis.Delta.TransferFiles()
+	}
+
+	comparison := &core.Comparison{}
+	for strategy, engine := range o.engines {
+		if !supportsTransfer(engine, analysis.SourceProtocol, analysis.DestProtocol) {
+			continue
+		}
+
+		engineOpts := *opts
+		engineOpts.Strategy = strategy
+		if engineOpts.Compression == "" {
+			engineOpts.Compression = core.CompressionAuto
+		}
+		if engineOpts.Compression == core.CompressionAuto && analysis.Compression == core.CompressionNone {
+			engineOpts.Compression = core.CompressionNone
+		}
+
+		row := core.StrategyComparison{
+			Strategy:    strategy,
+			BytesTotal:  bytesTotal,
+			WireBytes:   bytesTotal,
+			Compression: core.CompressionNone,
+			Recommended: strategy == analysis.Recommendation,
+		}
+		if describer, ok := engine.(core.EngineDescriber); ok {
+			caps := describer.Capabilities(&engineOpts)
+			row.Resume = caps.Resume
+			row.RequiredBinaries = caps.RequiredBinaries
+			row.MissingBinaries = missingBinaries(caps.RequiredBinaries)
+			row.Compression = caps.Compression
+			row.WireBytes = wireBytes(bytesTotal, analysis.Compressibility, caps.Compression)
+		}
+
+		estimate, err := engine.Estimate(ctx, &engineOpts)
+		if err != nil {
+			row.Error = err.Error()
+			comparison.Strategies = append(comparison.Strategies, row)
+			continue
+		}
+
+		// Scale the engine's own estimate to what actually goes on the wire,
+		// or use benchmarks (100 MB/s fallback) when it gave no time
+		if estimate.EstimatedTime > 0 && estimate.BytesTotal > 0 {
+			scale := float64(row.WireBytes) / float64(estimate.BytesTotal)
+			row.EstimatedTime = time.Duration(float64(estimate.EstimatedTime) * scale)
+			row.EstimatedSpeed = estimate.EstimatedSpeed
+		} else if row.WireBytes > 0 {
+			timing := benchmark.EstimateTransfer(opts.Source, opts.Destination, row.WireBytes, filesTotal, 100*1024*1024)
+			row.EstimatedTime = timing.Duration
+			row.EstimatedSpeed = timing.Speed
+		}
+
+		comparison.Strategies = append(comparison.Strategies, row)
+	}
+
+	// Fastest first, engines that could not estimate last
+	sort.Slice(comparison.Strategies, func(i, j int) bool {
+		a, b := comparison.Strategies[i], comparison.Strategies[j]
+		if (a.Error == "") != (b.Error == "") {
+			return a.Error == ""
+		}
+		if a.EstimatedTime != b.EstimatedTime {
+			return a.EstimatedTime < b.EstimatedTime
+		}
+		return a.Strategy < b.Strategy
+	})
+
+	analysis.Comparison = comparison
+	return analysis, nil
+}
+
+// supportsTransfer checks whether an engine handles a protocol pair
+func supportsTransfer(engine core.TransferEngine, source, dest core.Protocol) bool {
+	if describer, ok := engine.(core.EngineDescriber); ok {
+		return describer.SupportsTransfer(source, dest)
+	}
+	return engine.SupportsProtocol(string(source)) && engine.SupportsProtocol(string(dest))
+}
+
+// wireBytes estimates the bytes sent after compression
+func wireBytes(bytes int64, compressibility float64, compression core.Compression) int64 {
+	savings, ok := codecSavings[compression]
+	if !ok {
+		return bytes
+	}
+	return int64(float64(bytes) * (1 - compressibility*savings))
+}
+
+// missingBinaries returns the local binaries that are not in PATH. Remote
+// binaries cannot be checked without connecting.
+func missingBinaries(binaries []string) []string {
+	var missing []string
+	for _, bin := range binaries {
+		if strings.HasSuffix(bin, "(remote)") {
+			continue
+		}
+		if _, err := exec.LookPath(bin); err != nil {
+			missing = append(missing, bin)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,105 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/larrydiffey/difpipe/pkg/analyzer"
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// fakeEngine estimates a fixed duration for whatever it is asked
+type fakeEngine struct {
+	name     string
+	local    bool
+	duration time.Duration
+	err      error
+	caps     core.EngineCapabilities
+}
+
+func (f *fakeEngine) Name() string { return f.name }
+
+func (f *fakeEngine) Transfer(ctx context.Context, opts *core.TransferOptions) (*core.TransferResult, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeEngine) SupportsProtocol(protocol string) bool { return true }
+
+func (f *fakeEngine) SupportsTransfer(source, dest core.Protocol) bool {
+	return f.local && source == core.ProtocolLocal && dest == core.ProtocolLocal
+}
+
+func (f *fakeEngine) Capabilities(opts *core.TransferOptions) core.EngineCapabilities {
+	return f.caps
+}
+
+func (f *fakeEngine) Estimate(ctx context.Context, opts *core.TransferOptions) (*core.TransferEstimate, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &core.TransferEstimate{BytesTotal: 1000, EstimatedTime: f.duration, EstimatedSpeed: "fake"}, nil
+}
+
+func TestCompare_ListsSupportingEnginesFastestFirst(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "a.bin"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &Orchestrator{
+		analyzer: analyzer.New(),
+		engines: map[core.Strategy]core.TransferEngine{
+			"slow":    &fakeEngine{name: "slow", local: true, duration: 2 * time.Second, caps: core.EngineCapabilities{Resume: true}},
+			"fast":    &fakeEngine{name: "fast", local: true, duration: time.Second},
+			"broken":  &fakeEngine{name: "broken", local: true, err: errors.New("no binary")},
+			"remote":  &fakeEngine{name: "remote", duration: time.Millisecond},
+			"missing": &fakeEngine{name: "missing", local: true, duration: 3 * time.Second, caps: core.EngineCapabilities{RequiredBinaries: []string{"difpipe-no-such-binary", "tool (remote)"}}},
+		},
+	}
+
+	analysis, err := o.Compare(context.Background(), &core.TransferOptions{Source: source, Destination: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+
+	rows := analysis.Comparison.Strategies
+	var order []core.Strategy
+	for _, row := range rows {
+		order = append(order, row.Strategy)
+	}
+	want := []core.Strategy{"fast", "slow", "missing", "broken"}
+	if len(order) != len(want) {
+		t.Fatalf("strategies = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("strategies = %v, want %v", order, want)
+		}
+	}
+
+	if !rows[1].Resume {
+		t.Error("slow: resume not reported")
+	}
+	if rows[3].Error != "no binary" {
+		t.Errorf("broken: error = %q", rows[3].Error)
+	}
+	if got := rows[2].MissingBinaries; len(got) != 1 || got[0] != "difpipe-no-such-binary" {
+		t.Errorf("missing binaries = %v, remote binaries must not be checked", got)
+	}
+}
+
+func TestWireBytes(t *testing.T) {
+	if got := wireBytes(1000, 0.8, core.CompressionNone); got != 1000 {
+		t.Errorf("uncompressed = %d, want 1000", got)
+	}
+	if got := wireBytes(1000, 0.5, core.CompressionGzip); got != 650 {
+		t.Errorf("gzip = %d, want 650", got)
+	}
+	if got := wireBytes(1000, 0, core.CompressionGzip); got != 1000 {
+		t.Errorf("incompressible = %d, want 1000", got)
+	}
+}
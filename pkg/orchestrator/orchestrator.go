@@ -6,11 +6,13 @@ import (
 	"time"
 
 	"github.com/larrydiffey/difpipe/pkg/analyzer"
+	"github.com/larrydiffey/difpipe/pkg/benchmark"
 	"github.com/larrydiffey/difpipe/pkg/core"
 	"github.com/larrydiffey/difpipe/pkg/engines/proxy"
 	"github.com/larrydiffey/difpipe/pkg/engines/rclone"
 	"github.com/larrydiffey/difpipe/pkg/engines/rsync"
 	"github.com/larrydiffey/difpipe/pkg/engines/tarstream"
+	"github.com/larrydiffey/difpipe/pkg/status"
 )
 
 // Orchestrator coordinates the transfer process
@@ -18,6 +20,7 @@ type Orchestrator struct {
 	analyzer *analyzer.FileAnalyzer
 	engines  map[core.Strategy]core.TransferEngine
 	progress core.ProgressReporter
+	tracker  *status.Tracker
 }
 
 // New creates a new orchestrator
@@ -42,6 +45,45 @@ func (o *Orchestrator) WithProgress(reporter core.ProgressReporter) *Orchestrato
 	return o
 }
 
+// WithTracker records transfers in a status tracker and uses the recorded
+// history to inform strategy selection
+func (o *Orchestrator) WithTracker(tracker *status.Tracker) *Orchestrator {
+	o.tracker = tracker
+	return o
+}
+
+// WithExplain records every check evaluated during strategy selection
+func (o *Orchestrator) WithExplain(enabled bool) *Orchestrator {
+	o.analyzer.WithExplain(enabled)
+	return o
+}
+
+// WithCache reuses analysis results stored under ~/.difpipe/analysis for
+// unchanged directories. The cache is skipped if it cannot be created.
+func (o *Orchestrator) WithCache(enabled bool) *Orchestrator {
+	if !enabled {
+		o.analyzer.WithCache(nil)
+		return o
+	}
+	if cache, err := analyzer.NewCache(""); err == nil {
+		o.analyzer.WithCache(cache)
+	}
+	return o
+}
+
+// WithDuplicates hashes sampled files during analysis to report duplicates
+func (o *Orchestrator) WithDuplicates(enabled bool) *Orchestrator {
+	o.analyzer.WithDuplicates(enabled)
+	return o
+}
+
+// WithSettleWindow sets how recently a file may have been modified before
+// analysis reports it as possibly still being written
+func (o *Orchestrator) WithSettleWindow(window time.Duration) *Orchestrator {
+	o.analyzer.WithSettleWindow(window)
+	return o
+}
+
 // RegisterEngine registers a transfer engine for a strategy
 func (o *Orchestrator) RegisterEngine(strategy core.Strategy, engine core.TransferEngine) {
 	o.engines[strategy] = engine
@@ -52,20 +94,37 @@ func (o *Orchestrator) Analyze(ctx context.Context, source string) (*core.FileAn
 	return o.analyzer.Analyze(ctx, source)
 }
 
+// AnalyzeTransfer analyzes a source/destination pair, including a
+// destination delta when the analyzer is configured to scan it
+func (o *Orchestrator) AnalyzeTransfer(ctx context.Context, opts *core.TransferOptions) (*core.FileAnalysis, error) {
+	o.configureAnalyzer(opts)
+	return o.analyzer.AnalyzeTransfer(ctx, opts.Source, opts.Destination)
+}
+
 // Transfer performs the complete transfer operation
 func (o *Orchestrator) Transfer(ctx context.Context, opts *core.TransferOptions) (*core.TransferResult, error) {
-	// Apply custom thresholds if provided
-	if opts.Thresholds != nil {
-		o.applyThresholds(opts.Thresholds)
+	o.configureAnalyzer(opts)
+
+	// Only tar streaming writes archives
+	if opts.ArchiveTo != "" {
+		opts.Strategy = core.StrategyTar
 	}
 
 	// Select strategy if auto
 	if opts.Strategy == core.StrategyAuto || opts.Strategy == "" {
-		strategy, err := o.SelectStrategy(ctx, opts.Source, opts.Destination)
+		analysis, err := o.analyzer.AnalyzeTransfer(ctx, opts.Source, opts.Destination)
 		if err != nil {
 			return nil, fmt.Errorf("select strategy: %w", err)
 		}
-		opts.Strategy = strategy
+		if analysis.StagingRule != nil {
+			return o.transferViaStaging(ctx, opts, analysis)
+		}
+		opts.Strategy = analysis.Recommendation
+
+		// Don't spend CPU compressing data that is already compressed
+		if opts.Compression == core.CompressionAuto && analysis.Compression == core.CompressionNone {
+			opts.Compression = core.CompressionNone
+		}
 	}
 
 	// Get engine for strategy
@@ -88,19 +147,106 @@ func (o *Orchestrator) Transfer(ctx context.Context, opts *core.TransferOptions)
 		}
 	}
 
+	// Track the transfer so its performance feeds future selection
+	transferID := generateTransferID()
+	if o.tracker != nil {
+		o.tracker.Register(transferID, opts.Source, opts.Destination, opts.Strategy)
+		o.tracker.Start(transferID)
+	}
+
 	// Perform transfer
 	result, err := engine.Transfer(ctx, opts)
 	if err != nil {
+		if o.tracker != nil {
+			o.tracker.Fail(transferID, err)
+		}
 		return nil, fmt.Errorf("transfer failed: %w", err)
 	}
 
+	if o.tracker != nil {
+		o.tracker.Update(transferID, result.BytesDone, result.BytesTotal, result.FilesDone, result.FilesTotal)
+		o.tracker.Complete(transferID)
+		result.TransferID = transferID
+	}
+
 	return result, nil
 }
 
+// transferViaStaging runs the two legs of a staged transfer: source to the
+// staging location with the rule's strategy, then staging to the destination
+// with whatever strategy is selected for that leg. Each leg only gets the
+// credentials of its own endpoints, and neither stages again. The staged
+// copy is left in place.
+func (o *Orchestrator) transferViaStaging(ctx context.Context, opts *core.TransferOptions, analysis *core.FileAnalysis) (*core.TransferResult, error) {
+	rule := analysis.StagingRule
+	rules := withoutStaging(opts.Rules)
+
+	toStaging := *opts
+	toStaging.Destination = rule.Staging
+	toStaging.Strategy = analysis.Recommendation
+	toStaging.Rules = rules
+	toStaging.Auth = &core.AuthOptions{
+		DestAuth:    rule.StagingAuth,
+		DestJumps:   rule.StagingJumps,
+		DestStaging: true,
+	}
+	if opts.Auth != nil {
+		toStaging.Auth.SourceAuth = opts.Auth.SourceAuth
+		toStaging.Auth.SourceJumps = opts.Auth.SourceJumps
+		toStaging.Auth.SourceStaging = opts.Auth.SourceStaging
+	}
+
+	first, err := o.Transfer(ctx, &toStaging)
+	if err != nil {
+		return nil, fmt.Errorf("transfer to staging %s: %w", rule.Staging, err)
+	}
+
+	fromStaging := *opts
+	fromStaging.Source = rule.Staging
+	fromStaging.Strategy = core.StrategyAuto
+	fromStaging.Rules = rules
+	fromStaging.Auth = &core.AuthOptions{
+		SourceAuth:    rule.StagingAuth,
+		SourceJumps:   rule.StagingJumps,
+		SourceStaging: true,
+	}
+	if opts.Auth != nil {
+		fromStaging.Auth.DestAuth = opts.Auth.DestAuth
+		fromStaging.Auth.DestJumps = opts.Auth.DestJumps
+		fromStaging.Auth.DestStaging = opts.Auth.DestStaging
+	}
+
+	second, err := o.Transfer(ctx, &fromStaging)
+	if err != nil {
+		return nil, fmt.Errorf("transfer from staging %s: %w", rule.Staging, err)
+	}
+
+	result := *second
+	result.Duration = first.Duration + second.Duration
+	result.Message = fmt.Sprintf("Transferred via %s (%s, then %s) per rule %q",
+		rule.Staging, toStaging.Strategy, fromStaging.Strategy, analysis.MatchedRule)
+
+	return &result, nil
+}
+
+// withoutStaging returns the rules that do not stage, so the legs of a
+// staged transfer are never staged again
+func withoutStaging(rules []core.StrategyRule) []core.StrategyRule {
+	var kept []core.StrategyRule
+	for _, rule := range rules {
+		if rule.Staging == "" {
+			kept = append(kept, rule)
+		}
+	}
+	return kept
+}
+
 // Estimate provides transfer estimates without performing the transfer
 func (o *Orchestrator) Estimate(ctx context.Context, opts *core.TransferOptions) (*core.TransferEstimate, error) {
-	// Analyze source
-	analysis, err := o.analyzer.Analyze(ctx, opts.Source)
+	o.configureAnalyzer(opts)
+
+	// Analyze source, and destination when delta scanning is enabled
+	analysis, err := o.analyzer.AnalyzeTransfer(ctx, opts.Source, opts.Destination)
 	if err != nil {
 		return nil, fmt.Errorf("analyze source: %w", err)
 	}
@@ -129,12 +275,17 @@ func (o *Orchestrator) Estimate(ctx context.Context, opts *core.TransferOptions)
 	estimate.Recommendation = analysis.Recommendation
 	estimate.RecommendReason = analysis.RecommendReason
 
-	// Calculate estimated time (rough estimate based on typical speeds)
+	// Only new and changed files need to be sent
+	if analysis.Delta != nil {
+		estimate.BytesTotal = analysis.Delta.TransferBytes()
+		estimate.FilesTotal = analysis.Delta.TransferFiles()
+	}
+
+	// Calculate estimated time from benchmarks, or assume 100 MB/s
 	if estimate.BytesTotal > 0 {
-		// Assume 100 MB/s for estimation
-		estimatedSeconds := float64(estimate.BytesTotal) / (100 * 1024 * 1024)
-		estimate.EstimatedTime = secondsToDuration(estimatedSeconds)
-		estimate.EstimatedSpeed = "~100 MB/s"
+		timing := benchmark.EstimateTransfer(opts.Source, opts.Destination, estimate.BytesTotal, estimate.FilesTotal, 100*1024*1024)
+		estimate.EstimatedTime = timing.Duration
+		estimate.EstimatedSpeed = timing.Speed
 	}
 
 	return estimate, nil
@@ -172,9 +323,23 @@ func (e *EngineNotFoundError) ExitCode() int {
 	return core.ExitEngineNotFound
 }
 
-// secondsToDuration converts seconds to time.Duration
-func secondsToDuration(seconds float64) time.Duration {
-	return time.Duration(seconds * float64(time.Second))
+// configureAnalyzer applies per-transfer settings to the analyzer
+func (o *Orchestrator) configureAnalyzer(opts *core.TransferOptions) {
+	// Apply custom thresholds if provided
+	if opts.Thresholds != nil {
+		o.applyThresholds(opts.Thresholds)
+	}
+
+	o.analyzer.WithDestinationScan(opts.ScanDestination).WithAuth(opts.Auth).WithRules(opts.Rules)
+
+	if o.tracker != nil {
+		o.analyzer.WithHistory(o.tracker.List())
+	}
+}
+
+// generateTransferID creates a unique transfer ID
+func generateTransferID() string {
+	return fmt.Sprintf("transfer-%d", time.Now().UnixNano())
 }
 
 // applyThresholds applies custom thresholds to the analyzer
@@ -0,0 +1,86 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/analyzer"
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// recordingEngine records the options of each transfer it is asked for
+type recordingEngine struct {
+	fakeEngine
+	transfers []core.TransferOptions
+}
+
+func (r *recordingEngine) Transfer(ctx context.Context, opts *core.TransferOptions) (*core.TransferResult, error) {
+	r.transfers = append(r.transfers, *opts)
+	return &core.TransferResult{Success: true}, nil
+}
+
+func TestTransfer_StagingLegsAuth(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	staging := t.TempDir()
+
+	engine := &recordingEngine{}
+	o := &Orchestrator{
+		analyzer: analyzer.New(),
+		engines: map[core.Strategy]core.TransferEngine{
+			core.StrategyRclone: engine,
+			core.StrategyRsync:  engine,
+			core.StrategyTar:    engine,
+			core.StrategyProxy:  engine,
+		},
+	}
+
+	sourceAuth := map[string]interface{}{"password": "source"}
+	destAuth := map[string]interface{}{"password": "destination"}
+	stagingAuth := map[string]interface{}{"key": "~/.ssh/staging"}
+	stagingJumps := []core.JumpHost{{Host: "ops@bastion"}}
+
+	// A rule without conditions matches every leg unless staging rules are
+	// dropped for them
+	_, err := o.Transfer(context.Background(), &core.TransferOptions{
+		Source:      source,
+		Destination: t.TempDir(),
+		Strategy:    core.StrategyAuto,
+		Auth:        &core.AuthOptions{SourceAuth: sourceAuth, DestAuth: destAuth},
+		Rules: []core.StrategyRule{{
+			Name:         "via-staging",
+			Strategy:     core.StrategyTar,
+			Staging:      staging,
+			StagingAuth:  stagingAuth,
+			StagingJumps: stagingJumps,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(engine.transfers) != 2 {
+		t.Fatalf("%d transfers, want the two legs", len(engine.transfers))
+	}
+
+	toStaging := engine.transfers[0]
+	if toStaging.Destination != staging || toStaging.Strategy != core.StrategyTar {
+		t.Errorf("first leg to %s with %s", toStaging.Destination, toStaging.Strategy)
+	}
+	if toStaging.Auth.SourceAuth["password"] != "source" || toStaging.Auth.DestAuth["key"] != "~/.ssh/staging" ||
+		len(toStaging.Auth.DestJumps) != 1 || toStaging.Auth.DestPasswordEnv() != "" || toStaging.Auth.SourcePasswordEnv() == "" {
+		t.Errorf("first leg auth = %+v", toStaging.Auth)
+	}
+
+	fromStaging := engine.transfers[1]
+	if fromStaging.Source != staging || len(fromStaging.Rules) != 0 {
+		t.Errorf("second leg from %s with %d rules", fromStaging.Source, len(fromStaging.Rules))
+	}
+	if fromStaging.Auth.SourceAuth["key"] != "~/.ssh/staging" || fromStaging.Auth.DestAuth["password"] != "destination" ||
+		len(fromStaging.Auth.SourceJumps) != 1 || fromStaging.Auth.SourcePasswordEnv() != "" || fromStaging.Auth.DestPasswordEnv() == "" {
+		t.Errorf("second leg auth = %+v", fromStaging.Auth)
+	}
+}
@@ -54,6 +54,7 @@ func (f *Formatter) Format(data interface{}) error {
 func (f *Formatter) formatJSON(data interface{}) error {
 	encoder := json.NewEncoder(f.writer)
 	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
 	return encoder.Encode(data)
 }
 
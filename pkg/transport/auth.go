@@ -2,8 +2,10 @@ package transport
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -102,16 +104,13 @@ func (a *AgentAuth) SSHAuthMethods() ([]ssh.AuthMethod, error) {
 		return nil, fmt.Errorf("SSH_AUTH_SOCK not set")
 	}
 
-	// Connect to SSH agent
-	conn, err := net.Dial("unix", a.socketPath)
+	signers, err := agentSigners(a.socketPath)
 	if err != nil {
-		return nil, fmt.Errorf("connect to SSH agent: %w", err)
+		return nil, err
 	}
 
-	agentClient := agent.NewClient(conn)
-
 	return []ssh.AuthMethod{
-		ssh.PublicKeysCallback(agentClient.Signers),
+		ssh.PublicKeys(signers...),
 	}, nil
 }
 
@@ -120,6 +119,121 @@ func (a *AgentAuth) String() string {
 	return "agent"
 }
 
+// agentSigners lists the keys of the SSH agent at socketPath. The listing
+// connection is closed, each signer connects again to sign.
+func agentSigners(socketPath string) ([]ssh.Signer, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connect to SSH agent: %w", err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("list SSH agent keys: %w", err)
+	}
+	var signers []ssh.Signer
+	for _, key := range keys {
+		if publicKey, err := ssh.ParsePublicKey(key.Blob); err == nil {
+			signers = append(signers, &agentSigner{socketPath: socketPath, key: publicKey})
+		}
+	}
+	return signers, nil
+}
+
+// agentSigner signs with a key of the SSH agent over a connection of its
+// own, so none is left open once authentication is over
+type agentSigner struct {
+	socketPath string
+	key        ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+// SignWithAlgorithm asks the agent for an RSA SHA-2 signature when the
+// server wants one, the key's own algorithm otherwise
+func (s *agentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	conn, err := net.Dial("unix", s.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connect to SSH agent: %w", err)
+	}
+	defer conn.Close()
+
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	}
+	return agent.NewClient(conn).SignWithFlags(s.key, data, flags)
+}
+
+// IdentityAuth offers the keys of IdentityFile entries from ~/.ssh/config
+// ahead of another method. The SSH client tries each kind of method once,
+// so the keys and those of the SSH agent are offered together.
+type IdentityAuth struct {
+	files []string
+	base  AuthMethod
+}
+
+// NewIdentityAuth creates an identity file authentication method
+func NewIdentityAuth(files []string, base AuthMethod) *IdentityAuth {
+	return &IdentityAuth{files: files, base: base}
+}
+
+// SSHAuthMethods returns the public keys of the identity files and agent,
+// then the methods of the base method. Missing and encrypted identity files
+// are skipped.
+func (a *IdentityAuth) SSHAuthMethods() ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	for _, file := range a.files {
+		keyData, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(keyData); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+
+	var baseMethods []ssh.AuthMethod
+	var baseErr error
+	if a.base != nil {
+		baseMethods, baseErr = a.base.SSHAuthMethods()
+	}
+	if len(signers) == 0 {
+		return baseMethods, baseErr
+	}
+
+	methods := []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		if socketPath := os.Getenv("SSH_AUTH_SOCK"); socketPath != "" {
+			if keys, err := agentSigners(socketPath); err == nil {
+				return append(signers, keys...), nil
+			}
+		}
+		return signers, nil
+	})}
+	if baseErr == nil {
+		methods = append(methods, baseMethods...)
+	}
+	return methods, nil
+}
+
+// String returns a string representation
+func (a *IdentityAuth) String() string {
+	if a.base == nil {
+		return fmt.Sprintf("identity(%s)", strings.Join(a.files, ","))
+	}
+	return fmt.Sprintf("identity(%s)+%s", strings.Join(a.files, ","), a.base)
+}
+
 // MultiAuth combines multiple authentication methods
 type MultiAuth struct {
 	methods []AuthMethod
@@ -155,6 +269,24 @@ func (a *MultiAuth) String() string {
 	return fmt.Sprintf("multi(%d methods)", len(a.methods))
 }
 
+// ResolveAuth selects the authentication for an endpoint. A password in the
+// given environment variable wins, then the endpoint's auth config, and
+// finally the SSH agent.
+func ResolveAuth(authConfig map[string]interface{}, passwordEnv string) (AuthMethod, error) {
+	if passwordEnv != "" {
+		if password := os.Getenv(passwordEnv); password != "" {
+			return NewPasswordAuth(password), nil
+		}
+	}
+
+	if len(authConfig) > 0 {
+		return AuthFromConfig(authConfig)
+	}
+
+	// Try default authentication methods
+	return NewMultiAuth(NewAgentAuth()), nil
+}
+
 // AuthFromConfig creates an AuthMethod from configuration
 func AuthFromConfig(authConfig map[string]interface{}) (AuthMethod, error) {
 	if authConfig == nil {
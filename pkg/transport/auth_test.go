@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testAgent serves a keyring holding the keys on a unix socket and counts
+// the connections still open
+func testAgent(t *testing.T, keys ...interface{}) (string, *atomic.Int32) {
+	t.Helper()
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		listener.Close()
+		wg.Wait()
+	})
+
+	open := &atomic.Int32{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			open.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer open.Add(-1)
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return socketPath, open
+}
+
+// waitClosed waits for the agent's connections to be closed
+func waitClosed(t *testing.T, open *atomic.Int32) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); open.Load() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d agent connections left open", open.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAgentSigners_CloseConnections(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketPath, open := testAgent(t, edKey, rsaKey)
+
+	signers, err := agentSigners(socketPath)
+	if err != nil {
+		t.Fatalf("agentSigners: %v", err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("got %d signers, want 2", len(signers))
+	}
+	waitClosed(t, open)
+
+	data := []byte("session")
+	for _, signer := range signers {
+		algorithms := []string{""}
+		if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512)
+		}
+		for _, algorithm := range algorithms {
+			signature, err := signer.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, data, algorithm)
+			if err != nil {
+				t.Fatalf("sign with %s %q: %v", signer.PublicKey().Type(), algorithm, err)
+			}
+			if algorithm != "" && signature.Format != algorithm {
+				t.Errorf("signature format = %s, want %s", signature.Format, algorithm)
+			}
+			if err := signer.PublicKey().Verify(data, signature); err != nil {
+				t.Errorf("verify %s signature: %v", signature.Format, err)
+			}
+		}
+	}
+	waitClosed(t, open)
+}
+
+func TestIdentityAuth_ClosesAgentConnection(t *testing.T) {
+	_, agentKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketPath, open := testAgent(t, agentKey)
+	t.Setenv("SSH_AUTH_SOCK", socketPath)
+
+	_, fileKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(fileKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server accepts only the agent's key, so both keys are offered
+	agentPublic, _ := ssh.NewPublicKey(agentKey.Public())
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(agentPublic.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	hostKey, err := ssh.NewSignerFromKey(fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AddHostKey(hostKey)
+
+	methods, err := NewIdentityAuth([]string{file}, nil).SSHAuthMethods()
+	if err != nil {
+		t.Fatalf("SSHAuthMethods: %v", err)
+	}
+	port := testSSHServer(t, config)
+	client, err := ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            "test",
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("log in with the agent's key: %v", err)
+	}
+	client.Close()
+	waitClosed(t, open)
+}
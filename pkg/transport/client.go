@@ -3,16 +3,22 @@ package transport
 import (
 	"context"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
 )
 
 // SSHClient wraps an SSH client with additional management features
 type SSHClient struct {
 	config        *SSHConfig
 	client        *ssh.Client
+	jumps         []*ssh.Client // Connections to the jump hosts, in order
 	keepaliveStop chan struct{}
 	keepaliveDone chan struct{}
 	mutex         sync.Mutex
@@ -27,6 +33,16 @@ type SSHConfig struct {
 	Timeout           time.Duration
 	Keepalive         time.Duration
 	HostKeyCallback   ssh.HostKeyCallback
+	Jumps             []*SSHConfig // Jump hosts, each dialed through the one before
+	Exclusive         bool         // Asks a Pool for a connection no other caller shares
+	hostKeys          *KnownHosts  // Verifier behind the default HostKeyCallback
+}
+
+// WithExclusive asks a Pool for a connection of the config's own, as a
+// stream run in parallel with others to the host needs
+func (c *SSHConfig) WithExclusive() *SSHConfig {
+	c.Exclusive = true
+	return c
 }
 
 // Validate checks if the configuration is valid
@@ -52,9 +68,14 @@ func (c *SSHConfig) Validate() error {
 	}
 
 	if c.HostKeyCallback == nil {
-		// Use insecure callback for MVP
-		// TODO: Replace with proper host key verification
-		c.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		c.hostKeys = defaultHostKeys()
+		c.HostKeyCallback = c.hostKeys.HostKeyCallback()
+	}
+
+	for i, jump := range c.Jumps {
+		if err := jump.Validate(); err != nil {
+			return fmt.Errorf("jump host %d: %w", i+1, err)
+		}
 	}
 
 	return nil
@@ -124,8 +145,28 @@ func (c *SSHClient) stopKeepalive() {
 	}
 }
 
-// ParseRemotePath parses a remote path in the format user@host:path
+// ParseRemotePath parses a remote path in the format user@host:path, or
+// host:path for hosts named in ~/.ssh/config. The host's settings there
+// (HostName, Port, User, IdentityFile, ProxyJump, ConnectTimeout) apply.
 func ParseRemotePath(remotePath string) (*RemoteLocation, error) {
+	return parseRemotePath(remotePath, UserSSHConfig())
+}
+
+// IsRemotePath reports whether a path names a remote location, as
+// user@host:path or as host:path for a host named in ~/.ssh/config
+func IsRemotePath(path string) bool {
+	if strings.Contains(path, "://") {
+		return false
+	}
+	if strings.Contains(path, "@") && strings.Contains(path, ":") {
+		return true
+	}
+	host, _, found := strings.Cut(path, ":")
+	return found && host != "" && !strings.Contains(host, "/") && UserSSHConfig().HasHost(host)
+}
+
+// parseRemotePath parses a remote path with the given ssh config
+func parseRemotePath(remotePath string, sshConfig *SSHConfigFile) (*RemoteLocation, error) {
 	// Expected format: user@host:path or user@host:/path
 	var location RemoteLocation
 
@@ -138,11 +179,23 @@ func ParseRemotePath(remotePath string) (*RemoteLocation, error) {
 		}
 	}
 
-	if atIndex == -1 {
-		return nil, fmt.Errorf("invalid remote path format: expected user@host:path, got %s", remotePath)
+	// An @ after the first : is part of the path
+	if colonIndex := strings.IndexByte(remotePath, ':'); colonIndex != -1 && colonIndex < atIndex {
+		atIndex = -1
 	}
 
-	location.User = remotePath[:atIndex]
+	if atIndex == -1 {
+		// host:path is only remote for hosts in the ssh config
+		host, _, found := strings.Cut(remotePath, ":")
+		if !found || !sshConfig.HasHost(host) {
+			return nil, fmt.Errorf("invalid remote path format: expected user@host:path, got %s", remotePath)
+		}
+	} else {
+		location.User = remotePath[:atIndex]
+		if location.User == "" {
+			return nil, fmt.Errorf("user is empty")
+		}
+	}
 
 	// Find : separator
 	colonIndex := -1
@@ -159,11 +212,6 @@ func ParseRemotePath(remotePath string) (*RemoteLocation, error) {
 
 	location.Host = remotePath[atIndex+1 : colonIndex]
 	location.Path = remotePath[colonIndex+1:]
-	location.Port = 22 // Default SSH port
-
-	if location.User == "" {
-		return nil, fmt.Errorf("user is empty")
-	}
 
 	if location.Host == "" {
 		return nil, fmt.Errorf("host is empty")
@@ -173,15 +221,61 @@ func ParseRemotePath(remotePath string) (*RemoteLocation, error) {
 		return nil, fmt.Errorf("path is empty")
 	}
 
+	settings := location.applySSHConfig(sshConfig)
+	if location.User == "" {
+		return nil, fmt.Errorf("user is empty")
+	}
+
+	// Hops of a ProxyJump log in with the agent and their IdentityFiles
+	if settings.ProxyJump != "" && settings.ProxyJump != "none" {
+		for _, hop := range strings.Split(settings.ProxyJump, ",") {
+			jump, err := parseJumpHost(hop, sshConfig, true)
+			if err != nil {
+				return nil, fmt.Errorf("ProxyJump of %s: %w", location.Host, err)
+			}
+			auth, _ := ResolveAuth(nil, "")
+			location.Jumps = append(location.Jumps, jump.SSHConfig(auth))
+		}
+	}
+
 	return &location, nil
 }
 
+// applySSHConfig fills in the settings the ssh config has for the
+// location's host. A user or port given explicitly wins; hosts named in the
+// config without a User log in as the local user, like ssh. The port
+// defaults to 22.
+func (r *RemoteLocation) applySSHConfig(sshConfig *SSHConfigFile) SSHHostSettings {
+	settings := sshConfig.Lookup(r.Host)
+	if r.User == "" {
+		r.User = settings.User
+		if r.User == "" && sshConfig.HasHost(r.Host) {
+			r.User = localUser()
+		}
+	}
+	if settings.HostName != "" {
+		r.Host = settings.HostName
+	}
+	if r.Port == 0 {
+		r.Port = settings.Port
+	}
+	if r.Port == 0 {
+		r.Port = 22 // Default SSH port
+	}
+	r.IdentityFiles = settings.IdentityFiles
+	r.Timeout = settings.ConnectTimeout
+	return settings
+}
+
 // RemoteLocation represents a parsed remote location
 type RemoteLocation struct {
-	User string
-	Host string
-	Port int
-	Path string
+	User          string
+	Host          string
+	Port          int
+	Path          string
+	Jumps         []*SSHConfig  // Jump hosts to connect through
+	IdentityFiles []string      // Keys from ~/.ssh/config, offered along with the auth method
+	Timeout       time.Duration // Connect timeout from ~/.ssh/config, 0 for the default
 }
 
 // String returns a string representation of the remote location
@@ -191,19 +285,103 @@ func (r *RemoteLocation) String() string {
 
 // SSHConfig returns an SSHConfig for this location
 func (r *RemoteLocation) SSHConfig(auth AuthMethod) *SSHConfig {
+	timeout := 30 * time.Second
+	if r.Timeout > 0 {
+		timeout = r.Timeout
+	}
+	if len(r.IdentityFiles) > 0 {
+		auth = NewIdentityAuth(r.IdentityFiles, auth)
+	}
 	return &SSHConfig{
 		Host:      r.Host,
 		Port:      r.Port,
 		User:      r.User,
 		Auth:      auth,
-		Timeout:   30 * time.Second,
+		Timeout:   timeout,
 		Keepalive: 30 * time.Second,
+		Jumps:     r.Jumps,
+	}
+}
+
+// ResolveJumps sets the jump hosts the location is reached through.
+// Explicit jump hosts replace a ProxyJump from ~/.ssh/config.
+func (r *RemoteLocation) ResolveJumps(jumps []core.JumpHost) error {
+	if len(jumps) == 0 {
+		return nil
+	}
+	configs, err := ResolveJumps(jumps)
+	if err != nil {
+		return err
+	}
+	r.Jumps = configs
+	return nil
+}
+
+// ParseJumpHost parses a jump host in the format user@host or
+// user@host:port. The user may be left out for hosts named in
+// ~/.ssh/config, whose settings apply.
+func ParseJumpHost(spec string) (*RemoteLocation, error) {
+	return parseJumpHost(spec, UserSSHConfig(), false)
+}
+
+// parseJumpHost parses a jump host with the given ssh config. Hops of a
+// ProxyJump may leave out the user for any host and log in as the local
+// user, like ssh.
+func parseJumpHost(spec string, sshConfig *SSHConfigFile, proxyJump bool) (*RemoteLocation, error) {
+	user, address, found := strings.Cut(spec, "@")
+	if !found {
+		user, address = "", spec
+	} else if user == "" {
+		return nil, fmt.Errorf("invalid jump host: expected user@host[:port], got %s", spec)
+	}
+
+	location := &RemoteLocation{User: user, Host: address}
+	if strings.Contains(address, ":") {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host %s: %w", spec, err)
+		}
+		location.Host = host
+		if location.Port, err = strconv.Atoi(port); err != nil || location.Port < 1 || location.Port > 65535 {
+			return nil, fmt.Errorf("invalid jump host %s: bad port %s", spec, port)
+		}
+	}
+	if location.Host == "" {
+		return nil, fmt.Errorf("invalid jump host %s: host is empty", spec)
+	}
+
+	location.applySSHConfig(sshConfig)
+	if location.User == "" && proxyJump {
+		location.User = localUser()
+	}
+	if location.User == "" {
+		return nil, fmt.Errorf("invalid jump host: expected user@host[:port], got %s", spec)
+	}
+	return location, nil
+}
+
+// ResolveJumps builds the SSH configs of a chain of jump hosts. Each
+// authenticates with its own auth config, or the agent and default keys.
+func ResolveJumps(jumps []core.JumpHost) ([]*SSHConfig, error) {
+	var configs []*SSHConfig
+	for _, jump := range jumps {
+		location, err := ParseJumpHost(jump.Host)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := ResolveAuth(jump.Auth, "")
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %w", jump.Host, err)
+		}
+		configs = append(configs, location.SSHConfig(auth))
 	}
+	return configs, nil
 }
 
 // GetFileSize gets the size of a remote file using stat
 func GetFileSize(ctx context.Context, transport Transport, client *SSHClient, path string) (int64, error) {
-	cmd := fmt.Sprintf("stat -c%%s %s 2>/dev/null || stat -f%%z %s", path, path)
+	quoted := QuoteRemotePath(path)
+	cmd := fmt.Sprintf("stat -c%%s %s 2>/dev/null || stat -f%%z %s", quoted, quoted)
 
 	result, err := transport.ExecuteCommand(ctx, client, cmd)
 	if err != nil {
@@ -222,3 +400,21 @@ func GetFileSize(ctx context.Context, transport Transport, client *SSHClient, pa
 
 	return size, nil
 }
+
+// QuoteRemotePath quotes a path for use in a remote shell command. A leading
+// "~/" is left unquoted so the remote shell still expands it to the home
+// directory.
+func QuoteRemotePath(path string) string {
+	if path == "~" {
+		return path
+	}
+	if strings.HasPrefix(path, "~/") {
+		return "~/" + ShellQuote(path[2:])
+	}
+	return ShellQuote(path)
+}
+
+// ShellQuote quotes a string for safe use as a single POSIX shell word
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
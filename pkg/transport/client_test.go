@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func TestParseJumpHost(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // No ~/.ssh/config
+	tests := []struct {
+		spec string
+		user string
+		host string
+		port int
+	}{
+		{"ops@bastion.example.com", "ops", "bastion.example.com", 22},
+		{"ops@bastion.example.com:2222", "ops", "bastion.example.com", 2222},
+		{"root@[2001:db8::1]:22", "root", "2001:db8::1", 22},
+	}
+	for _, tt := range tests {
+		location, err := ParseJumpHost(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		if location.User != tt.user || location.Host != tt.host || location.Port != tt.port {
+			t.Errorf("%s: got %s@%s:%d", tt.spec, location.User, location.Host, location.Port)
+		}
+	}
+
+	for _, spec := range []string{"bastion", "@bastion", "ops@", "ops@bastion:ssh", "ops@bastion:0"} {
+		if _, err := ParseJumpHost(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestResolveJumps(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	configs, err := ResolveJumps([]core.JumpHost{
+		{Host: "ops@bastion:2222", Auth: map[string]interface{}{"password": "secret"}},
+		{Host: "root@inner", Auth: map[string]interface{}{"key": "/keys/inner"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("got %d configs, want 2", len(configs))
+	}
+	if configs[0].Host != "bastion" || configs[0].Port != 2222 || configs[0].Auth.String() != "password" {
+		t.Errorf("first hop = %s:%d with %s", configs[0].Host, configs[0].Port, configs[0].Auth)
+	}
+	if configs[1].Host != "inner" || configs[1].Auth.String() != "key(/keys/inner)" {
+		t.Errorf("second hop = %s with %s", configs[1].Host, configs[1].Auth)
+	}
+
+	location := &RemoteLocation{User: "root", Host: "db1", Port: 22, Path: "/data", Jumps: configs}
+	if got := location.SSHConfig(NewPasswordAuth("x")).Jumps; len(got) != 2 {
+		t.Errorf("SSH config has %d jump hosts, want 2", len(got))
+	}
+}
@@ -0,0 +1,263 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+// HostKeyPolicy selects how the keys presented by SSH hosts are verified
+type HostKeyPolicy string
+
+const (
+	// HostKeyStrict only accepts hosts listed in a known_hosts file
+	HostKeyStrict HostKeyPolicy = "strict"
+
+	// HostKeyTOFU trusts unknown hosts on first use and records their keys,
+	// rejecting keys that differ from those on record
+	HostKeyTOFU HostKeyPolicy = "tofu"
+
+	// HostKeyInsecure accepts any host key
+	HostKeyInsecure HostKeyPolicy = "insecure"
+)
+
+// ParseHostKeyPolicy parses a policy name; an empty name is tofu
+func ParseHostKeyPolicy(name string) (HostKeyPolicy, error) {
+	switch policy := HostKeyPolicy(name); policy {
+	case "":
+		return HostKeyTOFU, nil
+	case HostKeyStrict, HostKeyTOFU, HostKeyInsecure:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid host key policy %q: expected strict, tofu or insecure", name)
+	}
+}
+
+// KnownHosts verifies host keys against the user's known_hosts file and
+// difpipe's own, where trust-on-first-use records new keys
+type KnownHosts struct {
+	Policy   HostKeyPolicy
+	UserFile string // ~/.ssh/known_hosts, only read
+	File     string // ~/.difpipe/known_hosts, new keys are appended here
+	mutex    sync.Mutex
+}
+
+// NewKnownHosts creates a verifier using the default known_hosts files
+func NewKnownHosts(policy HostKeyPolicy) *KnownHosts {
+	k := &KnownHosts{Policy: policy}
+	if home, err := os.UserHomeDir(); err == nil {
+		k.UserFile = filepath.Join(home, ".ssh", "known_hosts")
+		k.File = filepath.Join(home, ".difpipe", "known_hosts")
+	}
+	return k
+}
+
+// HostKeyCallback returns the callback checking host keys under the policy
+func (k *KnownHosts) HostKeyCallback() ssh.HostKeyCallback {
+	if k.Policy == HostKeyInsecure {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return k.check
+}
+
+// check verifies the key of a host, recording it if the host is new and
+// the policy trusts on first use
+func (k *KnownHosts) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	// Checks are serialized and the files read each time, so a key recorded
+	// for one connection is seen by the next when parallel connections reach
+	// a new host together
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	callback, err := k.load()
+	if err != nil {
+		return err
+	}
+	if callback != nil {
+		err = callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		var revokedErr *knownhosts.RevokedError
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &revokedErr):
+			return &HostKeyError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Revoked: true}
+		case !errors.As(err, &keyErr):
+			return err
+		case len(keyErr.Want) > 0:
+			return &HostKeyError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Known: keyErr.Want}
+		}
+	}
+
+	if k.Policy != HostKeyTOFU {
+		return &HostKeyError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key)}
+	}
+	return k.record(hostname, key)
+}
+
+// HostKeyAlgorithms returns the algorithms of the keys on record for a
+// host, which ssh also limits the handshake to. It returns nil when the
+// host is unknown or any key is accepted.
+func (k *KnownHosts) HostKeyAlgorithms(hostname string) ([]string, error) {
+	if k.Policy == HostKeyInsecure {
+		return nil, nil
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	callback, err := k.load()
+	if err != nil || callback == nil {
+		return nil, err
+	}
+
+	// No key matches an empty one, so the keys on record come back in the
+	// error
+	var keyErr *knownhosts.KeyError
+	if err := callback(hostname, &net.TCPAddr{}, emptyKey{}); !errors.As(err, &keyErr) {
+		return nil, nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, known := range keyErr.Want {
+		for _, algorithm := range keyAlgorithms(known.Key.Type()) {
+			if !seen[algorithm] {
+				seen[algorithm] = true
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms, nil
+}
+
+// keyAlgorithms returns the signature algorithms of a key type
+func keyAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	default:
+		return []string{keyType}
+	}
+}
+
+// emptyKey stands in for a host's key when looking up the keys on record
+type emptyKey struct{}
+
+func (emptyKey) Type() string                        { return "" }
+func (emptyKey) Marshal() []byte                     { return nil }
+func (emptyKey) Verify([]byte, *ssh.Signature) error { return errors.New("empty key") }
+
+// load reads the known_hosts files that exist, returning a nil callback
+// when there are none. The mutex must be held.
+func (k *KnownHosts) load() (ssh.HostKeyCallback, error) {
+	var files []string
+	for _, file := range []string{k.UserFile, k.File} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("read known hosts: %w", err)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// record appends the key of a new host to difpipe's known_hosts file
+func (k *KnownHosts) record(hostname string, key ssh.PublicKey) error {
+	if k.File == "" {
+		return fmt.Errorf("record host key for %s: no known_hosts file", hostname)
+	}
+	if err := os.MkdirAll(filepath.Dir(k.File), 0700); err != nil {
+		return fmt.Errorf("record host key: %w", err)
+	}
+
+	file, err := os.OpenFile(k.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("record host key: %w", err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		file.Close()
+		return fmt.Errorf("record host key: %w", err)
+	}
+	return file.Close()
+}
+
+// HostKeyError is returned when a host's key is unknown, revoked or
+// differs from the one on record
+type HostKeyError struct {
+	Host        string
+	Fingerprint string                // SHA256 fingerprint of the key the host presented
+	Known       []knownhosts.KnownKey // Keys on record for the host, none when it is unknown
+	Revoked     bool
+}
+
+func (e *HostKeyError) Error() string {
+	switch {
+	case e.Revoked:
+		return fmt.Sprintf("host key verification failed for %s: key %s is revoked", e.Host, e.Fingerprint)
+	case len(e.Known) > 0:
+		known := e.Known[0]
+		return fmt.Sprintf("host key verification failed for %s: key %s does not match the key at %s:%d, the host may be impersonated",
+			e.Host, e.Fingerprint, known.Filename, known.Line)
+	default:
+		return fmt.Sprintf("host key verification failed for %s: unknown host (key %s), add it to known_hosts or use --host-key-policy tofu",
+			e.Host, e.Fingerprint)
+	}
+}
+
+// ExitCode reports host key failures as authentication errors
+func (e *HostKeyError) ExitCode() int {
+	return core.ExitAuthError
+}
+
+var (
+	hostKeyMutex sync.Mutex
+	hostKeys     *KnownHosts // Verifies hosts of configs without a HostKeyCallback
+)
+
+// SetHostKeyPolicy sets the policy of connections whose config has no
+// HostKeyCallback
+func SetHostKeyPolicy(policy HostKeyPolicy) {
+	hostKeyMutex.Lock()
+	defer hostKeyMutex.Unlock()
+	hostKeys = NewKnownHosts(policy)
+}
+
+// DefaultHostKeyPolicy returns the policy of connections whose config has
+// no HostKeyCallback, tofu unless set
+func DefaultHostKeyPolicy() HostKeyPolicy {
+	return defaultHostKeys().Policy
+}
+
+// defaultHostKeys returns the verifier shared by connections without a
+// HostKeyCallback
+func defaultHostKeys() *KnownHosts {
+	hostKeyMutex.Lock()
+	defer hostKeyMutex.Unlock()
+	if hostKeys == nil {
+		hostKeys = NewKnownHosts(HostKeyTOFU)
+	}
+	return hostKeys
+}
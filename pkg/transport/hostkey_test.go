@@ -0,0 +1,212 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/larrydiffey/difpipe/pkg/core"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testKnownHosts(t *testing.T, policy HostKeyPolicy) *KnownHosts {
+	dir := t.TempDir()
+	return &KnownHosts{
+		Policy:   policy,
+		UserFile: filepath.Join(dir, "ssh_known_hosts"),
+		File:     filepath.Join(dir, "difpipe", "known_hosts"),
+	}
+}
+
+func TestKnownHosts_TOFU(t *testing.T) {
+	known := testKnownHosts(t, HostKeyTOFU)
+	callback := known.HostKeyCallback()
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 2222}
+	key := newHostKey(t)
+
+	// First use records the key, later connections accept it
+	if err := callback("server:2222", remote, key); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := callback("server:2222", remote, key); err != nil {
+		t.Fatalf("known key: %v", err)
+	}
+	data, err := os.ReadFile(known.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := knownhosts.Line([]string{"[server]:2222"}, key) + "\n"; string(data) != want {
+		t.Errorf("recorded %q, want %q", data, want)
+	}
+
+	// A different key for the same host is rejected
+	err = callback("server:2222", remote, newHostKey(t))
+	var hostKeyErr *HostKeyError
+	if !errors.As(err, &hostKeyErr) || len(hostKeyErr.Known) != 1 {
+		t.Fatalf("changed key: got %v", err)
+	}
+	if hostKeyErr.ExitCode() != core.ExitAuthError {
+		t.Errorf("exit code %d", hostKeyErr.ExitCode())
+	}
+}
+
+func TestKnownHosts_Strict(t *testing.T) {
+	known := testKnownHosts(t, HostKeyStrict)
+	callback := known.HostKeyCallback()
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 22}
+	key := newHostKey(t)
+
+	// Unknown hosts are rejected and not recorded
+	err := callback("server:22", remote, key)
+	var hostKeyErr *HostKeyError
+	if !errors.As(err, &hostKeyErr) || len(hostKeyErr.Known) != 0 {
+		t.Fatalf("unknown host: got %v", err)
+	}
+	if _, err := os.Stat(known.File); !os.IsNotExist(err) {
+		t.Errorf("strict policy recorded a key")
+	}
+
+	// Hosts in the user's known_hosts are accepted
+	line := knownhosts.Line([]string{"server"}, key) + "\n"
+	if err := os.WriteFile(known.UserFile, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("server:22", remote, key); err != nil {
+		t.Errorf("known host: %v", err)
+	}
+}
+
+func TestParseHostKeyPolicy(t *testing.T) {
+	for name, want := range map[string]HostKeyPolicy{"": HostKeyTOFU, "strict": HostKeyStrict, "insecure": HostKeyInsecure} {
+		if got, err := ParseHostKeyPolicy(name); err != nil || got != want {
+			t.Errorf("ParseHostKeyPolicy(%q) = %s, %v", name, got, err)
+		}
+	}
+	if _, err := ParseHostKeyPolicy("ask"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+// testSSHServer accepts SSH connections with the config and returns its
+// port
+func testSSHServer(t *testing.T, config *ssh.ServerConfig) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					newChannel.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestKnownHosts_NonPreferredKeyType(t *testing.T) {
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSigner, err := ssh.NewSignerFromKey(ecdsaPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The host also has an ECDSA key, which is preferred by default, but
+	// only its ed25519 key is on record
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(ecdsaSigner)
+	config.AddHostKey(edSigner)
+	port := testSSHServer(t, config)
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	known := testKnownHosts(t, HostKeyStrict)
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, edSigner.PublicKey()) + "\n"
+	if err := os.WriteFile(known.UserFile, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	algorithms, err := known.HostKeyAlgorithms(address)
+	if err != nil || !reflect.DeepEqual(algorithms, []string{ssh.KeyAlgoED25519}) {
+		t.Errorf("algorithms = %v, %v, want ed25519 only", algorithms, err)
+	}
+	if algorithms, err := known.HostKeyAlgorithms("other:22"); err != nil || algorithms != nil {
+		t.Errorf("unknown host algorithms = %v, %v, want none", algorithms, err)
+	}
+
+	sshConfig := &SSHConfig{
+		Host:            "127.0.0.1",
+		Port:            port,
+		User:            "test",
+		Auth:            NewPasswordAuth("unused"),
+		Timeout:         5 * time.Second,
+		HostKeyCallback: known.HostKeyCallback(),
+		hostKeys:        known,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := dial(ctx, nil, sshConfig)
+	if err != nil {
+		t.Fatalf("dial host known by its ed25519 key: %v", err)
+	}
+	client.Close()
+}
+
+func TestKeyAlgorithms(t *testing.T) {
+	want := []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	if got := keyAlgorithms(ssh.KeyAlgoRSA); !reflect.DeepEqual(got, want) {
+		t.Errorf("RSA algorithms = %v, want %v", got, want)
+	}
+	if got := keyAlgorithms(ssh.KeyAlgoECDSA256); !reflect.DeepEqual(got, []string{ssh.KeyAlgoECDSA256}) {
+		t.Errorf("ECDSA algorithms = %v", got)
+	}
+}
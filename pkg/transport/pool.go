@@ -0,0 +1,478 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	// DefaultMaxSessions is how many sessions a pool runs on one host at
+	// once, the default of OpenSSH's MaxSessions
+	DefaultMaxSessions = 10
+
+	// DefaultIdleTimeout is how long a pooled connection stays open unused
+	DefaultIdleTimeout = time.Minute
+
+	// poolSweepInterval is how often idle connections are looked for
+	poolSweepInterval = 15 * time.Second
+)
+
+// Pool is a Transport that shares SSH connections between engines and
+// workers. Callers connecting with the same user, host, port and jump hosts
+// share a connection, each running sessions of its own on it, and Close
+// hands a caller's lease back; call it once per Connect. A connection
+// nobody holds stays open for the idle timeout. Connections are checked
+// with IsConnected before they are handed out again, and a connection
+// handed back by its last holder with sessions still open is closed, which
+// ends those sessions.
+//
+// Sessions (commands, streams and SFTP, and the session IsConnected opens)
+// to one host are limited across its connections; further ones wait until
+// a session ends or their context is done. Callers share the connection
+// with the fewest holders. Configs marked Exclusive get a connection no
+// other caller shares, so parallel streams keep separate TCP connections.
+type Pool struct {
+	transport   Transport
+	maxSessions int
+	idleTimeout time.Duration
+	healthy     func(*SSHClient) bool // Checks connections before they are handed out
+
+	mutex    sync.Mutex
+	clients  map[*SSHClient]*pooledClient
+	slots    map[string]chan struct{} // Session slots by host
+	sweeping bool
+	stop     chan struct{}
+	shutdown bool
+}
+
+// pooledClient is a connection owned by the pool
+type pooledClient struct {
+	key       string
+	host      string
+	leases    int  // Callers holding the connection
+	exclusive bool // Held by a caller that doesn't share it
+	lastUsed  time.Time
+	open      map[*poolSession]bool // Sessions not yet ended
+}
+
+// poolSession holds a session slot of a host for a connection
+type poolSession struct {
+	pool   *Pool
+	client *SSHClient
+	slots  chan struct{}
+	once   sync.Once
+}
+
+// release frees the slot; later calls do nothing
+func (s *poolSession) release() {
+	s.once.Do(func() {
+		<-s.slots
+		s.pool.mutex.Lock()
+		if pooled, ok := s.pool.clients[s.client]; ok {
+			delete(pooled.open, s)
+		}
+		s.pool.mutex.Unlock()
+	})
+}
+
+// NewPool creates a pool opening connections with the given transport
+func NewPool(transport Transport) *Pool {
+	return &Pool{
+		transport:   transport,
+		maxSessions: DefaultMaxSessions,
+		idleTimeout: DefaultIdleTimeout,
+		healthy:     (*SSHClient).IsConnected,
+		clients:     make(map[*SSHClient]*pooledClient),
+		slots:       make(map[string]chan struct{}),
+		stop:        make(chan struct{}),
+	}
+}
+
+// WithMaxSessions sets how many sessions run on one host at once, which
+// must not exceed the hosts' MaxSessions
+func (p *Pool) WithMaxSessions(n int) *Pool {
+	if n > 0 {
+		p.maxSessions = n
+	}
+	return p
+}
+
+// MaxSessions returns how many sessions run on one host at once
+func (p *Pool) MaxSessions() int {
+	return p.maxSessions
+}
+
+// WithIdleTimeout sets how long unused connections stay open
+func (p *Pool) WithIdleTimeout(d time.Duration) *Pool {
+	if d > 0 {
+		p.idleTimeout = d
+	}
+	return p
+}
+
+var (
+	sharedPoolOnce sync.Once
+	sharedPool     *Pool
+)
+
+// Shared returns the pool shared by everything in the process
+func Shared() *Pool {
+	sharedPoolOnce.Do(func() {
+		sharedPool = NewPool(New())
+	})
+	return sharedPool
+}
+
+// poolKey identifies the connections a config can reuse
+func poolKey(config *SSHConfig) string {
+	var b strings.Builder
+	for _, jump := range config.Jumps {
+		b.WriteString(poolKey(jump))
+		b.WriteString(" > ")
+	}
+	fmt.Fprintf(&b, "%s@%s", config.User, hostKey(config))
+	return b.String()
+}
+
+// hostKey identifies a host
+func hostKey(config *SSHConfig) string {
+	return config.Host + ":" + strconv.Itoa(config.Port)
+}
+
+// Connect leases a connection to the host, shared with other callers
+// unless the config is Exclusive, or opens a new one
+func (p *Pool) Connect(ctx context.Context, config *SSHConfig) (*SSHClient, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	p.evictIdle()
+
+	key := poolKey(config)
+	for {
+		client := p.lease(key, config.Exclusive)
+		if client == nil {
+			break
+		}
+		healthy, err := p.checkHealth(ctx, client)
+		if err != nil {
+			p.Close(client)
+			return nil, err
+		}
+		if healthy {
+			return client, nil
+		}
+		p.discard(client)
+	}
+
+	client, err := p.transport.Connect(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.clients[client] = &pooledClient{
+		key:       key,
+		host:      hostKey(config),
+		leases:    1,
+		exclusive: config.Exclusive,
+		open:      make(map[*poolSession]bool),
+	}
+	if !p.sweeping && !p.shutdown {
+		p.sweeping = true
+		go p.sweep()
+	}
+	return client, nil
+}
+
+// lease leases the connection for key with the fewest holders, then the
+// fewest sessions open, then the most recently used. An exclusive lease
+// only takes a connection nobody holds.
+func (p *Pool) lease(key string, exclusive bool) *SSHClient {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var found *SSHClient
+	for client, pooled := range p.clients {
+		if pooled.key != key || pooled.exclusive {
+			continue
+		}
+		if exclusive && pooled.leases > 0 {
+			continue
+		}
+		if found != nil && !p.clients[found].busierThan(pooled) {
+			continue
+		}
+		found = client
+	}
+	if found != nil {
+		p.clients[found].leases++
+		p.clients[found].exclusive = exclusive
+	}
+	return found
+}
+
+// busierThan reports whether a connection is less suited to another lease
+// than other
+func (c *pooledClient) busierThan(other *pooledClient) bool {
+	if c.leases != other.leases {
+		return c.leases > other.leases
+	}
+	if len(c.open) != len(other.open) {
+		return len(c.open) > len(other.open)
+	}
+	return !c.lastUsed.After(other.lastUsed)
+}
+
+// checkHealth checks a leased connection with the healthy function, which
+// opens a session, in a session slot of its host
+func (p *Pool) checkHealth(ctx context.Context, client *SSHClient) (bool, error) {
+	release, err := p.acquireSession(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	return p.healthy(client), nil
+}
+
+// discard drops the lease on a dead connection and forgets it. Other
+// holders close it when they hand it back.
+func (p *Pool) discard(client *SSHClient) {
+	p.mutex.Lock()
+	pooled, ok := p.clients[client]
+	delete(p.clients, client)
+	p.mutex.Unlock()
+
+	if ok && pooled.leases <= 1 {
+		p.transport.Close(client)
+	}
+}
+
+// Close hands a lease back to the pool. A connection handed back by its
+// last holder with sessions still open, or that the pool does not own, is
+// closed.
+func (p *Pool) Close(client *SSHClient) error {
+	if client == nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	pooled, ok := p.clients[client]
+	if ok && pooled.leases > 1 {
+		pooled.leases--
+		p.mutex.Unlock()
+		return nil
+	}
+	if ok && !p.shutdown && len(pooled.open) == 0 {
+		// Closing twice leaves an idle connection in the pool
+		pooled.leases = 0
+		pooled.exclusive = false
+		pooled.lastUsed = time.Now()
+		p.mutex.Unlock()
+		return nil
+	}
+	var open []*poolSession
+	if ok {
+		for session := range pooled.open {
+			open = append(open, session)
+		}
+	}
+	delete(p.clients, client)
+	p.mutex.Unlock()
+
+	// The sessions end with the connection
+	err := p.transport.Close(client)
+	for _, session := range open {
+		session.release()
+	}
+	return err
+}
+
+// sweep closes idle connections until the pool is shut down
+func (p *Pool) sweep() {
+	ticker := time.NewTicker(poolSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.evictIdle()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// evictIdle closes connections unused for longer than the idle timeout
+func (p *Pool) evictIdle() {
+	p.mutex.Lock()
+	var expired []*SSHClient
+	for client, pooled := range p.clients {
+		if pooled.leases == 0 && time.Since(pooled.lastUsed) > p.idleTimeout {
+			expired = append(expired, client)
+			delete(p.clients, client)
+		}
+	}
+	p.mutex.Unlock()
+
+	for _, client := range expired {
+		p.transport.Close(client)
+	}
+}
+
+// Shutdown closes the idle connections and stops pooling; connections
+// still held are closed when their last holder hands them back
+func (p *Pool) Shutdown() {
+	p.mutex.Lock()
+	var idle []*SSHClient
+	for client, pooled := range p.clients {
+		if pooled.leases == 0 {
+			idle = append(idle, client)
+			delete(p.clients, client)
+		}
+	}
+	if !p.shutdown {
+		p.shutdown = true
+		close(p.stop)
+	}
+	p.mutex.Unlock()
+
+	for _, client := range idle {
+		p.transport.Close(client)
+	}
+}
+
+// Idle returns the number of pooled connections nobody holds
+func (p *Pool) Idle() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	idle := 0
+	for _, pooled := range p.clients {
+		if pooled.leases == 0 {
+			idle++
+		}
+	}
+	return idle
+}
+
+// acquireSession waits for a session slot on the connection's host and
+// returns the function releasing it. Sessions on connections the pool does
+// not own are not limited.
+func (p *Pool) acquireSession(ctx context.Context, client *SSHClient) (func(), error) {
+	p.mutex.Lock()
+	pooled, ok := p.clients[client]
+	var slots chan struct{}
+	if ok {
+		if slots = p.slots[pooled.host]; slots == nil {
+			slots = make(chan struct{}, p.maxSessions)
+			p.slots[pooled.host] = slots
+		}
+	}
+	p.mutex.Unlock()
+	if !ok {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for a session to %s: %w", pooled.host, ctx.Err())
+	}
+
+	session := &poolSession{pool: p, client: client, slots: slots}
+	p.mutex.Lock()
+	if pooled, ok := p.clients[client]; ok {
+		pooled.open[session] = true
+	}
+	p.mutex.Unlock()
+	return session.release, nil
+}
+
+// ExecuteCommand executes a command in a session slot
+func (p *Pool) ExecuteCommand(ctx context.Context, client *SSHClient, cmd string) (*CommandResult, error) {
+	release, err := p.acquireSession(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.transport.ExecuteCommand(ctx, client, cmd)
+}
+
+// StreamCommand starts a command in a session slot, released when the
+// stream is closed
+func (p *Pool) StreamCommand(ctx context.Context, client *SSHClient, cmd string) (io.ReadCloser, error) {
+	release, err := p.acquireSession(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := p.transport.StreamCommand(ctx, client, cmd)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &pooledReader{ReadCloser: stream, release: release}, nil
+}
+
+// StreamWrite starts a command in a session slot, released when the
+// stream is closed
+func (p *Pool) StreamWrite(ctx context.Context, client *SSHClient, cmd string) (io.WriteCloser, error) {
+	release, err := p.acquireSession(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := p.transport.StreamWrite(ctx, client, cmd)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &pooledWriter{WriteCloser: stream, release: release}, nil
+}
+
+// OpenSFTP starts SFTP in a session slot, released when the SFTP client
+// is closed
+func (p *Pool) OpenSFTP(ctx context.Context, client *SSHClient) (*sftp.Client, error) {
+	release, err := p.acquireSession(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	sftpClient, err := p.transport.OpenSFTP(ctx, client)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	go func() {
+		sftpClient.Wait()
+		release()
+	}()
+	return sftpClient, nil
+}
+
+// pooledReader releases its session slot when closed
+type pooledReader struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *pooledReader) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+// pooledWriter releases its session slot when closed
+type pooledWriter struct {
+	io.WriteCloser
+	release func()
+}
+
+func (w *pooledWriter) Close() error {
+	defer w.release()
+	return w.WriteCloser.Close()
+}
@@ -0,0 +1,272 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// fakeTransport hands out unconnected clients and counts connections
+type fakeTransport struct {
+	mutex    sync.Mutex
+	connects int
+	closed   []*SSHClient
+}
+
+func (f *fakeTransport) Connect(ctx context.Context, config *SSHConfig) (*SSHClient, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.connects++
+	return &SSHClient{config: config}, nil
+}
+
+func (f *fakeTransport) ExecuteCommand(ctx context.Context, client *SSHClient, cmd string) (*CommandResult, error) {
+	return &CommandResult{}, nil
+}
+
+func (f *fakeTransport) StreamCommand(ctx context.Context, client *SSHClient, cmd string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeTransport) StreamWrite(ctx context.Context, client *SSHClient, cmd string) (io.WriteCloser, error) {
+	return nopWriteCloser{io.Discard}, nil
+}
+
+func (f *fakeTransport) OpenSFTP(ctx context.Context, client *SSHClient) (*sftp.Client, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (f *fakeTransport) Close(client *SSHClient) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = append(f.closed, client)
+	return nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func testPool(fake *fakeTransport) *Pool {
+	pool := NewPool(fake)
+	pool.healthy = func(*SSHClient) bool { return true }
+	return pool
+}
+
+func testConfig(host string) *SSHConfig {
+	return &SSHConfig{Host: host, User: "root", Auth: NewPasswordAuth("x")}
+}
+
+func TestPool_Reuse(t *testing.T) {
+	fake := &fakeTransport{}
+	pool := testPool(fake)
+	defer pool.Shutdown()
+	ctx := context.Background()
+
+	// Callers connecting at the same time share the connection
+	first, _ := pool.Connect(ctx, testConfig("db1"))
+	second, _ := pool.Connect(ctx, testConfig("db1"))
+	if first != second {
+		t.Fatal("concurrent callers got separate connections")
+	}
+
+	// It stays leased until both hand it back
+	pool.Close(first)
+	if pool.Idle() != 0 || len(fake.closed) != 0 {
+		t.Fatalf("idle %d, closed %d with a holder left", pool.Idle(), len(fake.closed))
+	}
+	pool.Close(second)
+	if pool.Idle() != 1 || len(fake.closed) != 0 {
+		t.Fatalf("idle %d, closed %d after handing back", pool.Idle(), len(fake.closed))
+	}
+	if again, _ := pool.Connect(ctx, testConfig("db1")); again != first {
+		t.Error("idle connection was not reused")
+	}
+	if other, _ := pool.Connect(ctx, testConfig("db2")); other == first {
+		t.Error("connection reused for another host")
+	}
+	if fake.connects != 2 {
+		t.Errorf("%d connections opened, want 2", fake.connects)
+	}
+}
+
+func TestPool_Exclusive(t *testing.T) {
+	fake := &fakeTransport{}
+	pool := testPool(fake)
+	defer pool.Shutdown()
+	ctx := context.Background()
+
+	// Parallel streams get connections of their own, which aren't shared
+	shared, _ := pool.Connect(ctx, testConfig("db1"))
+	first, _ := pool.Connect(ctx, testConfig("db1").WithExclusive())
+	second, _ := pool.Connect(ctx, testConfig("db1").WithExclusive())
+	if first == shared || second == shared || first == second {
+		t.Fatal("exclusive connection shared")
+	}
+	if again, _ := pool.Connect(ctx, testConfig("db1")); again != shared {
+		t.Error("caller shared an exclusive connection")
+	}
+
+	// Handed back, they are reused like any other
+	pool.Close(first)
+	if again, _ := pool.Connect(ctx, testConfig("db1").WithExclusive()); again != first {
+		t.Error("idle connection was not reused for an exclusive lease")
+	}
+	if fake.connects != 3 {
+		t.Errorf("%d connections opened, want 3", fake.connects)
+	}
+}
+
+func TestPool_HealthAndIdleEviction(t *testing.T) {
+	fake := &fakeTransport{}
+	pool := testPool(fake).WithIdleTimeout(time.Millisecond)
+	defer pool.Shutdown()
+	ctx := context.Background()
+
+	// Idle connections past the timeout are closed
+	client, _ := pool.Connect(ctx, testConfig("db1"))
+	pool.Close(client)
+	time.Sleep(5 * time.Millisecond)
+	pool.evictIdle()
+	if pool.Idle() != 0 || len(fake.closed) != 1 {
+		t.Fatalf("idle %d, closed %d after the idle timeout", pool.Idle(), len(fake.closed))
+	}
+
+	// Dead connections are replaced
+	pool.WithIdleTimeout(time.Hour)
+	client, _ = pool.Connect(ctx, testConfig("db1"))
+	pool.Close(client)
+	pool.healthy = func(*SSHClient) bool { return false }
+	if replaced, _ := pool.Connect(ctx, testConfig("db1")); replaced == client {
+		t.Error("dead connection reused")
+	}
+	if len(fake.closed) != 2 {
+		t.Errorf("closed %d, want the dead connection closed", len(fake.closed))
+	}
+}
+
+func TestPool_SessionLimit(t *testing.T) {
+	fake := &fakeTransport{}
+	pool := testPool(fake).WithMaxSessions(1)
+	defer pool.Shutdown()
+	ctx := context.Background()
+
+	first, _ := pool.Connect(ctx, testConfig("db1"))
+	shared, _ := pool.Connect(ctx, testConfig("db1"))
+	if shared != first {
+		t.Fatal("connection not shared")
+	}
+	stream, err := pool.StreamCommand(ctx, first, "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The host's only slot is taken
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.ExecuteCommand(waitCtx, shared, "true"); err == nil {
+		t.Fatal("session started beyond the limit")
+	}
+
+	// The limit is per host, another connection to it waits too
+	exclusive, _ := pool.Connect(ctx, testConfig("db1").WithExclusive())
+	if exclusive == first {
+		t.Fatal("exclusive connection shared")
+	}
+	waitCtx, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.ExecuteCommand(waitCtx, exclusive, "true"); err == nil {
+		t.Fatal("session on another connection to the host started beyond the limit")
+	}
+	other, _ := pool.Connect(ctx, testConfig("db2"))
+	otherCtx, cancelOther := context.WithTimeout(ctx, time.Second)
+	defer cancelOther()
+	if _, err := pool.ExecuteCommand(otherCtx, other, "true"); err != nil {
+		t.Fatalf("session to another host: %v", err)
+	}
+
+	// Checking a pooled connection takes a slot as well
+	pool.Close(exclusive)
+	waitCtx, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Connect(waitCtx, testConfig("db1").WithExclusive()); err == nil {
+		t.Fatal("connection checked beyond the limit")
+	}
+	if pool.Idle() != 1 {
+		t.Fatalf("idle %d, want the unchecked connection handed back", pool.Idle())
+	}
+
+	stream.Close()
+	if _, err := pool.ExecuteCommand(ctx, shared, "true"); err != nil {
+		t.Fatalf("session after the slot was freed: %v", err)
+	}
+
+	// Handing back a connection with a session open closes it once its
+	// last holder is done, and frees the slot
+	if _, err := pool.StreamWrite(ctx, first, "cat > /dev/null"); err != nil {
+		t.Fatal(err)
+	}
+	pool.Close(shared)
+	if len(fake.closed) != 0 {
+		t.Fatal("connection closed while still held")
+	}
+	pool.Close(first)
+	if len(fake.closed) != 1 || fake.closed[0] != first {
+		t.Fatal("connection with an open session was pooled")
+	}
+	if _, err := pool.ExecuteCommand(ctx, other, "true"); err != nil {
+		t.Fatalf("session after the connection was closed: %v", err)
+	}
+}
+
+func TestPool_Balance(t *testing.T) {
+	fake := &fakeTransport{}
+	pool := testPool(fake)
+	defer pool.Shutdown()
+	ctx := context.Background()
+
+	first, _ := pool.Connect(ctx, testConfig("db1").WithExclusive())
+	second, _ := pool.Connect(ctx, testConfig("db1").WithExclusive())
+	pool.Close(first)
+	pool.Close(second)
+
+	// Callers spread over the idle connections by their holders
+	a, _ := pool.Connect(ctx, testConfig("db1"))
+	b, _ := pool.Connect(ctx, testConfig("db1"))
+	if a == b {
+		t.Fatal("caller shared a connection while another was idle")
+	}
+	if c, _ := pool.Connect(ctx, testConfig("db1")); c != a && c != b {
+		t.Fatal("connection opened while pooled ones were free")
+	}
+	if fake.connects != 2 {
+		t.Errorf("%d connections opened, want 2", fake.connects)
+	}
+}
+
+func TestPool_DeadSharedConnection(t *testing.T) {
+	fake := &fakeTransport{}
+	pool := testPool(fake)
+	defer pool.Shutdown()
+	ctx := context.Background()
+
+	// A dead connection is replaced, its holder closes it when done
+	held, _ := pool.Connect(ctx, testConfig("db1"))
+	pool.healthy = func(*SSHClient) bool { return false }
+	replaced, _ := pool.Connect(ctx, testConfig("db1"))
+	if replaced == held {
+		t.Fatal("dead connection shared")
+	}
+	if len(fake.closed) != 0 {
+		t.Fatal("dead connection closed under its holder")
+	}
+	pool.Close(held)
+	if len(fake.closed) != 1 || fake.closed[0] != held {
+		t.Error("dead connection not closed by its holder")
+	}
+}
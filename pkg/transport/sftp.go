@@ -0,0 +1,28 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// OpenSFTP starts the SFTP subsystem over an existing connection. Large
+// reads and writes are split into concurrent requests.
+func (t *SSHTransport) OpenSFTP(ctx context.Context, client *SSHClient) (*sftp.Client, error) {
+	sftpClient, err := sftp.NewClient(client.client, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		return nil, fmt.Errorf("start sftp: %w", err)
+	}
+	return sftpClient, nil
+}
+
+// SFTPPath converts a remote path to its SFTP form. SFTP resolves relative
+// paths against the home directory, so a leading "~/" is dropped.
+func SFTPPath(path string) string {
+	if path == "~" {
+		return "."
+	}
+	return strings.TrimPrefix(path, "~/")
+}
@@ -0,0 +1,347 @@
+package transport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSHHostSettings holds the ~/.ssh/config settings difpipe uses for a host
+type SSHHostSettings struct {
+	HostName       string
+	Port           int
+	User           string
+	IdentityFiles  []string
+	ProxyJump      string // Comma-separated hops, or "none"
+	ConnectTimeout time.Duration
+}
+
+// SSHConfigFile is a parsed ssh_config file. Host blocks are supported;
+// Match blocks are skipped.
+type SSHConfigFile struct {
+	blocks []sshConfigBlock
+}
+
+// sshConfigBlock is a Host block, or the settings before the first one
+type sshConfigBlock struct {
+	patterns []string // Host patterns, nil for settings that apply to every host
+	skip     bool     // Match blocks never apply
+	options  []sshConfigOption
+}
+
+// sshConfigOption is one keyword line, the keyword lowercased
+type sshConfigOption struct {
+	keyword string
+	args    []string
+}
+
+// maxIncludeDepth limits nested Include directives
+const maxIncludeDepth = 16
+
+// LoadSSHConfig parses an ssh_config file and the files it includes
+func LoadSSHConfig(path string) (*SSHConfigFile, error) {
+	config := &SSHConfigFile{}
+	if err := config.load(path, nil, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ParseSSHConfig parses ssh_config contents. Include directives are
+// resolved relative to ~/.ssh.
+func ParseSSHConfig(r io.Reader) (*SSHConfigFile, error) {
+	config := &SSHConfigFile{}
+	if err := config.parse(r, nil, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// load parses a file into the config, its settings outside Host blocks
+// applying to the hosts of the including block
+func (c *SSHConfigFile) load(path string, patterns []string, depth int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read ssh config: %w", err)
+	}
+	defer file.Close()
+
+	if err := c.parse(file, patterns, depth); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// parse reads keyword lines into blocks
+func (c *SSHConfigFile) parse(r io.Reader, patterns []string, depth int) error {
+	block := sshConfigBlock{patterns: patterns}
+	flush := func() {
+		if len(block.options) > 0 {
+			c.blocks = append(c.blocks, block)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		switch keyword {
+		case "":
+			continue
+		case "host":
+			flush()
+			block = sshConfigBlock{patterns: args}
+		case "match":
+			flush()
+			block = sshConfigBlock{skip: true}
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("line %d: includes nested too deeply", line)
+			}
+			if block.skip {
+				continue
+			}
+			flush()
+			for _, pattern := range args {
+				matches, err := filepath.Glob(sshConfigIncludePath(pattern))
+				if err != nil {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+				for _, match := range matches {
+					if err := c.load(match, block.patterns, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			block = sshConfigBlock{patterns: block.patterns}
+		default:
+			block.options = append(block.options, sshConfigOption{keyword: keyword, args: args})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read ssh config: %w", err)
+	}
+
+	flush()
+	return nil
+}
+
+// splitSSHConfigLine splits a line into its lowercased keyword and
+// arguments, which may be double quoted. The keyword may be followed by "=".
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing == -1 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			args = append(args, rest[1:closing+1])
+			rest = rest[closing+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end == -1 {
+				end = len(rest)
+			}
+			args = append(args, rest[:end])
+			rest = rest[end:]
+		}
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args, nil
+}
+
+// sshConfigIncludePath expands ~ in an Include path and resolves relative
+// paths against ~/.ssh
+func sshConfigIncludePath(pattern string) string {
+	home, _ := os.UserHomeDir()
+	if pattern == "~" || strings.HasPrefix(pattern, "~/") {
+		return filepath.Join(home, pattern[1:])
+	}
+	if filepath.IsAbs(pattern) {
+		return pattern
+	}
+	return filepath.Join(home, ".ssh", pattern)
+}
+
+// matches reports whether a Host block applies to host: a pattern must
+// match and no negated pattern may
+func (b *sshConfigBlock) matches(host string) bool {
+	if b.skip {
+		return false
+	}
+	if b.patterns == nil {
+		return true
+	}
+
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), host); !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// HasHost reports whether a Host line names host without wildcards, so
+// that host:path can be told apart from a local path
+func (c *SSHConfigFile) HasHost(host string) bool {
+	for _, block := range c.blocks {
+		for _, pattern := range block.patterns {
+			if pattern == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lookup returns the settings for a host. As with ssh, the first value
+// found for a keyword wins, except IdentityFile which accumulates.
+func (c *SSHConfigFile) Lookup(host string) SSHHostSettings {
+	var settings SSHHostSettings
+	seen := make(map[string]bool)
+	for _, block := range c.blocks {
+		if !block.matches(host) {
+			continue
+		}
+		for _, option := range block.options {
+			if len(option.args) == 0 {
+				continue
+			}
+			value := option.args[0]
+			if option.keyword == "identityfile" {
+				settings.IdentityFiles = append(settings.IdentityFiles, value)
+				continue
+			}
+			if seen[option.keyword] {
+				continue
+			}
+			seen[option.keyword] = true
+
+			switch option.keyword {
+			case "hostname":
+				settings.HostName = value
+			case "port":
+				settings.Port, _ = strconv.Atoi(value)
+			case "user":
+				settings.User = value
+			case "proxyjump":
+				settings.ProxyJump = value
+			case "connecttimeout":
+				if seconds, err := strconv.Atoi(value); err == nil {
+					settings.ConnectTimeout = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+	}
+
+	// Tokens refer to the host as given and as resolved
+	settings.HostName = expandSSHTokens(settings.HostName, host, "", settings.User)
+	hostName := settings.HostName
+	if hostName == "" {
+		hostName = host
+	}
+	for i, file := range settings.IdentityFiles {
+		settings.IdentityFiles[i] = expandSSHTokens(file, hostName, host, settings.User)
+	}
+	return settings
+}
+
+// expandSSHTokens expands a leading ~ and the %h, %n, %r, %u, %d and %%
+// tokens of ssh_config values
+func expandSSHTokens(value, host, alias, remoteUser string) string {
+	if !strings.ContainsAny(value, "~%") {
+		return value
+	}
+	home, _ := os.UserHomeDir()
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		value = home + value[1:]
+	}
+	if alias == "" {
+		alias = host
+	}
+	if remoteUser == "" {
+		remoteUser = localUser()
+	}
+	return strings.NewReplacer(
+		"%%", "%",
+		"%h", host,
+		"%n", alias,
+		"%r", remoteUser,
+		"%u", localUser(),
+		"%d", home,
+	).Replace(value)
+}
+
+// localUser returns the name of the local user, the login ssh uses when
+// none is configured
+func localUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+var (
+	sshConfigMutex   sync.Mutex
+	sshConfigPath    string
+	sshConfigModTime time.Time
+	sshConfigCache   *SSHConfigFile
+)
+
+// UserSSHConfig returns the parsed ~/.ssh/config, read again when it
+// changes. A missing or unreadable file has no settings.
+func UserSSHConfig() *SSHConfigFile {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return &SSHConfigFile{}
+	}
+	configPath := filepath.Join(home, ".ssh", "config")
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return &SSHConfigFile{}
+	}
+
+	sshConfigMutex.Lock()
+	defer sshConfigMutex.Unlock()
+	if sshConfigCache != nil && sshConfigPath == configPath && sshConfigModTime.Equal(info.ModTime()) {
+		return sshConfigCache
+	}
+
+	config, err := LoadSSHConfig(configPath)
+	if err != nil {
+		config = &SSHConfigFile{}
+	}
+	sshConfigPath, sshConfigModTime, sshConfigCache = configPath, info.ModTime(), config
+	return config
+}
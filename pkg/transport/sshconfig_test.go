@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSSHConfig = `
+# The first value found wins, so defaults go last
+Host backup
+    HostName backup01.example.com
+    Port 2222
+    User archiver
+    IdentityFile ~/.ssh/backup_ed25519
+    ProxyJump ops@bastion.example.com:2200
+
+Host db-*  !db-legacy
+    User = postgres
+    HostName "%h.internal"
+    ConnectTimeout 5
+
+Match exec "true"
+    User nobody
+
+Host *
+    IdentityFile ~/.ssh/id_ed25519
+    User fallback
+    Port 22
+`
+
+func TestSSHConfig_Lookup(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	config, err := ParseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := config.Lookup("backup")
+	want := SSHHostSettings{
+		HostName:      "backup01.example.com",
+		Port:          2222,
+		User:          "archiver",
+		IdentityFiles: []string{filepath.Join(home, ".ssh/backup_ed25519"), filepath.Join(home, ".ssh/id_ed25519")},
+		ProxyJump:     "ops@bastion.example.com:2200",
+	}
+	if !reflect.DeepEqual(backup, want) {
+		t.Errorf("backup = %+v, want %+v", backup, want)
+	}
+
+	db := config.Lookup("db-orders")
+	if db.HostName != "db-orders.internal" || db.User != "postgres" || db.Port != 22 || db.ConnectTimeout != 5*time.Second {
+		t.Errorf("db-orders = %+v", db)
+	}
+
+	// Negated patterns and Match blocks do not apply
+	if legacy := config.Lookup("db-legacy"); legacy.User != "fallback" || legacy.HostName != "" {
+		t.Errorf("db-legacy = %+v", legacy)
+	}
+
+	if !config.HasHost("backup") || config.HasHost("db-orders") {
+		t.Error("HasHost should only report hosts named without wildcards")
+	}
+}
+
+func TestSSHConfig_Include(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".ssh", "conf.d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	included := "Host nas\n    HostName 192.0.2.7\n    User admin\n"
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "conf.d", "nas.conf"), []byte(included), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseSSHConfig(strings.NewReader("Include conf.d/*.conf\nHost *\n    User fallback\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nas := config.Lookup("nas"); nas.HostName != "192.0.2.7" || nas.User != "admin" {
+		t.Errorf("nas = %+v", nas)
+	}
+}
+
+func TestParseRemotePath_SSHConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	config, err := ParseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := parseRemotePath("backup:/srv/dumps", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.String() != "archiver@backup01.example.com:/srv/dumps" || location.Port != 2222 {
+		t.Errorf("location = %s port %d", location, location.Port)
+	}
+	if len(location.Jumps) != 1 || location.Jumps[0].Host != "bastion.example.com" || location.Jumps[0].Port != 2200 || location.Jumps[0].User != "ops" {
+		t.Errorf("jumps = %+v", location.Jumps)
+	}
+
+	// An explicit user wins over the config, ConnectTimeout sets the timeout
+	location, err = parseRemotePath("root@db-orders:/var/lib", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConfig := location.SSHConfig(NewPasswordAuth("x"))
+	if sshConfig.User != "root" || sshConfig.Host != "db-orders.internal" || sshConfig.Timeout != 5*time.Second {
+		t.Errorf("config = %s@%s timeout %s", sshConfig.User, sshConfig.Host, sshConfig.Timeout)
+	}
+	if !strings.HasPrefix(sshConfig.Auth.String(), "identity(") {
+		t.Errorf("auth = %s, want identity files offered", sshConfig.Auth)
+	}
+
+	// host:path needs a host named in the config
+	if _, err := parseRemotePath("db-orders:/var/lib", config); err == nil {
+		t.Error("expected an error for a host only matched by a wildcard")
+	}
+}
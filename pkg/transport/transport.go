@@ -2,9 +2,15 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -22,6 +28,9 @@ type Transport interface {
 	// StreamWrite executes a command and returns a stream for writing input
 	StreamWrite(ctx context.Context, client *SSHClient, cmd string) (io.WriteCloser, error)
 
+	// OpenSFTP starts an SFTP session over a connection
+	OpenSFTP(ctx context.Context, client *SSHClient) (*sftp.Client, error)
+
 	// Close closes an SSH connection
 	Close(client *SSHClient) error
 }
@@ -34,12 +43,54 @@ func New() Transport {
 	return &SSHTransport{}
 }
 
-// Connect establishes an SSH connection
+// Connect establishes an SSH connection, through the configured jump
+// hosts when there are any
 func (t *SSHTransport) Connect(ctx context.Context, config *SSHConfig) (*SSHClient, error) {
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
+	var jumps []*ssh.Client
+	closeJumps := func() {
+		for i := len(jumps) - 1; i >= 0; i-- {
+			jumps[i].Close()
+		}
+	}
+
+	var via *ssh.Client
+	for _, jump := range config.Jumps {
+		client, err := dial(ctx, via, jump)
+		if err != nil {
+			closeJumps()
+			return nil, fmt.Errorf("jump host %s: %w", jump.Host, err)
+		}
+		jumps = append(jumps, client)
+		via = client
+	}
+
+	client, err := dial(ctx, via, config)
+	if err != nil {
+		closeJumps()
+		return nil, err
+	}
+
+	sshClient := &SSHClient{
+		config: config,
+		client: client,
+		jumps:  jumps,
+	}
+
+	// Start keepalive if configured
+	if config.Keepalive > 0 {
+		sshClient.startKeepalive()
+	}
+
+	return sshClient, nil
+}
+
+// dial opens an SSH connection to the host of a validated config, directly
+// or tunneled through via
+func dial(ctx context.Context, via *ssh.Client, config *SSHConfig) (*ssh.Client, error) {
 	// Build SSH client config
 	authMethods, err := config.Auth.SSHAuthMethods()
 	if err != nil {
@@ -54,16 +105,43 @@ func (t *SSHTransport) Connect(ctx context.Context, config *SSHConfig) (*SSHClie
 	}
 
 	// Connect with timeout
-	address := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+
+	// Ask for a key type on record, or the host may present another one
+	// and fail the check
+	if config.hostKeys != nil {
+		if sshConfig.HostKeyAlgorithms, err = config.hostKeys.HostKeyAlgorithms(address); err != nil {
+			return nil, err
+		}
+	}
 
 	// Use context deadline if provided
 	var client *ssh.Client
 	done := make(chan error, 1)
 
 	go func() {
-		var err error
-		client, err = ssh.Dial("tcp", address, sshConfig)
-		done <- err
+		if via == nil {
+			var err error
+			client, err = ssh.Dial("tcp", address, sshConfig)
+			done <- err
+			return
+		}
+
+		// The jump host opens the TCP connection, the handshake runs
+		// through it
+		conn, err := via.Dial("tcp", address)
+		if err != nil {
+			done <- err
+			return
+		}
+		clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, sshConfig)
+		if err != nil {
+			conn.Close()
+			done <- err
+			return
+		}
+		client = ssh.NewClient(clientConn, chans, reqs)
+		done <- nil
 	}()
 
 	select {
@@ -75,17 +153,7 @@ func (t *SSHTransport) Connect(ctx context.Context, config *SSHConfig) (*SSHClie
 		return nil, fmt.Errorf("connection timeout: %w", ctx.Err())
 	}
 
-	sshClient := &SSHClient{
-		config: config,
-		client: client,
-	}
-
-	// Start keepalive if configured
-	if config.Keepalive > 0 {
-		sshClient.startKeepalive()
-	}
-
-	return sshClient, nil
+	return client, nil
 }
 
 // ExecuteCommand executes a command and returns the result
@@ -178,27 +246,21 @@ func (t *SSHTransport) StreamCommand(ctx context.Context, client *SSHClient, cmd
 		return nil, fmt.Errorf("stdout pipe: %w", err)
 	}
 
-	// Get stderr pipe to drain it
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		session.Close()
-		return nil, fmt.Errorf("stderr pipe: %w", err)
-	}
+	// Keep the end of stderr to explain a failed command
+	stderr := &tailBuffer{limit: stderrLimit}
+	session.Stderr = stderr
 
 	if err := session.Start(cmd); err != nil {
 		session.Close()
 		return nil, fmt.Errorf("start command: %w", err)
 	}
 
-	// Drain stderr in background so session can complete
-	go func() {
-		io.Copy(io.Discard, stderr)
-	}()
-
 	// Wrap stdout with session cleanup
 	return &streamReader{
 		reader:  stdout,
 		session: session,
+		cmd:     cmd,
+		stderr:  stderr,
 	}, nil
 }
 
@@ -215,36 +277,22 @@ func (t *SSHTransport) StreamWrite(ctx context.Context, client *SSHClient, cmd s
 		return nil, fmt.Errorf("stdin pipe: %w", err)
 	}
 
-	// Get stdout and stderr pipes to drain them
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		session.Close()
-		return nil, fmt.Errorf("stdout pipe: %w", err)
-	}
-
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		session.Close()
-		return nil, fmt.Errorf("stderr pipe: %w", err)
-	}
+	// Discard stdout, keep the end of stderr to explain a failed command
+	stderr := &tailBuffer{limit: stderrLimit}
+	session.Stdout = io.Discard
+	session.Stderr = stderr
 
 	if err := session.Start(cmd); err != nil {
 		session.Close()
 		return nil, fmt.Errorf("start command: %w", err)
 	}
 
-	// Drain stdout and stderr in background so session can complete
-	go func() {
-		io.Copy(io.Discard, stdout)
-	}()
-	go func() {
-		io.Copy(io.Discard, stderr)
-	}()
-
 	// Wrap stdin with session cleanup
 	return &streamWriter{
 		writer:  stdin,
 		session: session,
+		cmd:     cmd,
+		stderr:  stderr,
 	}, nil
 }
 
@@ -256,28 +304,39 @@ func (t *SSHTransport) Close(client *SSHClient) error {
 
 	client.stopKeepalive()
 
+	var err error
 	if client.client != nil {
-		return client.client.Close()
+		err = client.client.Close()
 	}
 
-	return nil
+	// The jump hosts carry the connection, close them after it
+	for i := len(client.jumps) - 1; i >= 0; i-- {
+		client.jumps[i].Close()
+	}
+
+	return err
 }
 
 // streamReader wraps an io.Reader with session cleanup
 type streamReader struct {
 	reader  io.Reader
 	session *ssh.Session
+	cmd     string
+	stderr  *tailBuffer
 }
 
 func (s *streamReader) Read(p []byte) (n int, err error) {
 	return s.reader.Read(p)
 }
 
+// Close waits for the command to exit and returns a *CommandError if it
+// failed
 func (s *streamReader) Close() error {
 	// Wait for session to complete
 	if s.session != nil {
-		s.session.Wait()
-		return s.session.Close()
+		err := s.session.Wait()
+		s.session.Close()
+		return commandError(s.cmd, err, s.stderr)
 	}
 	return nil
 }
@@ -286,27 +345,97 @@ func (s *streamReader) Close() error {
 type streamWriter struct {
 	writer  io.WriteCloser
 	session *ssh.Session
+	cmd     string
+	stderr  *tailBuffer
 }
 
 func (s *streamWriter) Write(p []byte) (n int, err error) {
 	return s.writer.Write(p)
 }
 
+// Close closes stdin, waits for the command to exit and returns a
+// *CommandError if it failed
 func (s *streamWriter) Close() error {
-	// Close stdin first
-	if err := s.writer.Close(); err != nil {
-		return err
-	}
+	// Closing stdin fails when the command already exited, its exit status
+	// explains why
+	closeErr := s.writer.Close()
 
 	// Wait for session to complete
 	if s.session != nil {
-		s.session.Wait()
-		return s.session.Close()
+		err := s.session.Wait()
+		s.session.Close()
+		if err := commandError(s.cmd, err, s.stderr); err != nil {
+			return err
+		}
 	}
 
+	if closeErr != nil && closeErr != io.EOF {
+		return closeErr
+	}
 	return nil
 }
 
+// stderrLimit is how much of a streamed command's stderr is kept
+const stderrLimit = 4096
+
+// CommandError is returned when a streamed remote command exits
+// unsuccessfully
+type CommandError struct {
+	Command string
+	Status  int    // Exit status, -1 when the command was killed by a signal
+	Stderr  string // End of the command's stderr
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("remote command %q exited with status %d", e.Command, e.Status)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// commandError converts the result of session.Wait into a *CommandError
+func commandError(cmd string, err error, stderr *tailBuffer) error {
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &CommandError{Command: cmd, Status: exitErr.ExitStatus(), Stderr: stderr.String()}
+	}
+	var missing *ssh.ExitMissingError
+	if errors.As(err, &missing) {
+		return &CommandError{Command: cmd, Status: -1, Stderr: stderr.String()}
+	}
+	return fmt.Errorf("wait for command: %w", err)
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	mutex sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept output without surrounding whitespace
+func (b *tailBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.TrimSpace(string(b.buf))
+}
+
 // CommandResult contains the result of a command execution
 type CommandResult struct {
 	Stdout   []byte
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		writes   []string
+		limit    int
+		expected string
+	}{
+		{[]string{"tar: error\n"}, 64, "tar: error"},
+		{[]string{"0123456789"}, 4, "6789"},
+		{[]string{"abc", "def", "ghi"}, 5, "efghi"},
+		{[]string{"first line\n", "  last  \n"}, 9, "last"},
+		{nil, 8, ""},
+	}
+
+	for _, tt := range tests {
+		b := &tailBuffer{limit: tt.limit}
+		for _, w := range tt.writes {
+			if n, err := b.Write([]byte(w)); n != len(w) || err != nil {
+				t.Fatalf("Write(%q) = %d, %v", w, n, err)
+			}
+		}
+		if got := b.String(); got != tt.expected {
+			t.Errorf("writes %q limit %d = %q, want %q", tt.writes, tt.limit, got, tt.expected)
+		}
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	stderr := &tailBuffer{limit: stderrLimit}
+	stderr.Write([]byte("tar: short read\n"))
+
+	if err := commandError("tar -xf -", nil, stderr); err != nil {
+		t.Errorf("success = %v", err)
+	}
+
+	var cmdErr *CommandError
+	err := commandError("tar -xf -", &ssh.ExitMissingError{}, stderr)
+	if !errors.As(err, &cmdErr) || cmdErr.Status != -1 || cmdErr.Stderr != "tar: short read" {
+		t.Errorf("killed = %v", err)
+	}
+	if !strings.Contains(err.Error(), `"tar -xf -" exited with status -1: tar: short read`) {
+		t.Errorf("message = %s", err)
+	}
+
+	err = commandError("tar -xf -", &ssh.ExitError{}, stderr)
+	if !errors.As(err, &cmdErr) || cmdErr.Status != 0 {
+		t.Errorf("exit = %v", err)
+	}
+
+	cause := errors.New("connection lost")
+	err = commandError("tar -xf -", cause, stderr)
+	if errors.As(err, &cmdErr) || !errors.Is(err, cause) {
+		t.Errorf("other error = %v, want it wrapped", err)
+	}
+}